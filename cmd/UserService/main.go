@@ -9,6 +9,7 @@ import (
 	"github.com/go-kratos/kratos/v2/log"
 
 	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/YangZhaoWeblog/UserService/internal/server"
 
 	"github.com/go-kratos/kratos/v2" // 确保 kratos v2 核心包导入
 	"github.com/go-kratos/kratos/v2/config"
@@ -43,20 +44,21 @@ var (
 	// Version is the version of the compiled software.
 	Version string
 
+	configMode string
 	configPath string
 	id, _      = os.Hostname()
 )
 
 func init() {
 	// 从环境变量加载配置
-	configMode := os.Getenv("CONFIG_MODE")
+	configMode = os.Getenv("CONFIG_MODE")
 	if configMode == "" {
 		os.Exit(1)
 	}
 	configPath = filepath.Join("configs", configMode+".user.config.yaml")
 }
 
-func newApp(gs *grpc.Server, hs *http.Server, logger log.Logger) *kratos.App {
+func newApp(gs *grpc.Server, hs *http.Server, retention *server.RetentionJob, logger log.Logger) *kratos.App {
 	return kratos.New(
 		kratos.ID(id),
 		kratos.Name(Name),
@@ -66,6 +68,7 @@ func newApp(gs *grpc.Server, hs *http.Server, logger log.Logger) *kratos.App {
 		kratos.Server(
 			gs,
 			hs,
+			retention,
 		),
 	)
 }
@@ -94,6 +97,14 @@ func main() {
 		panic(err)
 	}
 
+	// 未显式配置运行环境时沿用配置文件的模式, 例如 dev.user.config.yaml 即 dev
+	if bc.App == nil {
+		bc.App = &conf.App{}
+	}
+	if bc.App.Env == "" {
+		bc.App.Env = configMode
+	}
+
 	app, cleanup, err := wireApp(bc.Server, bc.App, bc.Log, bc.Data, bc.Trace)
 	if err != nil {
 		panic(err)
@@ -0,0 +1,220 @@
+package biz
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/applog/v1"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+var (
+	// ErrInvalidCursor 分页游标无法解析
+	ErrInvalidCursor = errors.BadRequest(v1.ErrorReason_INVALID_CURSOR.String(), "invalid cursor")
+)
+
+const (
+	defaultAppLogPageSize = 20
+	maxAppLogPageSize     = 200
+
+	defaultAppLogPurgeBatchSize = 500
+)
+
+// AppLog 是落盘的错误日志, 对应 data/schema 中的 AppLog 表
+type AppLog struct {
+	ID        int
+	Time      time.Time
+	Level     string
+	Msg       string
+	Kind      string
+	Component string
+	Operation string
+	UserID    int64
+	TraceID   string
+	SpanID    string
+	Args      string
+	Code      int
+	Reason    string
+	Stack     string
+	Latency   float64
+	AppName   string
+	Extra     map[string]any
+}
+
+// AppLogFilter 是错误日志的查询条件, 零值字段不参与过滤
+type AppLogFilter struct {
+	StartTime time.Time
+	EndTime   time.Time
+	Code      int
+	Reason    string
+	Operation string
+	TraceID   string
+	UserID    int64
+}
+
+// AppLogCodeCount 是单个错误码的条数
+type AppLogCodeCount struct {
+	Code  int
+	Count int64
+}
+
+// AppLogMatch 按级别与错误码匹配日志, 零值字段匹配所有
+type AppLogMatch struct {
+	Level string
+	Code  int
+}
+
+// AppLogRetentionRule 是一条保留规则
+type AppLogRetentionRule struct {
+	AppLogMatch
+	MaxAge  time.Duration
+	Archive bool
+}
+
+// AppLogRetentionPolicy 是错误日志的保留策略
+// Rules 按顺序匹配, 第一条命中的规则生效; 未命中任何规则的日志按 DefaultMaxAge 清理
+type AppLogRetentionPolicy struct {
+	Rules          []AppLogRetentionRule
+	DefaultMaxAge  time.Duration
+	DefaultArchive bool
+	BatchSize      int
+}
+
+// AppLogRepo 是错误日志仓库接口
+type AppLogRepo interface {
+	BatchSave(context.Context, []*AppLog) error
+	// List 按 ID 倒序返回 ID 小于 beforeID 的日志, beforeID 为 0 表示从最新一条开始
+	List(ctx context.Context, f *AppLogFilter, beforeID int, limit int) ([]*AppLog, error)
+	CountByCode(context.Context, *AppLogFilter) ([]*AppLogCodeCount, error)
+	// ListExpired 按 ID 正序返回早于 before、命中 match 且不命中任何 exclude 的日志
+	ListExpired(ctx context.Context, match AppLogMatch, exclude []AppLogMatch, before time.Time, limit int) ([]*AppLog, error)
+	DeleteByIDs(ctx context.Context, ids []int) (int, error)
+}
+
+// AppLogArchiver 把即将删除的错误日志归档到冷存储
+type AppLogArchiver interface {
+	Archive(context.Context, []*AppLog) error
+}
+
+// AppLogUsecase 是错误日志查询用例
+type AppLogUsecase struct {
+	repo     AppLogRepo
+	archiver AppLogArchiver
+}
+
+// NewAppLogUsecase 创建错误日志查询用例
+func NewAppLogUsecase(repo AppLogRepo, archiver AppLogArchiver) *AppLogUsecase {
+	return &AppLogUsecase{repo: repo, archiver: archiver}
+}
+
+// Search 分页查询错误日志, 返回本页数据与下一页游标, 游标为空表示没有更多
+func (uc *AppLogUsecase) Search(ctx context.Context, f *AppLogFilter, pageSize int, cursor string) ([]*AppLog, string, error) {
+	beforeID, err := decodeAppLogCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if pageSize <= 0 {
+		pageSize = defaultAppLogPageSize
+	}
+	if pageSize > maxAppLogPageSize {
+		pageSize = maxAppLogPageSize
+	}
+
+	// 多取一条用来判断是否还有下一页
+	logs, err := uc.repo.List(ctx, f, beforeID, pageSize+1)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(logs) <= pageSize {
+		return logs, "", nil
+	}
+	logs = logs[:pageSize]
+	return logs, encodeAppLogCursor(logs[pageSize-1].ID), nil
+}
+
+// CountByCode 按错误码聚合条数
+func (uc *AppLogUsecase) CountByCode(ctx context.Context, f *AppLogFilter) ([]*AppLogCodeCount, error) {
+	return uc.repo.CountByCode(ctx, f)
+}
+
+// Purge 按保留策略清理过期的错误日志, 返回删除的条数
+func (uc *AppLogUsecase) Purge(ctx context.Context, p *AppLogRetentionPolicy, now time.Time) (int, error) {
+	var (
+		total   int
+		matched []AppLogMatch
+	)
+	for _, rule := range p.Rules {
+		n, err := uc.purge(ctx, rule, matched, now, p.BatchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		matched = append(matched, rule.AppLogMatch)
+	}
+
+	// 兜底规则: 清理未命中任何规则的日志
+	n, err := uc.purge(ctx, AppLogRetentionRule{MaxAge: p.DefaultMaxAge, Archive: p.DefaultArchive}, matched, now, p.BatchSize)
+	return total + n, err
+}
+
+func (uc *AppLogUsecase) purge(ctx context.Context, rule AppLogRetentionRule, exclude []AppLogMatch, now time.Time, batchSize int) (int, error) {
+	if rule.MaxAge <= 0 {
+		return 0, nil
+	}
+	if batchSize <= 0 {
+		batchSize = defaultAppLogPurgeBatchSize
+	}
+
+	before := now.Add(-rule.MaxAge)
+	total := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		logs, err := uc.repo.ListExpired(ctx, rule.AppLogMatch, exclude, before, batchSize)
+		if err != nil || len(logs) == 0 {
+			return total, err
+		}
+
+		// 先归档再删除, 归档失败时保留数据等下一轮重试
+		if rule.Archive {
+			if err := uc.archiver.Archive(ctx, logs); err != nil {
+				return total, err
+			}
+		}
+		ids := make([]int, 0, len(logs))
+		for _, l := range logs {
+			ids = append(ids, l.ID)
+		}
+		n, err := uc.repo.DeleteByIDs(ctx, ids)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if len(logs) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+func encodeAppLogCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+func decodeAppLogCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(string(b))
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
@@ -0,0 +1,129 @@
+package biz
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestAppLogCursor(t *testing.T) {
+	for _, id := range []int{1, 42, 1 << 40} {
+		got, err := decodeAppLogCursor(encodeAppLogCursor(id))
+		if err != nil || got != id {
+			t.Errorf("round trip %d = %d, %v", id, got, err)
+		}
+	}
+	if id, err := decodeAppLogCursor(""); err != nil || id != 0 {
+		t.Errorf("decode empty = %d, %v, want 0", id, err)
+	}
+
+	tampered := []string{
+		"not base64!",
+		base64.StdEncoding.EncodeToString([]byte("42")), // 带填充的标准编码
+		base64.RawURLEncoding.EncodeToString([]byte("abc")),
+		base64.RawURLEncoding.EncodeToString([]byte("0")),
+		base64.RawURLEncoding.EncodeToString([]byte("-5")),
+		base64.RawURLEncoding.EncodeToString([]byte("42 OR 1=1")),
+	}
+	for _, c := range tampered {
+		if _, err := decodeAppLogCursor(c); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decode %q error = %v, want ErrInvalidCursor", c, err)
+		}
+	}
+}
+
+// pagedAppLogRepo 按 ID 倒序保存 ID 为 1..n 的日志
+type pagedAppLogRepo struct {
+	AppLogRepo
+	n      int
+	limits []int
+}
+
+func (r *pagedAppLogRepo) List(_ context.Context, _ *AppLogFilter, beforeID int, limit int) ([]*AppLog, error) {
+	r.limits = append(r.limits, limit)
+	start := r.n
+	if beforeID > 0 {
+		start = beforeID - 1
+	}
+	var logs []*AppLog
+	for id := start; id >= 1 && len(logs) < limit; id-- {
+		logs = append(logs, &AppLog{ID: id})
+	}
+	return logs, nil
+}
+
+func TestAppLogUsecase_SearchPaging(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		n        int
+		pageSize int
+		want     [][]int
+	}{
+		{"last page is partial", 5, 2, [][]int{{5, 4}, {3, 2}, {1}}},
+		// 总数恰好是页大小的整数倍时, 最后一页不再返回游标
+		{"last page is full", 4, 2, [][]int{{4, 3}, {2, 1}}},
+		{"single page", 3, 10, [][]int{{3, 2, 1}}},
+		{"empty", 0, 10, [][]int{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &pagedAppLogRepo{n: tt.n}
+			uc := NewAppLogUsecase(repo, nil)
+			cursor := ""
+			for i, want := range tt.want {
+				logs, next, err := uc.Search(ctx, &AppLogFilter{}, tt.pageSize, cursor)
+				if err != nil {
+					t.Fatalf("page %d: Search() error = %v", i, err)
+				}
+				if len(logs) != len(want) {
+					t.Fatalf("page %d: got %d logs, want %v", i, len(logs), want)
+				}
+				for j, l := range logs {
+					if l.ID != want[j] {
+						t.Fatalf("page %d: ids = %v, want %v", i, logs, want)
+					}
+				}
+				if last := i == len(tt.want)-1; last != (next == "") {
+					t.Fatalf("page %d: next cursor = %q, last page = %v", i, next, last)
+				}
+				cursor = next
+			}
+			// 每次多取一条用于判断是否还有下一页
+			for _, limit := range repo.limits {
+				if limit != tt.pageSize+1 {
+					t.Errorf("List() limit = %d, want %d", limit, tt.pageSize+1)
+				}
+			}
+		})
+	}
+}
+
+func TestAppLogUsecase_SearchPageSize(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		pageSize int
+		want     int
+	}{
+		{0, defaultAppLogPageSize},
+		{-1, defaultAppLogPageSize},
+		{maxAppLogPageSize + 1, maxAppLogPageSize},
+		{7, 7},
+	}
+	for _, tt := range tests {
+		repo := &pagedAppLogRepo{n: 1000}
+		logs, _, err := NewAppLogUsecase(repo, nil).Search(ctx, &AppLogFilter{}, tt.pageSize, "")
+		if err != nil || len(logs) != tt.want {
+			t.Errorf("Search(pageSize %d) = %d logs, %v, want %d", tt.pageSize, len(logs), err, tt.want)
+		}
+	}
+
+	repo := &pagedAppLogRepo{n: 10}
+	if _, _, err := NewAppLogUsecase(repo, nil).Search(ctx, &AppLogFilter{}, 2, "bogus!"); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("Search(bad cursor) error = %v, want ErrInvalidCursor", err)
+	}
+	if len(repo.limits) != 0 {
+		t.Error("repo queried with an invalid cursor")
+	}
+}
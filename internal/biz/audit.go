@@ -0,0 +1,25 @@
+package biz
+
+import "context"
+
+// 审计事件的动作
+const (
+	AuditIdentityLinked   = "identity.linked"
+	AuditIdentityUnlinked = "identity.unlinked"
+	AuditRefreshReused    = "token.refresh_reused"
+	AuditSessionRevoked   = "session.revoked"
+	AuditSessionEvicted   = "session.evicted"
+	AuditEmailReleased    = "email.released"
+)
+
+// AuditEvent 是一次需要留痕的账号变更
+type AuditEvent struct {
+	Action  string
+	UserID  int64
+	Details map[string]any
+}
+
+// Auditor 记录审计事件, 记录失败不影响业务结果
+type Auditor interface {
+	Audit(ctx context.Context, e *AuditEvent)
+}
@@ -0,0 +1,176 @@
+package biz
+
+import (
+	"context"
+	"unicode/utf8"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+var (
+	// ErrInvalidCredentials 手机号或邮箱未注册或密码错误, 两种情况不做区分
+	ErrInvalidCredentials = errors.Unauthorized(v1.ErrorReason_INVALID_CREDENTIALS.String(), "invalid account or password")
+	// ErrInvalidPassword 密码不满足长度要求
+	ErrInvalidPassword = errors.BadRequest(v1.ErrorReason_INVALID_PASSWORD.String(), "password must be 8 to 64 characters")
+)
+
+const (
+	minPasswordLen = 8
+	maxPasswordLen = 64
+
+	tokenTypeBearer = "Bearer"
+)
+
+// 访问令牌的权限范围
+const (
+	// ScopeUser 普通用户的权限范围, 可以访问自己账号下的全部接口
+	ScopeUser = "user"
+	// ScopeUnverified 邮箱未验证的账号, 只能查看资料、管理会话和重发验证邮件
+	ScopeUnverified = "unverified"
+)
+
+type authKey struct{}
+
+// NewAuthContext 把通过校验的访问令牌声明写入 ctx
+func NewAuthContext(ctx context.Context, claims *pkg.CustomClaims) context.Context {
+	return context.WithValue(ctx, authKey{}, claims)
+}
+
+// AuthFromContext 取出当前请求的访问令牌声明, 公开接口中不存在
+func AuthFromContext(ctx context.Context) (*pkg.CustomClaims, bool) {
+	claims, ok := ctx.Value(authKey{}).(*pkg.CustomClaims)
+	return claims, ok
+}
+
+// LoginWithPassword 手机号 + 密码登录
+func (uc *UserUsecase) LoginWithPassword(ctx context.Context, phone, password string) (*User, error) {
+	return uc.loginWithPassword(ctx, AuthTypePhone, phone, password)
+}
+
+// LoginWithEmail 邮箱 + 密码登录, 邮箱未验证时签发受限的令牌
+func (uc *UserUsecase) LoginWithEmail(ctx context.Context, email, password string) (*User, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		uc.hasher.VerifyDummy(password)
+		return nil, ErrInvalidCredentials
+	}
+	return uc.loginWithPassword(ctx, AuthTypeEmail, email, password)
+}
+
+// loginWithPassword 通过登录方式查找用户并校验密码
+// 参数调整过的旧哈希在登录成功后用当前参数重新哈希
+func (uc *UserUsecase) loginWithPassword(ctx context.Context, provider, subject, password string) (*User, error) {
+	u, err := uc.repo.FindByIdentity(ctx, provider, subject)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+	if u == nil || u.PasswordHash == "" {
+		// 用户不存在或未设置密码时同样计算一次哈希, 不能通过耗时判断账号是否注册
+		uc.hasher.VerifyDummy(password)
+		return nil, ErrInvalidCredentials
+	}
+
+	ok, needsRehash, err := uc.hasher.Verify(password, u.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if needsRehash {
+		// 重新哈希失败不影响本次登录, 下次登录会再次尝试
+		if hash, err := uc.hasher.Hash(password); err == nil {
+			if err := uc.repo.UpdatePassword(ctx, u.ID, hash); err == nil {
+				u.PasswordHash = hash
+			}
+		}
+	}
+
+	if err := uc.issueToken(ctx, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// LoginWithCode 手机号 + 短信验证码登录
+// 手机号未注册时与密码登录一样返回 ErrInvalidCredentials, 不能借此判断手机号是否注册
+func (uc *UserUsecase) LoginWithCode(ctx context.Context, phone, code string) (*User, error) {
+	if err := uc.codes.VerifyCode(ctx, CodePurposeLogin, phone, code); err != nil {
+		return nil, err
+	}
+
+	u, err := uc.repo.FindByIdentity(ctx, AuthTypePhone, phone)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.issueToken(ctx, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// LoginWithProvider 使用第三方 id_token 登录, 以 sub 查找绑定的用户
+// 账号未注册时返回 ErrUserNotFound, 客户端据此引导用户注册
+func (uc *UserUsecase) LoginWithProvider(ctx context.Context, provider, idToken string) (*User, error) {
+	ext, err := uc.idps.Verify(ctx, provider, idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := uc.repo.FindByIdentity(ctx, provider, ext.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.issueToken(ctx, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// issueToken 给用户签发访问令牌与刷新令牌, 每次登录开启一个新的刷新令牌家族, 并记录为一个会话
+func (uc *UserUsecase) issueToken(ctx context.Context, u *User) error {
+	pair, err := uc.jwtCli.GenerateToken(u.ID, u.Nickname, "", tokenScopes(u))
+	if err != nil {
+		return err
+	}
+	if err := uc.tokens.Create(ctx, pair.SessionID, u.ID, pair.RefreshID, uc.jwtCli.RefreshTTL()); err != nil {
+		return err
+	}
+	if err := uc.startSession(ctx, u.ID, pair.SessionID); err != nil {
+		return err
+	}
+	u.AuthToken = uc.authToken(pair)
+	return nil
+}
+
+// tokenScopes 返回签发给用户的权限范围, 刷新时按用户当前状态重新计算
+// 邮箱验证后刷新令牌即可拿到完整权限, 不需要重新登录
+func tokenScopes(u *User) []string {
+	if u.AuthType == AuthTypeEmail && !u.EmailVerified {
+		return []string{ScopeUnverified}
+	}
+	return []string{ScopeUser}
+}
+
+func (uc *UserUsecase) authToken(pair *pkg.TokenPair) AuthToken {
+	return AuthToken{
+		TokenType:    tokenTypeBearer,
+		ExpiresIn:    uc.jwtCli.ExpiresIn(),
+		AccessToken:  pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+	}
+}
+
+// checkPassword 只限制长度, 上限避免超长输入拖慢哈希
+func checkPassword(password string) error {
+	if n := utf8.RuneCountInString(password); n < minPasswordLen || n > maxPasswordLen {
+		return ErrInvalidPassword
+	}
+	return nil
+}
@@ -3,4 +3,6 @@ package biz
 import "github.com/google/wire"
 
 // ProviderSet is biz providers.
-var ProviderSet = wire.NewSet(NewGreeterUsecase, NewUserUsecase)
+var ProviderSet = wire.NewSet(NewGreeterUsecase, NewUserUsecase, NewAppLogUsecase,
+	NewVerificationUsecase, NewIntrospectionUsecase, NewEmailVerificationUsecase,
+	wire.Bind(new(CodeVerifier), new(*VerificationUsecase)), wire.Bind(new(EmailVerifier), new(*EmailVerificationUsecase)))
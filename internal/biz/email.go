@@ -0,0 +1,229 @@
+package biz
+
+import (
+	"context"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+var (
+	// ErrInvalidEmail 邮箱地址格式不正确
+	ErrInvalidEmail = errors.BadRequest(v1.ErrorReason_INVALID_EMAIL.String(), "invalid email address")
+	// ErrInvalidEmailLink 验证链接签名错误、已过期, 或邮箱已不属于该用户
+	ErrInvalidEmailLink = errors.BadRequest(v1.ErrorReason_INVALID_EMAIL_LINK.String(), "invalid or expired verification link")
+	// ErrEmailNotVerified 邮箱未验证的账号只能使用部分接口
+	ErrEmailNotVerified = errors.Forbidden(v1.ErrorReason_EMAIL_NOT_VERIFIED.String(), "email not verified")
+	// ErrEmailAlreadyVerified 邮箱已验证, 或账号不是邮箱注册的, 不需要再发送验证邮件
+	ErrEmailAlreadyVerified = errors.Conflict(v1.ErrorReason_EMAIL_ALREADY_VERIFIED.String(), "email already verified")
+	// ErrEmailTooFrequent 距离上次发送验证邮件的时间小于最小间隔
+	ErrEmailTooFrequent = errors.New(http.StatusTooManyRequests, v1.ErrorReason_EMAIL_TOO_FREQUENT.String(), "verification email requested too frequently")
+)
+
+const (
+	defaultEmailVerifyURL      = "/v1/user/email/verify"
+	defaultEmailLinkTTL        = 24 * time.Hour
+	defaultEmailResendInterval = time.Minute
+	defaultEmailUnverifiedTTL  = 7 * 24 * time.Hour
+)
+
+// EmailSender 发送邮件
+type EmailSender interface {
+	// SendVerificationLink 发送邮箱验证链接, 链接在 ttl 后失效
+	SendVerificationLink(ctx context.Context, email, link string, ttl time.Duration) error
+}
+
+// EmailVerifier 给用户发送邮箱验证链接, 注册时由 UserUsecase 调用
+type EmailVerifier interface {
+	SendVerification(ctx context.Context, userID int64, email string) error
+	// ReleaseStale 释放被过期未验证账号占用的邮箱, 返回被释放的账号 ID, 没有释放时返回 0
+	ReleaseStale(ctx context.Context, email string) (int64, error)
+}
+
+// EmailPolicy 是验证链接的参数, 零值字段使用默认值
+type EmailPolicy struct {
+	VerifyURL      string
+	LinkTTL        time.Duration
+	ResendInterval time.Duration
+	UnverifiedTTL  time.Duration // 注册后超过该时长仍未验证的账号不再占用邮箱, 不短于 LinkTTL
+}
+
+// EmailVerificationUsecase 负责邮箱验证链接的发送与校验, 实现了 EmailVerifier
+// 链接中的令牌是签名的, 不需要存储; 验证成功后再次点击同一链接仍返回成功
+type EmailVerificationUsecase struct {
+	repo   UserRepo
+	sender EmailSender
+	locker Locker
+	jwtCli *pkg.JwtClient
+	policy EmailPolicy
+}
+
+// NewEmailVerificationUsecase 创建邮箱验证用例
+func NewEmailVerificationUsecase(policy *EmailPolicy, repo UserRepo, sender EmailSender, locker Locker,
+	jwtClient *pkg.JwtClient,
+) *EmailVerificationUsecase {
+	p := *policy
+	if p.VerifyURL == "" {
+		p.VerifyURL = defaultEmailVerifyURL
+	}
+	if p.LinkTTL <= 0 {
+		p.LinkTTL = defaultEmailLinkTTL
+	}
+	if p.ResendInterval <= 0 {
+		p.ResendInterval = defaultEmailResendInterval
+	}
+	if p.UnverifiedTTL <= 0 {
+		p.UnverifiedTTL = defaultEmailUnverifiedTTL
+	}
+	// 链接有效期内邮箱不能被释放, 否则用户点开还没过期的链接却验证失败
+	if p.UnverifiedTTL < p.LinkTTL {
+		p.UnverifiedTTL = p.LinkTTL
+	}
+	return &EmailVerificationUsecase{
+		repo:   repo,
+		sender: sender,
+		locker: locker,
+		jwtCli: jwtClient,
+		policy: p,
+	}
+}
+
+// SendVerification 生成验证链接并发送到 email
+// 同一账号在 ResendInterval 内只发送一次, 避免被用来向他人邮箱发送垃圾邮件
+func (uc *EmailVerificationUsecase) SendVerification(ctx context.Context, userID int64, email string) error {
+	// 冷却期内的锁不释放, 到期自动过期
+	_, ok, err := uc.locker.TryLock(ctx, emailCooldownKey(userID), uc.policy.ResendInterval)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrEmailTooFrequent
+	}
+
+	token, err := uc.jwtCli.GenerateEmailToken(userID, email, uc.policy.LinkTTL)
+	if err != nil {
+		return err
+	}
+	link, err := verifyLink(uc.policy.VerifyURL, token)
+	if err != nil {
+		return err
+	}
+	return uc.sender.SendVerificationLink(ctx, email, link, uc.policy.LinkTTL)
+}
+
+// Resend 重新发送验证邮件, 之前发出的链接在过期前仍然有效
+func (uc *EmailVerificationUsecase) Resend(ctx context.Context, userID int64) error {
+	u, err := uc.repo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if u.AuthType != AuthTypeEmail || u.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	email, err := uc.registeredEmail(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return uc.SendVerification(ctx, userID, email)
+}
+
+// VerifyEmail 校验验证链接中的令牌并把邮箱标记为已验证
+// 令牌中的邮箱必须仍绑定在该用户上, 解绑后旧链接失效
+func (uc *EmailVerificationUsecase) VerifyEmail(ctx context.Context, token string) error {
+	claims, err := uc.jwtCli.ParseEmailToken(token)
+	if err != nil {
+		return ErrInvalidEmailLink
+	}
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return ErrInvalidEmailLink
+	}
+
+	u, err := uc.repo.FindByIdentity(ctx, AuthTypeEmail, claims.Email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return ErrInvalidEmailLink
+		}
+		return err
+	}
+	if u.ID != userID {
+		return ErrInvalidEmailLink
+	}
+	if u.EmailVerified {
+		return nil
+	}
+	return uc.repo.MarkEmailVerified(ctx, userID)
+}
+
+// ReleaseStale 邮箱账号注册超过 UnverifiedTTL 仍未验证时视为过期, 解绑其邮箱, 让新的注册可以使用该邮箱
+// 过期账号本身保留, 但没有了邮箱登录方式; 已验证或未过期的账号不受影响
+func (uc *EmailVerificationUsecase) ReleaseStale(ctx context.Context, email string) (int64, error) {
+	u, err := uc.repo.FindByIdentity(ctx, AuthTypeEmail, email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if u.AuthType != AuthTypeEmail || u.EmailVerified || time.Since(u.CreatedAt) < uc.policy.UnverifiedTTL {
+		return 0, nil
+	}
+	if err := uc.repo.RemoveIdentity(ctx, u.ID, AuthTypeEmail, email); err != nil {
+		// 并发的注册已经释放过
+		if errors.Is(err, ErrIdentityNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return u.ID, nil
+}
+
+// registeredEmail 返回用户注册时绑定的邮箱
+func (uc *EmailVerificationUsecase) registeredEmail(ctx context.Context, userID int64) (string, error) {
+	identities, err := uc.repo.ListIdentities(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	for _, id := range identities {
+		if id.Provider == AuthTypeEmail {
+			return id.Subject, nil
+		}
+	}
+	return "", ErrIdentityNotFound
+}
+
+func emailCooldownKey(userID int64) string {
+	return "email:verify:" + strconv.FormatInt(userID, 10)
+}
+
+// verifyLink 把令牌以 token 参数附加到 base 后面, base 中已有的参数保留
+func verifyLink(base, token string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// normalizeEmail 校验邮箱格式并统一为小写, 同一邮箱只能注册一个账号
+// 只接受裸地址, 不接受 "Name <a@b.com>" 这样带显示名的写法
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
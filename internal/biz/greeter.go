@@ -2,15 +2,6 @@ package biz
 
 import (
 	"context"
-
-	v1 "github.com/YangZhaoWeblog/UserService/api/helloworld/v1"
-
-	"github.com/go-kratos/kratos/v2/errors"
-)
-
-var (
-	// ErrUserNotFound is user not found.
-	ErrUserNotFound = errors.NotFound(v1.ErrorReason_USER_NOT_FOUND.String(), "user not found")
 )
 
 // Greeter is a Greeter model.
@@ -0,0 +1,178 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+var (
+	// ErrLastIdentity 不能解绑最后一个登录方式, 否则账号将无法登录
+	ErrLastIdentity = errors.BadRequest(v1.ErrorReason_LAST_IDENTITY.String(), "cannot unlink the last login method")
+	// ErrIdentityChangeInProgress 同一用户的另一个绑定/解绑请求正在处理
+	ErrIdentityChangeInProgress = errors.Conflict(v1.ErrorReason_IDENTITY_CHANGE_IN_PROGRESS.String(), "identity change in progress")
+	// ErrInvalidIdentity 请求中没有指定要绑定的登录方式
+	ErrInvalidIdentity = errors.BadRequest(v1.ErrorReason_INVALID_IDENTITY.String(), "identity is required")
+)
+
+// identityLockTTL 绑定与解绑按用户串行执行, 避免并发解绑把登录方式全部删掉
+const identityLockTTL = 10 * time.Second
+
+func identityLockKey(userID int64) string {
+	return fmt.Sprintf("user:identity:%d", userID)
+}
+
+// LinkPhone 给用户绑定手机号, 需要用途为 link 的新验证码
+func (uc *UserUsecase) LinkPhone(ctx context.Context, userID int64, phone, code string) (*Identity, error) {
+	if err := uc.codes.VerifyCode(ctx, CodePurposeLink, phone, code); err != nil {
+		return nil, err
+	}
+	return uc.link(ctx, &Identity{UserID: userID, Provider: AuthTypePhone, Subject: phone})
+}
+
+// LinkProvider 给用户绑定第三方账号, 以 id_token 中的 sub 作为标识
+func (uc *UserUsecase) LinkProvider(ctx context.Context, userID int64, provider, idToken string) (*Identity, error) {
+	ext, err := uc.idps.Verify(ctx, provider, idToken)
+	if err != nil {
+		return nil, err
+	}
+	return uc.link(ctx, &Identity{UserID: userID, Provider: provider, Subject: ext.Subject, Email: ext.Email})
+}
+
+func (uc *UserUsecase) link(ctx context.Context, id *Identity) (*Identity, error) {
+	unlock, err := uc.lockIdentities(ctx, id.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var linked *Identity
+	err = uc.tx.ExecTx(ctx, func(ctx context.Context) error {
+		u, err := uc.repo.FindByID(ctx, id.UserID)
+		if err != nil {
+			return err
+		}
+		if linked, err = uc.repo.AddIdentity(ctx, id); err != nil {
+			return err
+		}
+
+		// 资料中还没有手机号时顺便写入, 与注册时的行为保持一致
+		if id.Provider == AuthTypePhone && u.Phone.Number == "" {
+			u.Phone.Number = id.Subject
+			if _, err := uc.repo.Update(ctx, u); err != nil {
+				if errors.Is(err, ErrUserAlreadyExists) {
+					return ErrIdentityAlreadyLinked
+				}
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uc.auditor.Audit(ctx, &AuditEvent{
+		Action: AuditIdentityLinked,
+		UserID: id.UserID,
+		Details: map[string]any{
+			"provider": id.Provider,
+			"subject":  maskSubject(id.Provider, id.Subject),
+		},
+	})
+	return linked, nil
+}
+
+// UnlinkIdentity 解绑登录方式, 只剩一个时拒绝
+func (uc *UserUsecase) UnlinkIdentity(ctx context.Context, userID int64, provider, subject string) error {
+	unlock, err := uc.lockIdentities(ctx, userID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = uc.tx.ExecTx(ctx, func(ctx context.Context) error {
+		identities, err := uc.repo.ListIdentities(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !hasIdentity(identities, provider, subject) {
+			return ErrIdentityNotFound
+		}
+		if len(identities) == 1 {
+			return ErrLastIdentity
+		}
+		if err := uc.repo.RemoveIdentity(ctx, userID, provider, subject); err != nil {
+			return err
+		}
+
+		// 解绑的手机号同时从资料中移除, 释放给其他账号使用
+		if provider != AuthTypePhone {
+			return nil
+		}
+		u, err := uc.repo.FindByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if u.Phone.Number == subject {
+			u.Phone.Number = ""
+			_, err = uc.repo.Update(ctx, u)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	uc.auditor.Audit(ctx, &AuditEvent{
+		Action: AuditIdentityUnlinked,
+		UserID: userID,
+		Details: map[string]any{
+			"provider": provider,
+			"subject":  maskSubject(provider, subject),
+		},
+	})
+	return nil
+}
+
+func (uc *UserUsecase) lockIdentities(ctx context.Context, userID int64) (func(), error) {
+	unlock, ok, err := uc.locker.TryLock(ctx, identityLockKey(userID), identityLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrIdentityChangeInProgress
+	}
+	return unlock, nil
+}
+
+func hasIdentity(identities []*Identity, provider, subject string) bool {
+	for _, id := range identities {
+		if id.Provider == provider && id.Subject == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// maskSubject 审计日志中隐去手机号与邮箱的中间部分
+func maskSubject(provider, subject string) string {
+	switch provider {
+	case AuthTypePhone:
+		if len(subject) > 7 {
+			return subject[:3] + strings.Repeat("*", len(subject)-7) + subject[len(subject)-4:]
+		}
+	case AuthTypeEmail:
+		if at := strings.LastIndex(subject, "@"); at > 1 {
+			return subject[:1] + "***" + subject[at:]
+		}
+	default:
+		return subject
+	}
+	return strings.Repeat("*", len(subject))
+}
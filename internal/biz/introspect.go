@@ -0,0 +1,54 @@
+package biz
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+)
+
+// IntrospectionCache 短暂缓存访问令牌的解析结果, 省去重复的验签
+// 只缓存有效的令牌, 实现必须保证条目不晚于令牌的 exp 过期
+type IntrospectionCache interface {
+	Get(token string) (*pkg.CustomClaims, bool)
+	Set(token string, claims *pkg.CustomClaims)
+}
+
+// IntrospectionUsecase 供其他服务查询访问令牌是否有效
+type IntrospectionUsecase struct {
+	jwtCli   *pkg.JwtClient
+	denylist TokenDenylist
+	cache    IntrospectionCache
+}
+
+// NewIntrospectionUsecase new an IntrospectionUsecase.
+func NewIntrospectionUsecase(jwtCli *pkg.JwtClient, denylist TokenDenylist, cache IntrospectionCache) *IntrospectionUsecase {
+	return &IntrospectionUsecase{jwtCli: jwtCli, denylist: denylist, cache: cache}
+}
+
+// Introspect 返回有效访问令牌的声明, 令牌无效时返回 false, 不区分原因
+// 吊销状态每次都重新检查, 注销后立即生效, 不受缓存影响
+func (uc *IntrospectionUsecase) Introspect(ctx context.Context, token string) (*pkg.CustomClaims, bool) {
+	claims, ok := uc.cache.Get(token)
+	if !ok {
+		var err error
+		if claims, err = uc.jwtCli.ParseToken(token); err != nil {
+			return nil, false
+		}
+		uc.cache.Set(token, claims)
+	}
+
+	// 缓存条目不晚于 exp 过期, 这里再检查一次避免边界上的误差
+	if !time.Now().Before(claims.ExpiresAt.Time) {
+		return nil, false
+	}
+	userID, err := strconv.ParseInt(claims.UserID, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	if uc.denylist.IsRevoked(ctx, claims.ID, claims.SessionID, userID, claims.IssuedAt.Time) {
+		return nil, false
+	}
+	return claims, true
+}
@@ -0,0 +1,13 @@
+package biz
+
+import (
+	"context"
+	"time"
+)
+
+// Locker 是分布式锁, 多副本部署时保证后台任务只在一个副本上执行
+type Locker interface {
+	// TryLock 尝试加锁, 锁被其他副本持有时 ok 为 false
+	// 加锁成功后必须调用 unlock 释放, 进程异常退出时锁在 ttl 后自动过期
+	TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(), ok bool, err error)
+}
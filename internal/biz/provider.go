@@ -0,0 +1,60 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+// ErrUnknownProvider 请求的第三方登录提供方未配置
+var ErrUnknownProvider = errors.BadRequest(v1.ErrorReason_UNKNOWN_PROVIDER.String(), "unknown identity provider")
+
+// IdentityProvider 是一个第三方登录提供方, 例如 google、apple
+type IdentityProvider interface {
+	// Name 返回提供方名称, 同时作为 Identity.Provider 与 User.AuthType
+	Name() string
+	IDTokenVerifier
+}
+
+// IsBuiltinAuthType 判断是否为内置的登录方式, 第三方提供方不能使用这些名称
+func IsBuiltinAuthType(name string) bool {
+	switch name {
+	case AuthTypeNone, AuthTypePhone, AuthTypeEmail:
+		return true
+	}
+	return false
+}
+
+// ProviderRegistry 按名称保存已配置的第三方登录提供方
+// 新增提供方只需要增加配置, 不需要修改接口定义
+type ProviderRegistry struct {
+	providers map[string]IdentityProvider
+}
+
+// NewProviderRegistry 创建提供方注册表, 名称为空、重复或与内置登录方式冲突时返回错误
+func NewProviderRegistry(providers ...IdentityProvider) (*ProviderRegistry, error) {
+	r := &ProviderRegistry{providers: make(map[string]IdentityProvider, len(providers))}
+	for _, p := range providers {
+		name := p.Name()
+		if IsBuiltinAuthType(name) {
+			return nil, fmt.Errorf("identity provider name %q is reserved", name)
+		}
+		if _, ok := r.providers[name]; ok {
+			return nil, fmt.Errorf("duplicate identity provider %q", name)
+		}
+		r.providers[name] = p
+	}
+	return r, nil
+}
+
+// Verify 用名为 provider 的提供方校验 id_token, 未配置时返回 ErrUnknownProvider
+func (r *ProviderRegistry) Verify(ctx context.Context, provider, idToken string) (*ExternalIdentity, error) {
+	p, ok := r.providers[provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p.VerifyIDToken(ctx, idToken)
+}
@@ -0,0 +1,111 @@
+package biz
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+// ErrSessionNotFound 会话不存在、已过期或不属于当前用户
+var ErrSessionNotFound = errors.NotFound(v1.ErrorReason_SESSION_NOT_FOUND.String(), "session not found")
+
+// Session 是一次登录产生的会话, ID 即刷新令牌家族 ID, 也是访问令牌中的 sid
+// 会话随家族一起过期, 刷新令牌时更新 LastSeenAt 与 IP
+type Session struct {
+	ID         string
+	UserID     int64
+	DeviceID   string
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+// SessionRepo 保存用户的登录会话
+type SessionRepo interface {
+	// Create 记录新会话, 返回因此被挤掉的会话 ID
+	// 同一设备重新登录时挤掉该设备之前的会话; 设备数超过上限时挤掉最早创建的会话
+	Create(ctx context.Context, s *Session, ttl time.Duration) (evicted []string, err error)
+	// Touch 刷新令牌时更新最近活跃时间与 IP, 会话不存在时不报错
+	Touch(ctx context.Context, userID int64, id, ip string, ttl time.Duration) error
+	// List 按创建时间倒序列出用户的会话
+	List(ctx context.Context, userID int64) ([]*Session, error)
+	// Delete 删除会话, 不存在或不属于该用户时返回 ErrSessionNotFound
+	Delete(ctx context.Context, userID int64, id string) error
+	// DeleteUser 删除用户的所有会话
+	DeleteUser(ctx context.Context, userID int64) error
+}
+
+type clientKey struct{}
+
+// NewClientContext 把客户端信息写入 ctx, 登录与刷新令牌时据此记录会话
+func NewClientContext(ctx context.Context, client ClientInfo) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+func clientFromContext(ctx context.Context) ClientInfo {
+	client, _ := ctx.Value(clientKey{}).(ClientInfo)
+	return client
+}
+
+// ListSessions 列出用户的登录会话
+func (uc *UserUsecase) ListSessions(ctx context.Context, userID int64) ([]*Session, error) {
+	return uc.sessions.List(ctx, userID)
+}
+
+// RevokeSession 注销用户的某个会话, 例如在新手机上注销旧手机
+// 会话的刷新令牌立即失效, 已签发的访问令牌通过黑名单吊销
+func (uc *UserUsecase) RevokeSession(ctx context.Context, userID int64, id string) error {
+	if err := uc.sessions.Delete(ctx, userID, id); err != nil {
+		return err
+	}
+	if err := uc.revokeSession(ctx, id); err != nil {
+		return err
+	}
+	uc.auditor.Audit(ctx, &AuditEvent{
+		Action:  AuditSessionRevoked,
+		UserID:  userID,
+		Details: map[string]any{"session_id": id},
+	})
+	return nil
+}
+
+// startSession 记录登录会话, 并注销被挤掉的旧会话
+func (uc *UserUsecase) startSession(ctx context.Context, userID int64, id string) error {
+	client := clientFromContext(ctx)
+	now := time.Now()
+	evicted, err := uc.sessions.Create(ctx, &Session{
+		ID:         id,
+		UserID:     userID,
+		DeviceID:   client.DeviceID,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}, uc.jwtCli.RefreshTTL())
+	if err != nil {
+		return err
+	}
+	for _, old := range evicted {
+		if err := uc.revokeSession(ctx, old); err != nil {
+			return err
+		}
+		uc.auditor.Audit(ctx, &AuditEvent{
+			Action:  AuditSessionEvicted,
+			UserID:  userID,
+			Details: map[string]any{"session_id": old, "by": id},
+		})
+	}
+	return nil
+}
+
+// revokeSession 撤销会话的刷新令牌家族, 并吊销会话中尚未过期的访问令牌
+func (uc *UserUsecase) revokeSession(ctx context.Context, id string) error {
+	if err := uc.tokens.Revoke(ctx, id); err != nil {
+		return err
+	}
+	return uc.denylist.RevokeSession(ctx, id, time.Duration(uc.jwtCli.ExpiresIn())*time.Second)
+}
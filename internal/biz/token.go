@@ -0,0 +1,189 @@
+package biz
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+var (
+	// ErrInvalidRefreshToken 刷新令牌无效、已过期或所属家族已被撤销
+	ErrInvalidRefreshToken = errors.Unauthorized(v1.ErrorReason_INVALID_REFRESH_TOKEN.String(), "invalid refresh token")
+	// ErrRefreshTokenReused 已使用过的刷新令牌被再次提交, 令牌可能泄露, 整个家族与会话已撤销
+	ErrRefreshTokenReused = errors.Unauthorized(v1.ErrorReason_REFRESH_TOKEN_REUSED.String(), "refresh token reused")
+	// ErrRefreshTokenSuperseded 刷新令牌刚被并发的请求轮换过, 客户端应使用那次请求拿到的新令牌
+	ErrRefreshTokenSuperseded = errors.Conflict(v1.ErrorReason_REFRESH_TOKEN_SUPERSEDED.String(), "refresh token superseded")
+	// ErrInvalidAccessToken 访问令牌缺失、签名错误或声明不符
+	ErrInvalidAccessToken = errors.Unauthorized(v1.ErrorReason_INVALID_ACCESS_TOKEN.String(), "invalid access token")
+	// ErrTokenExpired 访问令牌已过期, 客户端应使用刷新令牌换取新令牌
+	ErrTokenExpired = errors.Unauthorized(v1.ErrorReason_TOKEN_EXPIRED.String(), "token expired")
+	// ErrTokenMalformed 访问令牌不是合法的 JWT
+	ErrTokenMalformed = errors.Unauthorized(v1.ErrorReason_TOKEN_MALFORMED.String(), "token malformed")
+	// ErrTokenAudienceMismatch 访问令牌不是签发给本服务的
+	ErrTokenAudienceMismatch = errors.Unauthorized(v1.ErrorReason_TOKEN_AUDIENCE_MISMATCH.String(), "token audience mismatch")
+	// ErrTokenRevoked 访问令牌已注销
+	ErrTokenRevoked = errors.Unauthorized(v1.ErrorReason_TOKEN_REVOKED.String(), "token revoked")
+	// ErrPermissionDenied 只能查看和修改自己的账号
+	ErrPermissionDenied = errors.Forbidden(v1.ErrorReason_PERMISSION_DENIED.String(), "permission denied")
+)
+
+// RefreshTokenRepo 记录刷新令牌家族
+// 一次登录签发的刷新令牌及其轮换出的后续令牌属于同一家族, 任意时刻只有最新的一个有效
+type RefreshTokenRepo interface {
+	// Create 新建家族, tokenID 为家族当前有效的刷新令牌
+	Create(ctx context.Context, family string, userID int64, tokenID string, ttl time.Duration) error
+	// Rotate 把家族的当前令牌从 oldID 换成 newID, 并把家族有效期重置为 ttl
+	// 家族不存在或不属于该用户时返回 ErrInvalidRefreshToken
+	// oldID 是上一个令牌且刚轮换不久(并发刷新)时不做修改, 返回 ErrRefreshTokenSuperseded
+	// 其余 oldID 不是当前令牌(已被使用过)的情况撤销整个家族并返回 ErrRefreshTokenReused
+	Rotate(ctx context.Context, family string, userID int64, oldID, newID string, ttl time.Duration) error
+	// Revoke 撤销整个家族, 家族不存在时不报错
+	Revoke(ctx context.Context, family string) error
+	// RevokeUser 撤销用户的所有家族
+	RevokeUser(ctx context.Context, userID int64) error
+}
+
+// TokenDenylist 是访问令牌黑名单, 用于在 exp 之前让访问令牌失效
+type TokenDenylist interface {
+	// Revoke 吊销单个访问令牌, 记录保留到令牌的 exp
+	Revoke(ctx context.Context, jti string, exp time.Time) error
+	// RevokeUser 吊销用户在 before 之前签发的全部访问令牌, 记录保留 ttl
+	RevokeUser(ctx context.Context, userID int64, before time.Time, ttl time.Duration) error
+	// RevokeSession 吊销会话 sid 签发的全部访问令牌, 记录保留 ttl
+	RevokeSession(ctx context.Context, sid string, ttl time.Duration) error
+	// IsRevoked 判断访问令牌是否已被吊销, 每个请求都会调用, 实现必须足够快
+	IsRevoked(ctx context.Context, jti, sid string, userID int64, issuedAt time.Time) bool
+}
+
+// RefreshToken 用刷新令牌换取新的令牌, 旧刷新令牌随即失效
+// 同一个刷新令牌只能使用一次: 客户端并发刷新时只有一个请求成功, 其余请求在宽限期内返回
+// ErrRefreshTokenSuperseded 且不影响家族; 宽限期之后再次使用视为令牌泄露, 撤销整个家族与会话
+func (uc *UserUsecase) RefreshToken(ctx context.Context, refreshToken string) (*User, error) {
+	claims, err := uc.jwtCli.ParseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	u, err := uc.repo.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	pair, err := uc.jwtCli.GenerateToken(u.ID, u.Nickname, claims.SessionID, tokenScopes(u))
+	if err != nil {
+		return nil, err
+	}
+	err = uc.tokens.Rotate(ctx, claims.SessionID, u.ID, claims.ID, pair.RefreshID, uc.jwtCli.RefreshTTL())
+	if errors.Is(err, ErrRefreshTokenReused) {
+		return nil, uc.refreshReused(ctx, u.ID, claims.SessionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.sessions.Touch(ctx, u.ID, claims.SessionID, clientFromContext(ctx).IP, uc.jwtCli.RefreshTTL()); err != nil {
+		return nil, err
+	}
+
+	u.AuthToken = uc.authToken(pair)
+	return u, nil
+}
+
+// refreshReused 处理刷新令牌被重复使用: 令牌可能已泄露, 除刷新令牌家族外
+// 还要吊销会话中已签发的访问令牌并删除会话记录, 持有泄露令牌的一方立即失去访问权限
+func (uc *UserUsecase) refreshReused(ctx context.Context, userID int64, sessionID string) error {
+	uc.auditor.Audit(ctx, &AuditEvent{
+		Action:  AuditRefreshReused,
+		UserID:  userID,
+		Details: map[string]any{"session_id": sessionID},
+	})
+	if err := uc.revokeSession(ctx, sessionID); err != nil {
+		return err
+	}
+	if err := uc.sessions.Delete(ctx, userID, sessionID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// ValidateAccessToken 校验访问令牌的签名与声明, 以及是否已被注销
+// 过期、格式错误、受众不符与已注销分别返回不同的错误原因
+func (uc *UserUsecase) ValidateAccessToken(ctx context.Context, accessToken string) (*pkg.CustomClaims, error) {
+	claims, err := uc.jwtCli.ParseToken(accessToken)
+	if err != nil {
+		return nil, accessTokenError(err)
+	}
+	userID, err := strconv.ParseInt(claims.UserID, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	if uc.denylist.IsRevoked(ctx, claims.ID, claims.SessionID, userID, claims.IssuedAt.Time) {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// accessTokenError 把解析错误转换为对应的错误原因
+func accessTokenError(err error) error {
+	switch {
+	case errors.Is(err, pkg.ErrTokenExpired):
+		return ErrTokenExpired
+	case errors.Is(err, pkg.ErrTokenMalformed):
+		return ErrTokenMalformed
+	case errors.Is(err, pkg.ErrTokenAudience):
+		return ErrTokenAudienceMismatch
+	default:
+		return ErrInvalidAccessToken
+	}
+}
+
+// Logout 注销当前会话: 吊销访问令牌, 并撤销同一次登录签发的刷新令牌
+func (uc *UserUsecase) Logout(ctx context.Context, claims *pkg.CustomClaims) error {
+	if claims.SessionID != "" {
+		if err := uc.tokens.Revoke(ctx, claims.SessionID); err != nil {
+			return err
+		}
+		userID, _ := strconv.ParseInt(claims.UserID, 10, 64)
+		if err := uc.sessions.Delete(ctx, userID, claims.SessionID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			return err
+		}
+	}
+	return uc.denylist.Revoke(ctx, claims.ID, claims.ExpiresAt.Time)
+}
+
+// LogoutAll 注销用户的所有会话, 包括其他设备
+func (uc *UserUsecase) LogoutAll(ctx context.Context, claims *pkg.CustomClaims) error {
+	userID, err := strconv.ParseInt(claims.UserID, 10, 64)
+	if err != nil {
+		return ErrInvalidAccessToken
+	}
+
+	if err := uc.revokeUser(ctx, userID, time.Now()); err != nil {
+		return err
+	}
+	// iat 精确到秒, 与注销同一秒签发的令牌不在上面的范围内, 当前令牌单独吊销
+	return uc.denylist.Revoke(ctx, claims.ID, claims.ExpiresAt.Time)
+}
+
+// revokeUser 撤销用户的全部刷新令牌家族与会话, 并吊销 before 之前签发的访问令牌
+func (uc *UserUsecase) revokeUser(ctx context.Context, userID int64, before time.Time) error {
+	if err := uc.tokens.RevokeUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := uc.sessions.DeleteUser(ctx, userID); err != nil {
+		return err
+	}
+	ttl := time.Duration(uc.jwtCli.ExpiresIn()) * time.Second
+	return uc.denylist.RevokeUser(ctx, userID, before, ttl)
+}
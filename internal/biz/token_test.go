@@ -0,0 +1,219 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+)
+
+// fakeUserRepo 只支持按 ID 查找
+type fakeUserRepo struct {
+	UserRepo
+	users map[int64]*User
+}
+
+func (r *fakeUserRepo) FindByID(_ context.Context, id int64) (*User, error) {
+	u, ok := r.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	cp := *u
+	return &cp, nil
+}
+
+// fakeRefreshTokenRepo 每个家族只记当前令牌, 旧令牌再次使用时撤销家族
+type fakeRefreshTokenRepo struct {
+	RefreshTokenRepo
+	families map[string]string
+}
+
+func (r *fakeRefreshTokenRepo) Create(_ context.Context, family string, _ int64, tokenID string, _ time.Duration) error {
+	r.families[family] = tokenID
+	return nil
+}
+
+func (r *fakeRefreshTokenRepo) Rotate(_ context.Context, family string, _ int64, oldID, newID string, _ time.Duration) error {
+	current, ok := r.families[family]
+	if !ok {
+		return ErrInvalidRefreshToken
+	}
+	if current != oldID {
+		delete(r.families, family)
+		return ErrRefreshTokenReused
+	}
+	r.families[family] = newID
+	return nil
+}
+
+func (r *fakeRefreshTokenRepo) Revoke(_ context.Context, family string) error {
+	delete(r.families, family)
+	return nil
+}
+
+// fakeSessionRepo 记录会话 ID 与所属用户
+type fakeSessionRepo struct {
+	SessionRepo
+	sessions map[string]int64
+}
+
+func (r *fakeSessionRepo) Touch(context.Context, int64, string, string, time.Duration) error {
+	return nil
+}
+
+func (r *fakeSessionRepo) Delete(_ context.Context, userID int64, id string) error {
+	if owner, ok := r.sessions[id]; !ok || owner != userID {
+		return ErrSessionNotFound
+	}
+	delete(r.sessions, id)
+	return nil
+}
+
+// fakeDenylist 只支持按会话吊销
+type fakeDenylist struct {
+	TokenDenylist
+	sessions map[string]bool
+}
+
+func (d *fakeDenylist) RevokeSession(_ context.Context, sid string, _ time.Duration) error {
+	d.sessions[sid] = true
+	return nil
+}
+
+func (d *fakeDenylist) IsRevoked(_ context.Context, _, sid string, _ int64, _ time.Time) bool {
+	return d.sessions[sid]
+}
+
+type recordingAuditor struct {
+	events []*AuditEvent
+}
+
+func (a *recordingAuditor) Audit(_ context.Context, e *AuditEvent) {
+	a.events = append(a.events, e)
+}
+
+type tokenTestEnv struct {
+	uc       *UserUsecase
+	tokens   *fakeRefreshTokenRepo
+	sessions *fakeSessionRepo
+	denylist *fakeDenylist
+	auditor  *recordingAuditor
+}
+
+func newTokenTestEnv(t *testing.T) *tokenTestEnv {
+	t.Helper()
+	jwtCli, err := pkg.NewClient(&conf.Data{Jwt: &conf.Data_Jwt{SigningKey: "secret", ExpiresTime: 60}})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	env := &tokenTestEnv{
+		tokens:   &fakeRefreshTokenRepo{families: make(map[string]string)},
+		sessions: &fakeSessionRepo{sessions: make(map[string]int64)},
+		denylist: &fakeDenylist{sessions: make(map[string]bool)},
+		auditor:  &recordingAuditor{},
+	}
+	env.uc = &UserUsecase{
+		repo:     &fakeUserRepo{users: map[int64]*User{1: {ID: 1, Nickname: "alice", EmailVerified: true}}},
+		tokens:   env.tokens,
+		sessions: env.sessions,
+		denylist: env.denylist,
+		auditor:  env.auditor,
+		jwtCli:   jwtCli,
+	}
+	return env
+}
+
+// login 签发一个新会话的令牌, 等同于登录成功
+func (env *tokenTestEnv) login(t *testing.T) *pkg.TokenPair {
+	t.Helper()
+	pair, err := env.uc.jwtCli.GenerateToken(1, "alice", "", []string{ScopeUser})
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+	env.tokens.families[pair.SessionID] = pair.RefreshID
+	env.sessions.sessions[pair.SessionID] = 1
+	return pair
+}
+
+func TestRefreshToken_ReuseRevokesSession(t *testing.T) {
+	ctx := context.Background()
+	env := newTokenTestEnv(t)
+	first := env.login(t)
+	other := env.login(t)
+
+	u, err := env.uc.RefreshToken(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshToken() error = %v", err)
+	}
+	rotated := u.AuthToken
+	if _, err := env.uc.ValidateAccessToken(ctx, rotated.AccessToken); err != nil {
+		t.Fatalf("ValidateAccessToken() after refresh error = %v", err)
+	}
+
+	// 再次提交已使用过的刷新令牌
+	if _, err := env.uc.RefreshToken(ctx, first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("RefreshToken() reused error = %v, want ErrRefreshTokenReused", err)
+	}
+
+	// 会话中已签发的访问令牌立即失效, 轮换出的刷新令牌也不能再用
+	if _, err := env.uc.ValidateAccessToken(ctx, first.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("ValidateAccessToken(original) error = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := env.uc.ValidateAccessToken(ctx, rotated.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("ValidateAccessToken(rotated) error = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := env.uc.RefreshToken(ctx, rotated.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("RefreshToken(rotated) error = %v, want ErrInvalidRefreshToken", err)
+	}
+	// 会话从列表中删除
+	if _, ok := env.sessions.sessions[first.SessionID]; ok {
+		t.Error("session still listed after refresh token reuse")
+	}
+	if len(env.auditor.events) != 1 || env.auditor.events[0].Action != AuditRefreshReused {
+		t.Errorf("audit events = %+v, want one %s", env.auditor.events, AuditRefreshReused)
+	}
+
+	// 同一用户的其他会话不受影响
+	if _, err := env.uc.ValidateAccessToken(ctx, other.AccessToken); err != nil {
+		t.Errorf("ValidateAccessToken(other session) error = %v", err)
+	}
+	if _, ok := env.sessions.sessions[other.SessionID]; !ok {
+		t.Error("other session deleted")
+	}
+}
+
+func TestRefreshToken_ReuseWithoutSessionRecord(t *testing.T) {
+	ctx := context.Background()
+	env := newTokenTestEnv(t)
+	pair := env.login(t)
+	// 会话记录已被删除或过期, 仍然吊销访问令牌
+	delete(env.sessions.sessions, pair.SessionID)
+
+	if _, err := env.uc.RefreshToken(ctx, pair.RefreshToken); err != nil {
+		t.Fatalf("RefreshToken() error = %v", err)
+	}
+	if _, err := env.uc.RefreshToken(ctx, pair.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("RefreshToken() reused error = %v, want ErrRefreshTokenReused", err)
+	}
+	if !env.denylist.sessions[pair.SessionID] {
+		t.Error("session not revoked")
+	}
+}
+
+func TestRefreshToken_InvalidDoesNotRevoke(t *testing.T) {
+	ctx := context.Background()
+	env := newTokenTestEnv(t)
+	pair := env.login(t)
+
+	for _, token := range []string{"not-a-jwt", pair.AccessToken} {
+		if _, err := env.uc.RefreshToken(ctx, token); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("RefreshToken(%.10s) error = %v, want ErrInvalidRefreshToken", token, err)
+		}
+	}
+	if len(env.denylist.sessions) != 0 || len(env.sessions.sessions) != 1 || len(env.auditor.events) != 0 {
+		t.Errorf("invalid refresh token revoked the session")
+	}
+}
@@ -0,0 +1,11 @@
+package biz
+
+import "context"
+
+// Transaction 是事务管理器, biz 层借助它把多个仓库的写操作放进同一个事务
+type Transaction interface {
+	// ExecTx 在事务中执行 fn, fn 返回错误或 panic 时回滚, 否则提交
+	// fn 内必须使用传入的 ctx 调用仓库, 仓库会从 ctx 中取出事务
+	// 嵌套调用时加入外层事务, 由最外层统一提交
+	ExecTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
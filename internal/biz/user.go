@@ -2,7 +2,24 @@ package biz
 
 import (
 	"context"
+	"time"
+
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
 	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+var (
+	// ErrUserNotFound is user not found.
+	ErrUserNotFound = errors.NotFound(v1.ErrorReason_USER_NOT_FOUND.String(), "user not found")
+	// ErrUserAlreadyExists 手机号或用户名已被占用
+	ErrUserAlreadyExists = errors.Conflict(v1.ErrorReason_USER_ALREADY_EXISTS.String(), "user already exists")
+	// ErrIdentityAlreadyLinked 该登录方式已绑定到某个用户
+	ErrIdentityAlreadyLinked = errors.Conflict(v1.ErrorReason_IDENTITY_ALREADY_LINKED.String(), "identity already linked")
+	// ErrIdentityNotFound 用户没有绑定该登录方式
+	ErrIdentityNotFound = errors.NotFound(v1.ErrorReason_IDENTITY_NOT_FOUND.String(), "identity not found")
 )
 
 // User 是用户领域模型
@@ -12,10 +29,20 @@ type User struct {
 	Nickname string
 	Avatar   string
 
-	AuthType string // 通过什么方式注册的
+	AuthType string // 通过什么方式注册的: phone, email 或第三方提供方名称
 	Phone    Phone
 
+	Email         string // 注册邮箱, 只在注册请求内使用, 落库的是 Identity
+	EmailVerified bool   // 邮箱注册的账号是否已点击验证链接
+
+	Password     string // 明文密码, 只在注册请求内使用, 不会落库
+	IDToken      string // 第三方登录凭证, 只在注册请求内使用, 不会落库
+	PasswordHash string // argon2id PHC 字符串, 不进缓存
+
 	AuthToken AuthToken
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 type AuthToken struct {
@@ -30,32 +57,87 @@ type Phone struct {
 	VerificationCode string
 }
 
+// Identity 是用户绑定的一种登录方式, (Provider, Subject) 全局唯一
+// 同一个人先用 Google 注册、再绑定手机号, 两个 Identity 指向同一个用户
+type Identity struct {
+	UserID    int64
+	Provider  string // 取值同 AuthType: phone, email 或第三方提供方名称, 如 google
+	Subject   string // 该方式下的唯一标识: 手机号、Google 账号的 sub、邮箱
+	Email     string // 第三方账号提供的已验证邮箱, 可为空
+	CreatedAt time.Time
+}
+
 // UserRepo 是用户仓库接口
+// 查不到用户时返回 ErrUserNotFound, 手机号或用户名冲突时返回 ErrUserAlreadyExists
 type UserRepo interface {
 	Save(context.Context, *User) (*User, error)
 	Update(context.Context, *User) (*User, error)
 	FindByID(context.Context, int64) (*User, error)
 	FindByPhone(context.Context, string) (*User, error)
 	FindByUsername(context.Context, string) (*User, error)
+
+	// FindByIdentity 通过登录方式查找用户
+	FindByIdentity(ctx context.Context, provider, subject string) (*User, error)
+	// AddIdentity 给用户绑定登录方式, 已被绑定时返回 ErrIdentityAlreadyLinked
+	AddIdentity(context.Context, *Identity) (*Identity, error)
+	// RemoveIdentity 解绑登录方式, 未绑定时返回 ErrIdentityNotFound
+	RemoveIdentity(ctx context.Context, userID int64, provider, subject string) error
+	// ListIdentities 按绑定时间顺序列出用户的所有登录方式
+	ListIdentities(ctx context.Context, userID int64) ([]*Identity, error)
+
+	// UpdatePassword 只更新密码哈希, Update 不会修改密码
+	UpdatePassword(ctx context.Context, userID int64, passwordHash string) error
+	// MarkEmailVerified 把邮箱标记为已验证, Update 不会修改该状态
+	MarkEmailVerified(ctx context.Context, userID int64) error
 }
 
 // UserUsecase 是用户用例
 type UserUsecase struct {
-	repo   UserRepo
-	jwtCli *pkg.JwtClient
+	repo     UserRepo
+	tx       Transaction
+	codes    CodeVerifier
+	emails   EmailVerifier
+	idps     *ProviderRegistry
+	locker   Locker
+	auditor  Auditor
+	tokens   RefreshTokenRepo
+	sessions SessionRepo
+	denylist TokenDenylist
+	jwtCli   *pkg.JwtClient
+	idGen    *pkg.IDGenerator
+	hasher   *pkg.PasswordHasher
+
+	logHelper *takin_log.TakinLogger
 }
 
 // NewUserUsecase 创建用户用例
-func NewUserUsecase(repo UserRepo, jwtClient *pkg.JwtClient) *UserUsecase {
+func NewUserUsecase(repo UserRepo, tx Transaction, codes CodeVerifier, emails EmailVerifier, idps *ProviderRegistry,
+	locker Locker, auditor Auditor, tokens RefreshTokenRepo, sessions SessionRepo, denylist TokenDenylist,
+	jwtClient *pkg.JwtClient, idGen *pkg.IDGenerator, hasher *pkg.PasswordHasher, logHelper *takin_log.TakinLogger,
+) *UserUsecase {
 	return &UserUsecase{
-		repo:   repo,
-		jwtCli: jwtClient,
+		repo:     repo,
+		tx:       tx,
+		codes:    codes,
+		emails:   emails,
+		idps:     idps,
+		locker:   locker,
+		auditor:  auditor,
+		tokens:   tokens,
+		sessions: sessions,
+		denylist: denylist,
+		jwtCli:   jwtClient,
+		idGen:    idGen,
+		hasher:   hasher,
+
+		logHelper: logHelper,
 	}
 }
 
 const (
 	AuthTypePhone  string = "phone"
 	AuthTypeGoogle string = "google"
+	AuthTypeEmail  string = "email"
 	AuthTypeNone   string = ""
 )
 
@@ -64,30 +146,118 @@ func (uc *UserUsecase) CreateUser(ctx context.Context, u *User) (*User, error) {
 	var err error
 	var createdUser *User
 
-	// 1. 创建用户
+	// 1. 分配用户 ID, 不依赖数据库自增, 多副本下也不会冲突
+	if u.ID, err = uc.idGen.NextID(); err != nil {
+		return nil, err
+	}
+
+	// 2. 创建用户
 	switch u.AuthType {
 	case AuthTypePhone:
-		createdUser, err = uc.repo.Save(ctx, u)
-	case AuthTypeGoogle:
-		createdUser, err = uc.repo.Save(ctx, u)
-		return nil, nil
+		if err := checkPassword(u.Password); err != nil {
+			return nil, err
+		}
+		if err := uc.codes.VerifyCode(ctx, CodePurposeRegister, u.Phone.Number, u.Phone.VerificationCode); err != nil {
+			return nil, err
+		}
+		if u.PasswordHash, err = uc.hasher.Hash(u.Password); err != nil {
+			return nil, err
+		}
+		u.Password = ""
+
+		createdUser, err = uc.saveWithIdentity(ctx, u, &Identity{Provider: AuthTypePhone, Subject: u.Phone.Number})
+	case AuthTypeEmail:
+		if u.Email, err = normalizeEmail(u.Email); err != nil {
+			return nil, err
+		}
+		if err := checkPassword(u.Password); err != nil {
+			return nil, err
+		}
+		if u.PasswordHash, err = uc.hasher.Hash(u.Password); err != nil {
+			return nil, err
+		}
+		u.Password = ""
+		u.EmailVerified = false
+		if err := uc.releaseStaleEmail(ctx, u.Email); err != nil {
+			return nil, err
+		}
+
+		createdUser, err = uc.saveWithIdentity(ctx, u, &Identity{Provider: AuthTypeEmail, Subject: u.Email})
+	case AuthTypeNone:
+		return nil, ErrInvalidIdentity
+	default:
+		// 其余均为第三方提供方, 按名称路由到对应的校验器
+		var ext *ExternalIdentity
+		if ext, err = uc.idps.Verify(ctx, u.AuthType, u.IDToken); err != nil {
+			return nil, err
+		}
+		u.IDToken = ""
+		// 没有填写昵称和头像时使用第三方账号的资料
+		if u.Nickname == "" {
+			u.Nickname = ext.Name
+		}
+		if u.Avatar == "" {
+			u.Avatar = ext.Picture
+		}
+
+		createdUser, err = uc.saveWithIdentity(ctx, u, &Identity{Provider: u.AuthType, Subject: ext.Subject, Email: ext.Email})
 	}
-	if err != nil || createdUser == nil {
+	if err != nil {
 		return nil, err
 	}
 
-	// 2. 生成 JWT 令牌
-	accessToken, refreshToken, err := uc.jwtCli.GenerateToken(u.ID, createdUser.Nickname)
-	if err != nil {
+	// 3. 生成 JWT 令牌, 邮箱账号在验证前只拿到受限的权限范围
+	if err := uc.issueToken(ctx, createdUser); err != nil {
 		return nil, err
 	}
 
-	return &User{
-		AuthToken: AuthToken{
-			AccessToken:  accessToken,
-			RefreshToken: refreshToken,
-		},
-	}, nil
+	// 4. 发送验证邮件, 发送失败不影响注册, 客户端可以调用 ResendVerificationEmail 重发
+	if u.AuthType == AuthTypeEmail {
+		if err := uc.emails.SendVerification(ctx, createdUser.ID, u.Email); err != nil {
+			uc.logHelper.ErrorContext(ctx, "send verification email failed", "user_id", createdUser.ID, "err", err)
+		}
+	}
+	return createdUser, nil
+}
+
+// releaseStaleEmail 邮箱被长期未验证的账号占用时, 释放该邮箱并注销那个账号的全部会话
+// 否则任何人都可以抢先用别人的邮箱注册, 让真正的主人再也无法注册
+func (uc *UserUsecase) releaseStaleEmail(ctx context.Context, email string) error {
+	released, err := uc.emails.ReleaseStale(ctx, email)
+	if err != nil || released == 0 {
+		return err
+	}
+	uc.auditor.Audit(ctx, &AuditEvent{
+		Action:  AuditEmailReleased,
+		UserID:  released,
+		Details: map[string]any{"email": email},
+	})
+	// 被释放的账号已无法再登录, iat 只精确到秒, 把同一秒内签发的令牌也包括进来
+	return uc.revokeUser(ctx, released, time.Now().Add(time.Second))
+}
+
+// saveWithIdentity 在同一个事务中写入用户与注册所用的登录方式
+// 登录方式已被其他账号绑定时返回 ErrUserAlreadyExists
+func (uc *UserUsecase) saveWithIdentity(ctx context.Context, u *User, id *Identity) (*User, error) {
+	var saved *User
+	err := uc.tx.ExecTx(ctx, func(ctx context.Context) error {
+		var err error
+		if saved, err = uc.repo.Save(ctx, u); err != nil {
+			return err
+		}
+		id.UserID = saved.ID
+		if _, err := uc.repo.AddIdentity(ctx, id); err != nil {
+			if errors.Is(err, ErrIdentityAlreadyLinked) {
+				return ErrUserAlreadyExists
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
 }
 
 // GetUser 获取用户信息
@@ -95,6 +265,28 @@ func (uc *UserUsecase) GetUser(ctx context.Context, id int64) (*User, error) {
 	return uc.repo.FindByID(ctx, id)
 }
 
+// ListIdentities 列出用户绑定的所有登录方式
+func (uc *UserUsecase) ListIdentities(ctx context.Context, userID int64) ([]*Identity, error) {
+	return uc.repo.ListIdentities(ctx, userID)
+}
+
+// AuthMethods 返回用户可用的登录方式, 按绑定顺序去重
+// 早于身份表创建的用户没有 Identity 记录, 此时退回到注册时的 AuthType
+func AuthMethods(u *User, identities []*Identity) []string {
+	methods := make([]string, 0, len(identities)+1)
+	seen := make(map[string]bool, len(identities)+1)
+	for _, id := range identities {
+		if !seen[id.Provider] {
+			seen[id.Provider] = true
+			methods = append(methods, id.Provider)
+		}
+	}
+	if len(methods) == 0 && u.AuthType != AuthTypeNone {
+		methods = append(methods, u.AuthType)
+	}
+	return methods
+}
+
 // GetUserByPhone 通过手机号获取用户
 func (uc *UserUsecase) GetUserByPhone(ctx context.Context, phone string) (*User, error) {
 	return uc.repo.FindByPhone(ctx, phone)
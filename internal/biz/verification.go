@@ -0,0 +1,154 @@
+package biz
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"net/http"
+	"time"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+var (
+	// ErrInvalidCodePurpose 验证码用途不在支持的范围内
+	ErrInvalidCodePurpose = errors.BadRequest(v1.ErrorReason_INVALID_CODE_PURPOSE.String(), "invalid verification code purpose")
+	// ErrSmsTooFrequent 距离上次发送的时间小于最小间隔
+	ErrSmsTooFrequent = errors.New(http.StatusTooManyRequests, v1.ErrorReason_SMS_TOO_FREQUENT.String(), "verification code requested too frequently")
+	// ErrSmsHourlyLimitExceeded 超过每小时发送上限
+	ErrSmsHourlyLimitExceeded = errors.New(http.StatusTooManyRequests, v1.ErrorReason_SMS_HOURLY_LIMIT_EXCEEDED.String(), "hourly verification code limit exceeded")
+	// ErrSmsDailyLimitExceeded 超过每天发送上限
+	ErrSmsDailyLimitExceeded = errors.New(http.StatusTooManyRequests, v1.ErrorReason_SMS_DAILY_LIMIT_EXCEEDED.String(), "daily verification code limit exceeded")
+	// ErrSmsCircuitOpen 短信总量异常, 全局熔断中
+	ErrSmsCircuitOpen = errors.ServiceUnavailable(v1.ErrorReason_SMS_CIRCUIT_OPEN.String(), "verification code sending is temporarily suspended")
+)
+
+const (
+	defaultCodeTTL         = 5 * time.Minute
+	defaultCodeLength      = 6
+	defaultCodeMaxAttempts = 5
+)
+
+// SmsSender 发送短信验证码
+type SmsSender interface {
+	SendCode(ctx context.Context, phone, purpose, code string, ttl time.Duration) error
+}
+
+// ClientInfo 是发起请求的客户端信息, 取不到的字段为空
+type ClientInfo struct {
+	IP        string
+	DeviceID  string
+	UserAgent string
+}
+
+// SmsLimiter 限制验证码的发送频率
+type SmsLimiter interface {
+	// Acquire 检查手机号、IP、设备的发送频率以及全局熔断, 全部通过时占用一次额度
+	// 被拦截时返回 ErrSmsTooFrequent 等错误, metadata 中的 scope 指明触发限制的维度
+	Acquire(ctx context.Context, phone string, client ClientInfo) error
+}
+
+// VerificationCodeRepo 保存验证码, 同一用途、同一手机号只保留最新的一个
+type VerificationCodeRepo interface {
+	// Save 保存验证码并重置输错次数, 覆盖之前未使用的验证码
+	Save(ctx context.Context, purpose, phone, code string, ttl time.Duration) error
+	// Verify 原子地校验验证码: 正确时删除; 错误时累加次数, 达到 maxAttempts 后删除
+	Verify(ctx context.Context, purpose, phone, code string, maxAttempts int) (bool, error)
+}
+
+// VerificationPolicy 是验证码的生成与校验参数, 零值字段使用默认值
+type VerificationPolicy struct {
+	CodeTTL     time.Duration
+	CodeLength  int
+	MaxAttempts int
+}
+
+// VerificationUsecase 负责验证码的发送与校验, 实现了 CodeVerifier
+type VerificationUsecase struct {
+	repo    VerificationCodeRepo
+	sender  SmsSender
+	limiter SmsLimiter
+	policy  VerificationPolicy
+}
+
+// NewVerificationUsecase 创建验证码用例
+func NewVerificationUsecase(policy *VerificationPolicy, repo VerificationCodeRepo, sender SmsSender, limiter SmsLimiter) *VerificationUsecase {
+	p := *policy
+	if p.CodeTTL <= 0 {
+		p.CodeTTL = defaultCodeTTL
+	}
+	if p.CodeLength <= 0 {
+		p.CodeLength = defaultCodeLength
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultCodeMaxAttempts
+	}
+	return &VerificationUsecase{
+		repo:    repo,
+		sender:  sender,
+		limiter: limiter,
+		policy:  p,
+	}
+}
+
+// SendCode 生成并发送验证码, 返回验证码的有效期
+func (uc *VerificationUsecase) SendCode(ctx context.Context, purpose, phone string, client ClientInfo) (time.Duration, error) {
+	if !validCodePurpose(purpose) {
+		return 0, ErrInvalidCodePurpose
+	}
+	if err := uc.limiter.Acquire(ctx, phone, client); err != nil {
+		return 0, err
+	}
+
+	code, err := randomDigits(uc.policy.CodeLength)
+	if err != nil {
+		return 0, err
+	}
+	if err := uc.repo.Save(ctx, purpose, phone, code, uc.policy.CodeTTL); err != nil {
+		return 0, err
+	}
+	if err := uc.sender.SendCode(ctx, phone, purpose, code, uc.policy.CodeTTL); err != nil {
+		return 0, err
+	}
+	return uc.policy.CodeTTL, nil
+}
+
+// VerifyCode 校验并消费验证码
+func (uc *VerificationUsecase) VerifyCode(ctx context.Context, purpose, phone, code string) error {
+	if code == "" || !validCodePurpose(purpose) {
+		return ErrInvalidVerificationCode
+	}
+
+	ok, err := uc.repo.Verify(ctx, purpose, phone, code, uc.policy.MaxAttempts)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidVerificationCode
+	}
+	return nil
+}
+
+func validCodePurpose(purpose string) bool {
+	switch purpose {
+	case CodePurposeRegister, CodePurposeLogin, CodePurposeReset, CodePurposeLink:
+		return true
+	}
+	return false
+}
+
+// randomDigits 用 crypto/rand 生成 n 位数字
+func randomDigits(n int) (string, error) {
+	b := make([]byte, n)
+	ten := big.NewInt(10)
+	for i := range b {
+		d, err := rand.Int(rand.Reader, ten)
+		if err != nil {
+			return "", err
+		}
+		b[i] = byte('0' + d.Int64())
+	}
+	return string(b), nil
+}
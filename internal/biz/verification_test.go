@@ -0,0 +1,192 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memoryCodeRepo 按用途与手机号保存验证码, 记录收到的参数
+type memoryCodeRepo struct {
+	codes       map[string]string
+	ttl         time.Duration
+	maxAttempts int
+}
+
+func (r *memoryCodeRepo) Save(_ context.Context, purpose, phone, code string, ttl time.Duration) error {
+	if r.codes == nil {
+		r.codes = make(map[string]string)
+	}
+	r.codes[purpose+":"+phone] = code
+	r.ttl = ttl
+	return nil
+}
+
+func (r *memoryCodeRepo) Verify(_ context.Context, purpose, phone, code string, maxAttempts int) (bool, error) {
+	r.maxAttempts = maxAttempts
+	key := purpose + ":" + phone
+	if c, ok := r.codes[key]; ok && c == code {
+		delete(r.codes, key)
+		return true, nil
+	}
+	return false, nil
+}
+
+// recordingSmsSender 记录发出的验证码
+type recordingSmsSender struct {
+	sent []string
+	err  error
+}
+
+func (s *recordingSmsSender) SendCode(_ context.Context, _, _, code string, _ time.Duration) error {
+	s.sent = append(s.sent, code)
+	return s.err
+}
+
+type stubSmsLimiter struct {
+	calls int
+	err   error
+}
+
+func (l *stubSmsLimiter) Acquire(context.Context, string, ClientInfo) error {
+	l.calls++
+	return l.err
+}
+
+func newTestVerificationUsecase(policy *VerificationPolicy) (*VerificationUsecase, *memoryCodeRepo, *recordingSmsSender, *stubSmsLimiter) {
+	repo := &memoryCodeRepo{}
+	sender := &recordingSmsSender{}
+	limiter := &stubSmsLimiter{}
+	return NewVerificationUsecase(policy, repo, sender, limiter), repo, sender, limiter
+}
+
+func TestVerificationUsecase_SendAndVerify(t *testing.T) {
+	ctx := context.Background()
+	uc, repo, sender, _ := newTestVerificationUsecase(&VerificationPolicy{})
+
+	ttl, err := uc.SendCode(ctx, CodePurposeLogin, "13800000001", ClientInfo{})
+	if err != nil {
+		t.Fatalf("SendCode() error = %v", err)
+	}
+	// 未配置时使用默认值
+	if ttl != defaultCodeTTL || repo.ttl != defaultCodeTTL {
+		t.Errorf("SendCode() ttl = %v, saved with %v, want %v", ttl, repo.ttl, defaultCodeTTL)
+	}
+	if len(sender.sent) != 1 || len(sender.sent[0]) != defaultCodeLength {
+		t.Fatalf("sent = %v, want one %d-digit code", sender.sent, defaultCodeLength)
+	}
+	code := sender.sent[0]
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			t.Fatalf("code %q is not numeric", code)
+		}
+	}
+
+	if err := uc.VerifyCode(ctx, CodePurposeReset, "13800000001", code); !errors.Is(err, ErrInvalidVerificationCode) {
+		t.Errorf("VerifyCode() other purpose error = %v, want ErrInvalidVerificationCode", err)
+	}
+	if err := uc.VerifyCode(ctx, CodePurposeLogin, "13800000001", code); err != nil {
+		t.Fatalf("VerifyCode() error = %v", err)
+	}
+	if repo.maxAttempts != defaultCodeMaxAttempts {
+		t.Errorf("Verify() maxAttempts = %d, want %d", repo.maxAttempts, defaultCodeMaxAttempts)
+	}
+	if err := uc.VerifyCode(ctx, CodePurposeLogin, "13800000001", code); !errors.Is(err, ErrInvalidVerificationCode) {
+		t.Errorf("VerifyCode() reused error = %v, want ErrInvalidVerificationCode", err)
+	}
+}
+
+func TestVerificationUsecase_Policy(t *testing.T) {
+	uc, repo, sender, _ := newTestVerificationUsecase(&VerificationPolicy{CodeTTL: time.Minute, CodeLength: 4, MaxAttempts: 2})
+
+	ttl, err := uc.SendCode(context.Background(), CodePurposeRegister, "13800000001", ClientInfo{})
+	if err != nil || ttl != time.Minute || repo.ttl != time.Minute {
+		t.Fatalf("SendCode() = %v, %v, saved with %v, want 1m", ttl, err, repo.ttl)
+	}
+	if len(sender.sent[0]) != 4 {
+		t.Errorf("code %q, want 4 digits", sender.sent[0])
+	}
+	_ = uc.VerifyCode(context.Background(), CodePurposeRegister, "13800000001", "0")
+	if repo.maxAttempts != 2 {
+		t.Errorf("Verify() maxAttempts = %d, want 2", repo.maxAttempts)
+	}
+}
+
+func TestVerificationUsecase_SendCodeRejections(t *testing.T) {
+	ctx := context.Background()
+
+	// 用途不合法时不占用发送额度
+	uc, _, sender, limiter := newTestVerificationUsecase(&VerificationPolicy{})
+	if _, err := uc.SendCode(ctx, "bogus", "13800000001", ClientInfo{}); !errors.Is(err, ErrInvalidCodePurpose) {
+		t.Errorf("SendCode() error = %v, want ErrInvalidCodePurpose", err)
+	}
+	if limiter.calls != 0 || len(sender.sent) != 0 {
+		t.Errorf("invalid purpose reached the limiter (%d) or sender (%d)", limiter.calls, len(sender.sent))
+	}
+
+	// 被限流时不生成也不发送验证码
+	uc, repo, sender, limiter := newTestVerificationUsecase(&VerificationPolicy{})
+	limiter.err = ErrSmsTooFrequent
+	if _, err := uc.SendCode(ctx, CodePurposeLogin, "13800000001", ClientInfo{}); !errors.Is(err, ErrSmsTooFrequent) {
+		t.Errorf("SendCode() error = %v, want ErrSmsTooFrequent", err)
+	}
+	if len(repo.codes) != 0 || len(sender.sent) != 0 {
+		t.Errorf("limited send saved %v and sent %v", repo.codes, sender.sent)
+	}
+
+	// 发送失败时返回错误
+	uc, _, sender, _ = newTestVerificationUsecase(&VerificationPolicy{})
+	sender.err = errors.New("gateway down")
+	if _, err := uc.SendCode(ctx, CodePurposeLogin, "13800000001", ClientInfo{}); !errors.Is(err, sender.err) {
+		t.Errorf("SendCode() error = %v, want %v", err, sender.err)
+	}
+}
+
+func TestVerificationUsecase_VerifyCodeRejections(t *testing.T) {
+	uc, repo, _, _ := newTestVerificationUsecase(&VerificationPolicy{})
+	repo.maxAttempts = -1
+	for _, c := range []struct{ purpose, code string }{
+		{CodePurposeLogin, ""},
+		{"bogus", "123456"},
+	} {
+		if err := uc.VerifyCode(context.Background(), c.purpose, "13800000001", c.code); !errors.Is(err, ErrInvalidVerificationCode) {
+			t.Errorf("VerifyCode(%q, %q) error = %v, want ErrInvalidVerificationCode", c.purpose, c.code, err)
+		}
+	}
+	// 空验证码与非法用途不访问仓库, 不消耗输错次数
+	if repo.maxAttempts != -1 {
+		t.Error("invalid input reached the repo")
+	}
+}
+
+// stubCodeVerifier 只接受 code 为 ok 的验证码
+type stubCodeVerifier struct{}
+
+func (stubCodeVerifier) VerifyCode(_ context.Context, _, _, code string) error {
+	if code != "ok" {
+		return ErrInvalidVerificationCode
+	}
+	return nil
+}
+
+// notFoundUserRepo 找不到任何用户
+type notFoundUserRepo struct {
+	UserRepo
+}
+
+func (notFoundUserRepo) FindByIdentity(context.Context, string, string) (*User, error) {
+	return nil, ErrUserNotFound
+}
+
+func TestLoginWithCode_UnregisteredPhone(t *testing.T) {
+	uc := &UserUsecase{repo: notFoundUserRepo{}, codes: stubCodeVerifier{}}
+
+	// 未注册的手机号与密码登录一样不暴露是否注册
+	if _, err := uc.LoginWithCode(context.Background(), "13800000001", "ok"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("LoginWithCode() error = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := uc.LoginWithCode(context.Background(), "13800000001", "bad"); !errors.Is(err, ErrInvalidVerificationCode) {
+		t.Errorf("LoginWithCode() bad code error = %v, want ErrInvalidVerificationCode", err)
+	}
+}
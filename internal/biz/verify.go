@@ -0,0 +1,48 @@
+package biz
+
+import (
+	"context"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+var (
+	// ErrInvalidVerificationCode 验证码错误、过期或已使用
+	ErrInvalidVerificationCode = errors.BadRequest(v1.ErrorReason_INVALID_VERIFICATION_CODE.String(), "invalid verification code")
+	// ErrInvalidIDToken 第三方登录凭证校验失败
+	ErrInvalidIDToken = errors.Unauthorized(v1.ErrorReason_INVALID_ID_TOKEN.String(), "invalid id token")
+	// ErrVerificationUnavailable 校验依赖的服务未配置或不可用, 此时一律拒绝
+	ErrVerificationUnavailable = errors.ServiceUnavailable(v1.ErrorReason_VERIFICATION_UNAVAILABLE.String(), "verification unavailable")
+)
+
+// 验证码用途, 不同用途的验证码互不通用
+const (
+	CodePurposeRegister = "register"
+	CodePurposeLogin    = "login"
+	CodePurposeReset    = "reset"
+	CodePurposeLink     = "link"
+)
+
+// CodeVerifier 校验发送到手机的验证码
+type CodeVerifier interface {
+	// VerifyCode 校验并消费验证码, 同一个验证码只能成功校验一次
+	// 校验失败返回 ErrInvalidVerificationCode
+	VerifyCode(ctx context.Context, purpose, phone, code string) error
+}
+
+// ExternalIdentity 是第三方登录凭证中解析出的用户身份
+type ExternalIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+}
+
+// IDTokenVerifier 校验第三方签发的 id_token
+type IDTokenVerifier interface {
+	// VerifyIDToken 校验签名与声明, 失败返回 ErrInvalidIDToken
+	VerifyIDToken(ctx context.Context, idToken string) (*ExternalIdentity, error)
+}
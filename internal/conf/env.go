@@ -0,0 +1,9 @@
+package conf
+
+// EnvDev 是本地开发环境
+const EnvDev = "dev"
+
+// IsDev 报告是否运行在本地开发环境
+func (x *App) IsDev() bool {
+	return x.GetEnv() == EnvDev
+}
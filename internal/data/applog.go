@@ -0,0 +1,236 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/data/ent"
+	"github.com/YangZhaoWeblog/UserService/internal/data/ent/applog"
+	"github.com/YangZhaoWeblog/UserService/internal/data/ent/predicate"
+)
+
+type appLogRepo struct {
+	data *Data
+}
+
+// errNoDatabase memory 驱动下没有数据库, 错误日志无法落盘与查询
+var errNoDatabase = errors.New("applog requires a database, unavailable with the memory driver")
+
+// NewAppLogRepo 创建错误日志仓库实例
+func NewAppLogRepo(data *Data) biz.AppLogRepo {
+	if data.db == nil {
+		return noDatabaseAppLogRepo{}
+	}
+	return &appLogRepo{
+		data: data,
+	}
+}
+
+// BatchSave 批量写入错误日志
+func (r *appLogRepo) BatchSave(ctx context.Context, logs []*biz.AppLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	client := r.data.AppLog(ctx)
+	builders := make([]*ent.AppLogCreate, 0, len(logs))
+	for _, l := range logs {
+		extra := l.Extra
+		if extra == nil {
+			extra = map[string]any{}
+		}
+		builders = append(builders, client.Create().
+			SetTime(l.Time).
+			SetLevel(l.Level).
+			SetMsg(l.Msg).
+			SetKind(l.Kind).
+			SetComponent(l.Component).
+			SetOperation(l.Operation).
+			SetUserID(l.UserID).
+			SetTraceID(l.TraceID).
+			SetSpanID(l.SpanID).
+			SetArgs(l.Args).
+			SetCode(l.Code).
+			SetReason(l.Reason).
+			SetStack(l.Stack).
+			SetLatency(l.Latency).
+			SetAppName(l.AppName).
+			SetExtra(extra))
+	}
+	return client.CreateBulk(builders...).Exec(ctx)
+}
+
+// List 按 ID 倒序分页查询错误日志
+func (r *appLogRepo) List(ctx context.Context, f *biz.AppLogFilter, beforeID int, limit int) ([]*biz.AppLog, error) {
+	q := r.data.AppLog(ctx).Query().
+		Where(appLogPredicates(f)...)
+	if beforeID > 0 {
+		q.Where(applog.IDLT(beforeID))
+	}
+
+	pos, err := q.Order(ent.Desc(applog.FieldID)).
+		Limit(limit).
+		All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make([]*biz.AppLog, 0, len(pos))
+	for _, po := range pos {
+		logs = append(logs, toBizAppLog(po))
+	}
+	return logs, nil
+}
+
+// CountByCode 按错误码聚合条数
+func (r *appLogRepo) CountByCode(ctx context.Context, f *biz.AppLogFilter) ([]*biz.AppLogCodeCount, error) {
+	var rows []struct {
+		Code  int   `json:"code"`
+		Count int64 `json:"count"`
+	}
+	err := r.data.AppLog(ctx).Query().
+		Where(appLogPredicates(f)...).
+		GroupBy(applog.FieldCode).
+		Aggregate(ent.Count()).
+		Scan(ctx, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]*biz.AppLogCodeCount, 0, len(rows))
+	for _, row := range rows {
+		counts = append(counts, &biz.AppLogCodeCount{Code: row.Code, Count: row.Count})
+	}
+	return counts, nil
+}
+
+// ListExpired 按 ID 正序返回待清理的日志
+func (r *appLogRepo) ListExpired(ctx context.Context, match biz.AppLogMatch, exclude []biz.AppLogMatch, before time.Time, limit int) ([]*biz.AppLog, error) {
+	q := r.data.AppLog(ctx).Query().
+		Where(applog.TimeLT(before))
+	if p := appLogMatch(match); p != nil {
+		q.Where(p)
+	}
+	for _, m := range exclude {
+		p := appLogMatch(m)
+		if p == nil {
+			// 前面已有匹配所有日志的规则, 不会再剩下任何日志
+			return nil, nil
+		}
+		q.Where(applog.Not(p))
+	}
+
+	pos, err := q.Order(ent.Asc(applog.FieldID)).
+		Limit(limit).
+		All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make([]*biz.AppLog, 0, len(pos))
+	for _, po := range pos {
+		logs = append(logs, toBizAppLog(po))
+	}
+	return logs, nil
+}
+
+// DeleteByIDs 按 ID 批量删除
+func (r *appLogRepo) DeleteByIDs(ctx context.Context, ids []int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return r.data.AppLog(ctx).Delete().
+		Where(applog.IDIn(ids...)).
+		Exec(ctx)
+}
+
+// appLogMatch 返回 nil 表示匹配所有日志
+func appLogMatch(m biz.AppLogMatch) predicate.AppLog {
+	var ps []predicate.AppLog
+	if m.Level != "" {
+		ps = append(ps, applog.Level(m.Level))
+	}
+	if m.Code != 0 {
+		ps = append(ps, applog.Code(m.Code))
+	}
+	if len(ps) == 0 {
+		return nil
+	}
+	return applog.And(ps...)
+}
+
+func appLogPredicates(f *biz.AppLogFilter) []predicate.AppLog {
+	var ps []predicate.AppLog
+	if f == nil {
+		return ps
+	}
+	if !f.StartTime.IsZero() {
+		ps = append(ps, applog.TimeGTE(f.StartTime))
+	}
+	if !f.EndTime.IsZero() {
+		ps = append(ps, applog.TimeLT(f.EndTime))
+	}
+	if f.Code != 0 {
+		ps = append(ps, applog.Code(f.Code))
+	}
+	if f.Reason != "" {
+		ps = append(ps, applog.Reason(f.Reason))
+	}
+	if f.Operation != "" {
+		ps = append(ps, applog.Operation(f.Operation))
+	}
+	if f.TraceID != "" {
+		ps = append(ps, applog.TraceID(f.TraceID))
+	}
+	if f.UserID != 0 {
+		ps = append(ps, applog.UserID(f.UserID))
+	}
+	return ps
+}
+
+func toBizAppLog(po *ent.AppLog) *biz.AppLog {
+	return &biz.AppLog{
+		ID:        po.ID,
+		Time:      po.Time,
+		Level:     po.Level,
+		Msg:       po.Msg,
+		Kind:      po.Kind,
+		Component: po.Component,
+		Operation: po.Operation,
+		UserID:    po.UserID,
+		TraceID:   po.TraceID,
+		SpanID:    po.SpanID,
+		Args:      po.Args,
+		Code:      po.Code,
+		Reason:    po.Reason,
+		Stack:     po.Stack,
+		Latency:   po.Latency,
+		AppName:   po.AppName,
+		Extra:     po.Extra,
+	}
+}
+
+// noDatabaseAppLogRepo 是 memory 驱动下的占位实现, 所有操作都返回 errNoDatabase
+type noDatabaseAppLogRepo struct{}
+
+func (noDatabaseAppLogRepo) BatchSave(context.Context, []*biz.AppLog) error {
+	return errNoDatabase
+}
+
+func (noDatabaseAppLogRepo) List(context.Context, *biz.AppLogFilter, int, int) ([]*biz.AppLog, error) {
+	return nil, errNoDatabase
+}
+
+func (noDatabaseAppLogRepo) CountByCode(context.Context, *biz.AppLogFilter) ([]*biz.AppLogCodeCount, error) {
+	return nil, errNoDatabase
+}
+
+func (noDatabaseAppLogRepo) ListExpired(context.Context, biz.AppLogMatch, []biz.AppLogMatch, time.Time, int) ([]*biz.AppLog, error) {
+	return nil, errNoDatabase
+}
+
+func (noDatabaseAppLogRepo) DeleteByIDs(context.Context, []int) (int, error) {
+	return 0, errNoDatabase
+}
@@ -0,0 +1,111 @@
+package data
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+)
+
+// appLogArchiver 把错误日志归档为本地磁盘上 gzip 压缩的 jsonl 文件
+// 按日志所在的 UTC 日期分目录, 每批日志在每个日期下写一个文件, 文件名带上首尾日志的 ID, 重复归档同一批时覆盖写
+type appLogArchiver struct {
+	dir string
+}
+
+// NewAppLogArchiver 创建错误日志归档器
+func NewAppLogArchiver(c *conf.Log) biz.AppLogArchiver {
+	return &appLogArchiver{
+		dir: c.GetRetention().GetArchiveDir(),
+	}
+}
+
+// appLogRecord 是归档文件中的一行
+type appLogRecord struct {
+	ID        int            `json:"id"`
+	Time      time.Time      `json:"time"`
+	Level     string         `json:"level"`
+	Msg       string         `json:"msg"`
+	Kind      string         `json:"kind,omitempty"`
+	Component string         `json:"component,omitempty"`
+	Operation string         `json:"operation,omitempty"`
+	UserID    int64          `json:"user_id,omitempty"`
+	TraceID   string         `json:"trace_id,omitempty"`
+	SpanID    string         `json:"span_id,omitempty"`
+	Args      string         `json:"args,omitempty"`
+	Code      int            `json:"code,omitempty"`
+	Reason    string         `json:"reason,omitempty"`
+	Stack     string         `json:"stack,omitempty"`
+	Latency   float64        `json:"latency,omitempty"`
+	AppName   string         `json:"app_name,omitempty"`
+	Extra     map[string]any `json:"extra,omitempty"`
+}
+
+// Archive 按日期拆分后写入归档文件, 跨天的一批日志分别落到各自日期的目录
+func (a *appLogArchiver) Archive(_ context.Context, logs []*biz.AppLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	if a.dir == "" {
+		return fmt.Errorf("applog archive dir is not configured")
+	}
+
+	var days []string
+	byDay := make(map[string][]*biz.AppLog)
+	for _, l := range logs {
+		day := l.Time.UTC().Format("2006-01-02")
+		if _, ok := byDay[day]; !ok {
+			days = append(days, day)
+		}
+		byDay[day] = append(byDay[day], l)
+	}
+	for _, day := range days {
+		if err := a.write(filepath.Join(a.dir, day), byDay[day]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write 写入一个归档文件, 先写临时文件再改名, 避免留下写了一半的归档
+func (a *appLogArchiver) write(dir string, logs []*biz.AppLog) (err error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("applog-%d-%d.jsonl.gz", logs[0].ID, logs[len(logs)-1].ID))
+
+	f, err := os.CreateTemp(dir, ".applog-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	zw := gzip.NewWriter(f)
+	enc := json.NewEncoder(zw)
+	for _, l := range logs {
+		if err = enc.Encode(appLogRecord(*l)); err != nil {
+			return err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
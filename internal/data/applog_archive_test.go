@@ -0,0 +1,101 @@
+package data
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+)
+
+// readArchive 读出归档文件中每一行的 ID
+func readArchive(t *testing.T, path string) []int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	var ids []int
+	sc := bufio.NewScanner(zr)
+	for sc.Scan() {
+		var rec appLogRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("decode record: %v", err)
+		}
+		ids = append(ids, rec.ID)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatalf("read archive: %v", err)
+	}
+	return ids
+}
+
+// archiveFiles 返回归档目录下 日期/文件名 的列表
+func archiveFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	for i, f := range files {
+		files[i], _ = filepath.Rel(dir, f)
+	}
+	return files
+}
+
+func TestAppLogArchiver_SplitsByDay(t *testing.T) {
+	dir := t.TempDir()
+	a := NewAppLogArchiver(&conf.Log{Retention: &conf.Log_Retention{ArchiveDir: dir}})
+	midnight := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	logs := []*biz.AppLog{
+		{ID: 1, Time: midnight.Add(-2 * time.Hour), Msg: "a"},
+		{ID: 2, Time: midnight.Add(-time.Nanosecond), Msg: "b"},
+		{ID: 3, Time: midnight, Msg: "c"},
+		// 同一时刻的其他时区表示仍归到 UTC 日期
+		{ID: 4, Time: midnight.Add(time.Hour).In(time.FixedZone("UTC-8", -8*3600)), Msg: "d"},
+	}
+
+	if err := a.Archive(context.Background(), logs); err != nil {
+		t.Fatalf("Archive() error = %v", err)
+	}
+	want := []string{"2026-10-17/applog-1-2.jsonl.gz", "2026-10-18/applog-3-4.jsonl.gz"}
+	if got := archiveFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	if got := readArchive(t, filepath.Join(dir, want[0])); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("%s ids = %v", want[0], got)
+	}
+	if got := readArchive(t, filepath.Join(dir, want[1])); !reflect.DeepEqual(got, []int{3, 4}) {
+		t.Errorf("%s ids = %v", want[1], got)
+	}
+
+	// 删除失败后重试同一批, 覆盖原文件, 不留临时文件
+	if err := a.Archive(context.Background(), logs); err != nil {
+		t.Fatalf("Archive() again error = %v", err)
+	}
+	if got := archiveFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Fatalf("files after retry = %v, want %v", got, want)
+	}
+}
+
+func TestAppLogArchiver_RequiresDir(t *testing.T) {
+	a := NewAppLogArchiver(&conf.Log{})
+	if err := a.Archive(context.Background(), nil); err != nil {
+		t.Fatalf("Archive(empty) error = %v", err)
+	}
+	if err := a.Archive(context.Background(), []*biz.AppLog{{ID: 1, Time: time.Now()}}); err == nil {
+		t.Fatal("Archive() without a dir succeeded")
+	}
+}
@@ -0,0 +1,135 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+)
+
+// recordingArchiver 记录归档的日志 ID, err 不为空时归档失败
+type recordingArchiver struct {
+	ids []int
+	err error
+}
+
+func (a *recordingArchiver) Archive(_ context.Context, logs []*biz.AppLog) error {
+	if a.err != nil {
+		return a.err
+	}
+	for _, l := range logs {
+		a.ids = append(a.ids, l.ID)
+	}
+	return nil
+}
+
+// seedAppLogs 写入测试日志
+func seedAppLogs(t *testing.T, repo biz.AppLogRepo, logs ...*biz.AppLog) {
+	t.Helper()
+	if err := repo.BatchSave(context.Background(), logs); err != nil {
+		t.Fatalf("BatchSave() error = %v", err)
+	}
+}
+
+func remainingAppLogs(t *testing.T, repo biz.AppLogRepo) []string {
+	t.Helper()
+	logs, err := repo.List(context.Background(), &biz.AppLogFilter{}, 0, 100)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	msgs := make([]string, 0, len(logs))
+	for _, l := range logs {
+		msgs = append(msgs, l.Msg)
+	}
+	sort.Strings(msgs)
+	return msgs
+}
+
+func TestAppLogUsecase_Purge(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	repo := NewAppLogRepo(newTestData(t))
+	seedAppLogs(t, repo,
+		&biz.AppLog{Time: now.Add(-100 * day), Level: "audit", Msg: "audit-old"},
+		&biz.AppLog{Time: now.Add(-30 * day), Level: "audit", Msg: "audit-recent"},
+		&biz.AppLog{Time: now.Add(-2 * day), Level: "error", Code: 500, Msg: "500-old"},
+		&biz.AppLog{Time: now.Add(-time.Hour), Level: "error", Code: 500, Msg: "500-recent"},
+		&biz.AppLog{Time: now.Add(-400 * day), Level: "error", Code: 403, Msg: "403-ancient"},
+		&biz.AppLog{Time: now.Add(-10 * day), Level: "error", Code: 404, Msg: "404-old"},
+		&biz.AppLog{Time: now.Add(-3 * day), Level: "error", Code: 404, Msg: "404-recent"},
+	)
+	archiver := &recordingArchiver{}
+	uc := biz.NewAppLogUsecase(repo, archiver)
+
+	n, err := uc.Purge(ctx, &biz.AppLogRetentionPolicy{
+		Rules: []biz.AppLogRetentionRule{
+			{AppLogMatch: biz.AppLogMatch{Level: "audit"}, MaxAge: 90 * day, Archive: true},
+			{AppLogMatch: biz.AppLogMatch{Code: 500}, MaxAge: day},
+			// MaxAge 为 0 的规则永久保留命中的日志, 也不会被兜底规则清理
+			{AppLogMatch: biz.AppLogMatch{Code: 403}},
+		},
+		DefaultMaxAge: 7 * day,
+		// 小批量, 覆盖分批循环
+		BatchSize: 1,
+	}, now)
+	if err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Purge() deleted %d, want 3", n)
+	}
+	want := []string{"403-ancient", "404-recent", "500-recent", "audit-recent"}
+	if got := remainingAppLogs(t, repo); !reflect.DeepEqual(got, want) {
+		t.Errorf("remaining = %v, want %v", got, want)
+	}
+	// 只有开启归档的规则会归档
+	if len(archiver.ids) != 1 {
+		t.Errorf("archived %v, want only audit-old", archiver.ids)
+	}
+}
+
+// 前面的规则匹配所有日志时, 兜底规则不再清理任何日志
+func TestAppLogUsecase_PurgeCatchAllRule(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	repo := NewAppLogRepo(newTestData(t))
+	seedAppLogs(t, repo,
+		&biz.AppLog{Time: now.Add(-48 * time.Hour), Level: "error", Msg: "old"},
+		&biz.AppLog{Time: now.Add(-12 * time.Hour), Level: "error", Msg: "recent"},
+	)
+	uc := biz.NewAppLogUsecase(repo, &recordingArchiver{})
+
+	n, err := uc.Purge(ctx, &biz.AppLogRetentionPolicy{
+		Rules:         []biz.AppLogRetentionRule{{MaxAge: 24 * time.Hour}},
+		DefaultMaxAge: time.Hour,
+	}, now)
+	if err != nil || n != 1 {
+		t.Fatalf("Purge() = %d, %v, want 1", n, err)
+	}
+	if got := remainingAppLogs(t, repo); !reflect.DeepEqual(got, []string{"recent"}) {
+		t.Errorf("remaining = %v, want [recent]", got)
+	}
+}
+
+// 归档失败时不删除, 留到下一轮重试
+func TestAppLogUsecase_PurgeKeepsLogsWhenArchiveFails(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	repo := NewAppLogRepo(newTestData(t))
+	seedAppLogs(t, repo, &biz.AppLog{Time: now.Add(-48 * time.Hour), Level: "error", Msg: "old"})
+	archiveErr := errors.New("disk full")
+	uc := biz.NewAppLogUsecase(repo, &recordingArchiver{err: archiveErr})
+
+	n, err := uc.Purge(ctx, &biz.AppLogRetentionPolicy{DefaultMaxAge: time.Hour, DefaultArchive: true}, now)
+	if !errors.Is(err, archiveErr) || n != 0 {
+		t.Fatalf("Purge() = %d, %v, want 0, %v", n, err, archiveErr)
+	}
+	if got := remainingAppLogs(t, repo); !reflect.DeepEqual(got, []string{"old"}) {
+		t.Errorf("remaining = %v, want [old]", got)
+	}
+}
@@ -1,20 +1,148 @@
 package data
 
 import (
+	"context"
+	"fmt"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
 	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/YangZhaoWeblog/UserService/internal/data/ent"
+	"github.com/redis/go-redis/v9"
+
+	// 数据库驱动: 生产使用 MySQL, 本地运行与测试使用 SQLite
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/google/wire"
 )
 
 // ProviderSet is data providers.
-var ProviderSet = wire.NewSet(NewData, NewGreeterRepo, NewUserRepo)
+var ProviderSet = wire.NewSet(NewData, NewGreeterRepo, NewUserRepo, NewAppLogRepo, NewAppLogArchiver, NewLocker, NewTransaction,
+	NewProviderRegistry, NewRefreshTokenRepo, NewTokenDenylist, NewVerificationCodeRepo, NewVerificationPolicy, NewSmsSender, NewSmsLimiter,
+	NewIntrospectionCache, NewSessionRepo, NewEmailSender, NewEmailPolicy)
+
+// DriverMemory 不连接数据库, 用户数据只保存在进程内存中, 供本地开发使用
+const DriverMemory = "memory"
 
 // Data .
 type Data struct {
-	// TODO wrapped database client
+	db  *ent.Client // memory 驱动下为 nil
+	rdb *redis.Client
+}
+
+// NewData 根据 conf.Data.Database 打开数据库连接
+func NewData(c *conf.Data, logHelper *takin_log.TakinLogger) (*Data, func(), error) {
+	if c.GetDatabase().GetDriver() == DriverMemory {
+		return newMemoryData(c, logHelper)
+	}
+
+	drv, err := openDriver(c.GetDatabase())
+	if err != nil {
+		return nil, nil, err
+	}
+	client := ent.NewClient(ent.Driver(drv))
+
+	// 仅本地开发直接建表，生产环境的表结构变更走 cmd/migrate 的版本化迁移
+	if c.GetDatabase().GetAutoMigrate() {
+		if err := client.Schema.Create(context.Background()); err != nil {
+			_ = client.Close()
+			return nil, nil, fmt.Errorf("create schema failed: %w", err)
+		}
+	}
+
+	rdb, err := openRedis(c.GetRedis())
+	if err != nil {
+		_ = client.Close()
+		return nil, nil, err
+	}
+
+	cleanup := func() {
+		if err := client.Close(); err != nil {
+			logHelper.ErrorContext(context.Background(), "close database failed", "err", err)
+		}
+		if err := rdb.Close(); err != nil {
+			logHelper.ErrorContext(context.Background(), "close redis failed", "err", err)
+		}
+		logHelper.Info("closing the data resources")
+	}
+	return &Data{db: client, rdb: rdb}, cleanup, nil
 }
 
-// NewData .
-func NewData(c *conf.Data) (*Data, error) {
-	return &Data{}, nil
+// newMemoryData 不连接数据库; 未配置 Redis 时使用进程内的 Redis, 只有以 -tags dev 构建时可用
+func newMemoryData(c *conf.Data, logHelper *takin_log.TakinLogger) (*Data, func(), error) {
+	if c.GetRedis().GetAddr() != "" {
+		rdb, err := openRedis(c.GetRedis())
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup := func() {
+			if err := rdb.Close(); err != nil {
+				logHelper.ErrorContext(context.Background(), "close redis failed", "err", err)
+			}
+			logHelper.Info("closing the data resources")
+		}
+		return &Data{rdb: rdb}, cleanup, nil
+	}
+
+	rdb, closeRedis, err := embeddedRedis()
+	if err != nil {
+		return nil, nil, err
+	}
+	logHelper.Info("using in-memory storage, data will be lost on exit")
+
+	cleanup := func() {
+		closeRedis()
+		logHelper.Info("closing the data resources")
+	}
+	return &Data{rdb: rdb}, cleanup, nil
+}
+
+// openDriver 打开 ent 的 sql 驱动, 目前支持 mysql 与 sqlite3
+func openDriver(c *conf.Data_Database) (*entsql.Driver, error) {
+	if c == nil || c.Driver == "" {
+		return nil, fmt.Errorf("database driver is not configured")
+	}
+
+	switch c.Driver {
+	case dialect.MySQL, dialect.SQLite:
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", c.Driver)
+	}
+
+	drv, err := entsql.Open(c.Driver, c.Source)
+	if err != nil {
+		return nil, fmt.Errorf("open %s failed: %w", c.Driver, err)
+	}
+	if err := drv.DB().Ping(); err != nil {
+		_ = drv.Close()
+		return nil, fmt.Errorf("ping %s failed: %w", c.Driver, err)
+	}
+	return drv, nil
+}
+
+// openRedis 根据 conf.Data.Redis 创建 Redis 客户端
+func openRedis(c *conf.Data_Redis) (*redis.Client, error) {
+	if c == nil || c.Addr == "" {
+		return nil, fmt.Errorf("redis addr is not configured")
+	}
+
+	opts := &redis.Options{
+		Network: c.Network,
+		Addr:    c.Addr,
+	}
+	if c.ReadTimeout != nil {
+		opts.ReadTimeout = c.ReadTimeout.AsDuration()
+	}
+	if c.WriteTimeout != nil {
+		opts.WriteTimeout = c.WriteTimeout.AsDuration()
+	}
+
+	rdb := redis.NewClient(opts)
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		_ = rdb.Close()
+		return nil, fmt.Errorf("ping redis failed: %w", err)
+	}
+	return rdb, nil
 }
@@ -0,0 +1,39 @@
+package data
+
+import (
+	"context"
+	"testing"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+	"github.com/YangZhaoWeblog/UserService/internal/data/ent"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestData 创建测试用的 Data: SQLite 内存库加 miniredis, 每个用例互相隔离
+func newTestData(t *testing.T) *Data {
+	t.Helper()
+	d, _ := newTestDataWithRedis(t)
+	return d
+}
+
+// newTestDataWithRedis 同 newTestData, 额外返回 miniredis 以便检查 key 或快进时间
+func newTestDataWithRedis(t *testing.T) (*Data, *miniredis.Miniredis) {
+	t.Helper()
+	// 以用例名区分内存库, cache=shared 让连接池中的连接共享同一个库
+	drv, err := entsql.Open(dialect.SQLite, "file:"+t.Name()+"?mode=memory&cache=shared&_fk=1")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	client := ent.NewClient(ent.Driver(drv))
+	t.Cleanup(func() { _ = client.Close() })
+	if err := client.Schema.Create(context.Background()); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return &Data{db: client, rdb: rdb}, mr
+}
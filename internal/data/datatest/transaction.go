@@ -0,0 +1,244 @@
+package datatest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+)
+
+// TransactionFactory 为每个用例创建一个全新的、空的 biz.UserRepo 及与之配套的 biz.Transaction
+type TransactionFactory func(t *testing.T) (biz.UserRepo, biz.Transaction)
+
+// RunTransactionSuite 对 biz.Transaction 的实现运行一致性用例
+// 事务失败时, 事务中经由仓库执行的写操作都必须撤销
+func RunTransactionSuite(t *testing.T, factory TransactionFactory) {
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, repo biz.UserRepo, tx biz.Transaction)
+	}{
+		{"Commit", testTxCommit},
+		{"RollbackOnError", testTxRollbackOnError},
+		{"RollbackOnPanic", testTxRollbackOnPanic},
+		{"NestedJoinsOuter", testTxNested},
+		{"SaveWithConflictingIdentity", testTxSaveWithConflictingIdentity},
+		{"RollbackUpdates", testTxRollbackUpdates},
+		{"RollbackRemoveIdentity", testTxRollbackRemoveIdentity},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			repo, tx := factory(t)
+			c.fn(t, repo, tx)
+		})
+	}
+}
+
+var errTxAbort = errors.New("abort")
+
+func testTxCommit(t *testing.T, repo biz.UserRepo, tx biz.Transaction) {
+	ctx := context.Background()
+	var saved *biz.User
+	err := tx.ExecTx(ctx, func(ctx context.Context) error {
+		var err error
+		if saved, err = repo.Save(ctx, &biz.User{Phone: biz.Phone{Number: "13800000101"}}); err != nil {
+			return err
+		}
+		_, err = repo.AddIdentity(ctx, &biz.Identity{UserID: saved.ID, Provider: biz.AuthTypePhone, Subject: "13800000101"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ExecTx() error = %v", err)
+	}
+	if _, err := repo.FindByPhone(ctx, "13800000101"); err != nil {
+		t.Errorf("FindByPhone() after commit error = %v", err)
+	}
+	if ids, _ := repo.ListIdentities(ctx, saved.ID); len(ids) != 1 {
+		t.Errorf("ListIdentities() after commit = %d, want 1", len(ids))
+	}
+}
+
+func testTxRollbackOnError(t *testing.T, repo biz.UserRepo, tx biz.Transaction) {
+	ctx := context.Background()
+	err := tx.ExecTx(ctx, func(ctx context.Context) error {
+		if _, err := repo.Save(ctx, &biz.User{Username: "rollback", Phone: biz.Phone{Number: "13800000102"}}); err != nil {
+			return err
+		}
+		return errTxAbort
+	})
+	if !errors.Is(err, errTxAbort) {
+		t.Fatalf("ExecTx() error = %v, want %v", err, errTxAbort)
+	}
+	assertNoUser(t, repo, "13800000102", "rollback")
+}
+
+func testTxRollbackOnPanic(t *testing.T, repo biz.UserRepo, tx biz.Transaction) {
+	ctx := context.Background()
+	func() {
+		defer func() {
+			if v := recover(); v != "boom" {
+				t.Fatalf("recover() = %v, want the panic to propagate", v)
+			}
+		}()
+		_ = tx.ExecTx(ctx, func(ctx context.Context) error {
+			if _, err := repo.Save(ctx, &biz.User{Username: "panic", Phone: biz.Phone{Number: "13800000103"}}); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+	assertNoUser(t, repo, "13800000103", "panic")
+}
+
+func testTxNested(t *testing.T, repo biz.UserRepo, tx biz.Transaction) {
+	ctx := context.Background()
+	err := tx.ExecTx(ctx, func(ctx context.Context) error {
+		// 内层成功返回并不提交, 外层失败时一并回滚
+		if err := tx.ExecTx(ctx, func(ctx context.Context) error {
+			_, err := repo.Save(ctx, &biz.User{Username: "inner", Phone: biz.Phone{Number: "13800000104"}})
+			return err
+		}); err != nil {
+			return err
+		}
+		return errTxAbort
+	})
+	if !errors.Is(err, errTxAbort) {
+		t.Fatalf("ExecTx() error = %v, want %v", err, errTxAbort)
+	}
+	assertNoUser(t, repo, "13800000104", "inner")
+}
+
+// 注册时 Save 成功而 AddIdentity 冲突, 不能留下没有登录方式的用户
+func testTxSaveWithConflictingIdentity(t *testing.T, repo biz.UserRepo, tx biz.Transaction) {
+	ctx := context.Background()
+	owner := mustSave(t, repo, &biz.User{Nickname: "owner"})
+	if _, err := repo.AddIdentity(ctx, &biz.Identity{UserID: owner.ID, Provider: biz.AuthTypeGoogle, Subject: "google-sub-tx"}); err != nil {
+		t.Fatalf("AddIdentity() error = %v", err)
+	}
+
+	err := tx.ExecTx(ctx, func(ctx context.Context) error {
+		saved, err := repo.Save(ctx, &biz.User{Username: "orphan", Phone: biz.Phone{Number: "13800000105"}})
+		if err != nil {
+			return err
+		}
+		_, err = repo.AddIdentity(ctx, &biz.Identity{UserID: saved.ID, Provider: biz.AuthTypeGoogle, Subject: "google-sub-tx"})
+		return err
+	})
+	if !errors.Is(err, biz.ErrIdentityAlreadyLinked) {
+		t.Fatalf("ExecTx() error = %v, want ErrIdentityAlreadyLinked", err)
+	}
+	assertNoUser(t, repo, "13800000105", "orphan")
+
+	// 回滚后手机号与用户名可以再次注册, 原有绑定不受影响
+	mustSave(t, repo, &biz.User{Username: "orphan", Phone: biz.Phone{Number: "13800000105"}})
+	if ids, _ := repo.ListIdentities(ctx, owner.ID); len(ids) != 1 {
+		t.Errorf("ListIdentities(owner) = %d, want 1", len(ids))
+	}
+}
+
+func testTxRollbackUpdates(t *testing.T, repo biz.UserRepo, tx biz.Transaction) {
+	ctx := context.Background()
+	u := mustSave(t, repo, &biz.User{Username: "kate", Nickname: "Kate", PasswordHash: "hash-1", Phone: biz.Phone{Number: "13800000106"}})
+	if _, err := repo.AddIdentity(ctx, &biz.Identity{UserID: u.ID, Provider: biz.AuthTypePhone, Subject: "13800000106"}); err != nil {
+		t.Fatalf("AddIdentity() error = %v", err)
+	}
+	// 先读一次, 带缓存的实现此时已缓存该用户
+	if _, err := repo.FindByID(ctx, u.ID); err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+
+	err := tx.ExecTx(ctx, func(ctx context.Context) error {
+		changed := *u
+		changed.Username = "kate2"
+		changed.Nickname = "Kate 2"
+		changed.Phone = biz.Phone{Number: "13800000107"}
+		if _, err := repo.Update(ctx, &changed); err != nil {
+			return err
+		}
+		if err := repo.UpdatePassword(ctx, u.ID, "hash-2"); err != nil {
+			return err
+		}
+		if err := repo.MarkEmailVerified(ctx, u.ID); err != nil {
+			return err
+		}
+		return errTxAbort
+	})
+	if !errors.Is(err, errTxAbort) {
+		t.Fatalf("ExecTx() error = %v, want %v", err, errTxAbort)
+	}
+
+	got, err := repo.FindByID(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if got.Username != "kate" || got.Nickname != "Kate" || got.Phone.Number != "13800000106" {
+		t.Errorf("FindByID() = %+v, want the update rolled back", got)
+	}
+	// 缓存中不含密码哈希, 经由登录方式读取
+	if byIdentity, err := repo.FindByIdentity(ctx, biz.AuthTypePhone, "13800000106"); err != nil || byIdentity.PasswordHash != "hash-1" {
+		t.Errorf("FindByIdentity() = %+v, %v, want PasswordHash hash-1", byIdentity, err)
+	}
+	if got.EmailVerified {
+		t.Error("EmailVerified = true, want rolled back")
+	}
+	// 旧的唯一索引恢复, 新的被释放
+	if _, err := repo.FindByPhone(ctx, "13800000106"); err != nil {
+		t.Errorf("FindByPhone(old) error = %v", err)
+	}
+	assertNoUser(t, repo, "13800000107", "kate2")
+}
+
+func testTxRollbackRemoveIdentity(t *testing.T, repo biz.UserRepo, tx biz.Transaction) {
+	ctx := context.Background()
+	u := mustSave(t, repo, &biz.User{Nickname: "leo"})
+	for _, id := range []*biz.Identity{
+		{UserID: u.ID, Provider: biz.AuthTypePhone, Subject: "13800000108"},
+		{UserID: u.ID, Provider: biz.AuthTypeGoogle, Subject: "google-sub-leo"},
+		{UserID: u.ID, Provider: biz.AuthTypeEmail, Subject: "leo@example.com"},
+	} {
+		if _, err := repo.AddIdentity(ctx, id); err != nil {
+			t.Fatalf("AddIdentity() error = %v", err)
+		}
+	}
+
+	err := tx.ExecTx(ctx, func(ctx context.Context) error {
+		if err := repo.RemoveIdentity(ctx, u.ID, biz.AuthTypeGoogle, "google-sub-leo"); err != nil {
+			return err
+		}
+		return errTxAbort
+	})
+	if !errors.Is(err, errTxAbort) {
+		t.Fatalf("ExecTx() error = %v, want %v", err, errTxAbort)
+	}
+
+	ids, err := repo.ListIdentities(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("ListIdentities() error = %v", err)
+	}
+	want := []string{biz.AuthTypePhone, biz.AuthTypeGoogle, biz.AuthTypeEmail}
+	if len(ids) != len(want) {
+		t.Fatalf("ListIdentities() = %d, want %d", len(ids), len(want))
+	}
+	for i, id := range ids {
+		if id.Provider != want[i] {
+			t.Errorf("ListIdentities()[%d] = %s, want %s", i, id.Provider, want[i])
+		}
+	}
+	// 绑定仍归原用户所有
+	other := mustSave(t, repo, &biz.User{Nickname: "mia"})
+	if _, err := repo.AddIdentity(ctx, &biz.Identity{UserID: other.ID, Provider: biz.AuthTypeGoogle, Subject: "google-sub-leo"}); !errors.Is(err, biz.ErrIdentityAlreadyLinked) {
+		t.Errorf("AddIdentity() after rollback error = %v, want ErrIdentityAlreadyLinked", err)
+	}
+}
+
+// assertNoUser 断言手机号与用户名都没有被占用
+func assertNoUser(t *testing.T, repo biz.UserRepo, phone, username string) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := repo.FindByPhone(ctx, phone); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Errorf("FindByPhone(%s) error = %v, want ErrUserNotFound", phone, err)
+	}
+	if _, err := repo.FindByUsername(ctx, username); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Errorf("FindByUsername(%s) error = %v, want ErrUserNotFound", username, err)
+	}
+}
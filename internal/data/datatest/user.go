@@ -0,0 +1,409 @@
+// Package datatest 提供 biz 仓库接口的一致性测试套件
+// internal/data 中的每种实现(数据库、内存、缓存)都应跑同一套用例, 保证行为一致
+package datatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+)
+
+// UserRepoFactory 为每个用例创建一个全新的、空的 biz.UserRepo
+type UserRepoFactory func(t *testing.T) biz.UserRepo
+
+// RunUserRepoSuite 对 biz.UserRepo 的实现运行一致性用例
+func RunUserRepoSuite(t *testing.T, factory UserRepoFactory) {
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, repo biz.UserRepo)
+	}{
+		{"SaveAndFind", testSaveAndFind},
+		{"NotFound", testNotFound},
+		{"UniquePhone", testUniquePhone},
+		{"UniqueUsername", testUniqueUsername},
+		{"EmptyPhoneAndUsernameNotUnique", testEmptyNotUnique},
+		{"Update", testUpdate},
+		{"UpdateNotFound", testUpdateNotFound},
+		{"UpdateConflict", testUpdateConflict},
+		{"ConcurrentSaveSamePhone", testConcurrentSaveSamePhone},
+		{"ConcurrentSaveDistinct", testConcurrentSaveDistinct},
+		{"Identities", testIdentities},
+		{"IdentityUnknownUser", testIdentityUnknownUser},
+		{"Password", testPassword},
+		{"EmailVerified", testEmailVerified},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.fn(t, factory(t))
+		})
+	}
+}
+
+func testSaveAndFind(t *testing.T, repo biz.UserRepo) {
+	ctx := context.Background()
+	saved := mustSave(t, repo, &biz.User{
+		Username: "alice",
+		Nickname: "Alice",
+		Avatar:   "https://example.com/a.png",
+		AuthType: biz.AuthTypePhone,
+		Phone:    biz.Phone{Number: "13800000001"},
+	})
+	if saved.ID == 0 {
+		t.Fatal("Save() did not assign an ID")
+	}
+	if saved.CreatedAt.IsZero() {
+		t.Error("Save() did not set CreatedAt")
+	}
+
+	lookups := map[string]func() (*biz.User, error){
+		"FindByID":       func() (*biz.User, error) { return repo.FindByID(ctx, saved.ID) },
+		"FindByPhone":    func() (*biz.User, error) { return repo.FindByPhone(ctx, "13800000001") },
+		"FindByUsername": func() (*biz.User, error) { return repo.FindByUsername(ctx, "alice") },
+	}
+	for name, find := range lookups {
+		got, err := find()
+		if err != nil {
+			t.Fatalf("%s() error = %v", name, err)
+		}
+		assertSameUser(t, name, got, saved)
+	}
+}
+
+func testNotFound(t *testing.T, repo biz.UserRepo) {
+	ctx := context.Background()
+	mustSave(t, repo, &biz.User{Username: "bob", Phone: biz.Phone{Number: "13800000002"}})
+
+	if _, err := repo.FindByID(ctx, 987654321); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Errorf("FindByID() error = %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.FindByPhone(ctx, "13899999999"); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Errorf("FindByPhone() error = %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.FindByUsername(ctx, "nobody"); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Errorf("FindByUsername() error = %v, want ErrUserNotFound", err)
+	}
+	// 查询过一次不存在之后再注册, 必须能查到(缓存实现不能一直返回不存在)
+	saved := mustSave(t, repo, &biz.User{Phone: biz.Phone{Number: "13899999999"}})
+	if got, err := repo.FindByPhone(ctx, "13899999999"); err != nil || got.ID != saved.ID {
+		t.Errorf("FindByPhone() after Save = %v, %v, want user %d", got, err, saved.ID)
+	}
+}
+
+func testUniquePhone(t *testing.T, repo biz.UserRepo) {
+	mustSave(t, repo, &biz.User{Phone: biz.Phone{Number: "13800000003"}})
+	_, err := repo.Save(context.Background(), &biz.User{Phone: biz.Phone{Number: "13800000003"}})
+	if !errors.Is(err, biz.ErrUserAlreadyExists) {
+		t.Errorf("Save() duplicate phone error = %v, want ErrUserAlreadyExists", err)
+	}
+}
+
+func testUniqueUsername(t *testing.T, repo biz.UserRepo) {
+	mustSave(t, repo, &biz.User{Username: "carol"})
+	_, err := repo.Save(context.Background(), &biz.User{Username: "carol"})
+	if !errors.Is(err, biz.ErrUserAlreadyExists) {
+		t.Errorf("Save() duplicate username error = %v, want ErrUserAlreadyExists", err)
+	}
+}
+
+func testEmptyNotUnique(t *testing.T, repo biz.UserRepo) {
+	a := mustSave(t, repo, &biz.User{Nickname: "a"})
+	b := mustSave(t, repo, &biz.User{Nickname: "b"})
+	if a.ID == b.ID {
+		t.Errorf("Save() assigned the same ID %d twice", a.ID)
+	}
+}
+
+func testUpdate(t *testing.T, repo biz.UserRepo) {
+	ctx := context.Background()
+	saved := mustSave(t, repo, &biz.User{Username: "dave", Nickname: "Dave", Phone: biz.Phone{Number: "13800000004"}})
+	// 先读一次, 让缓存实现把旧数据缓存起来
+	if _, err := repo.FindByPhone(ctx, "13800000004"); err != nil {
+		t.Fatalf("FindByPhone() error = %v", err)
+	}
+
+	saved.Nickname = "David"
+	saved.Phone.Number = "13800000005"
+	updated, err := repo.Update(ctx, saved)
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if updated.Nickname != "David" || updated.Phone.Number != "13800000005" {
+		t.Errorf("Update() = %+v, want new nickname and phone", updated)
+	}
+	if !updated.CreatedAt.Equal(saved.CreatedAt) {
+		t.Errorf("Update() changed CreatedAt from %v to %v", saved.CreatedAt, updated.CreatedAt)
+	}
+
+	got, err := repo.FindByID(ctx, saved.ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if got.Nickname != "David" {
+		t.Errorf("FindByID() after Update nickname = %q, want %q", got.Nickname, "David")
+	}
+	if _, err := repo.FindByPhone(ctx, "13800000004"); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Errorf("FindByPhone(old) error = %v, want ErrUserNotFound", err)
+	}
+	if got, err := repo.FindByPhone(ctx, "13800000005"); err != nil || got.ID != saved.ID {
+		t.Errorf("FindByPhone(new) = %v, %v, want user %d", got, err, saved.ID)
+	}
+	// 旧手机号释放后可以被其他用户使用
+	mustSave(t, repo, &biz.User{Phone: biz.Phone{Number: "13800000004"}})
+}
+
+func testUpdateNotFound(t *testing.T, repo biz.UserRepo) {
+	_, err := repo.Update(context.Background(), &biz.User{ID: 987654321, Nickname: "ghost"})
+	if !errors.Is(err, biz.ErrUserNotFound) {
+		t.Errorf("Update() unknown user error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func testUpdateConflict(t *testing.T, repo biz.UserRepo) {
+	mustSave(t, repo, &biz.User{Username: "erin", Phone: biz.Phone{Number: "13800000006"}})
+	frank := mustSave(t, repo, &biz.User{Username: "frank", Phone: biz.Phone{Number: "13800000007"}})
+
+	frank.Phone.Number = "13800000006"
+	if _, err := repo.Update(context.Background(), frank); !errors.Is(err, biz.ErrUserAlreadyExists) {
+		t.Errorf("Update() to taken phone error = %v, want ErrUserAlreadyExists", err)
+	}
+	frank.Phone.Number = "13800000007"
+	frank.Username = "erin"
+	if _, err := repo.Update(context.Background(), frank); !errors.Is(err, biz.ErrUserAlreadyExists) {
+		t.Errorf("Update() to taken username error = %v, want ErrUserAlreadyExists", err)
+	}
+}
+
+func testConcurrentSaveSamePhone(t *testing.T, repo biz.UserRepo) {
+	const n = 8
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		success  int
+		conflict int
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := repo.Save(context.Background(), &biz.User{
+				Nickname: fmt.Sprintf("racer-%d", i),
+				Phone:    biz.Phone{Number: "13800000008"},
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				success++
+			case errors.Is(err, biz.ErrUserAlreadyExists):
+				conflict++
+			default:
+				t.Errorf("Save() unexpected error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if success != 1 || conflict != n-1 {
+		t.Errorf("concurrent Save() of one phone: %d succeeded, %d conflicted, want 1 and %d", success, conflict, n-1)
+	}
+}
+
+func testConcurrentSaveDistinct(t *testing.T, repo biz.UserRepo) {
+	const n = 8
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		ids = make(map[int64]bool)
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u, err := repo.Save(context.Background(), &biz.User{
+				Phone: biz.Phone{Number: fmt.Sprintf("1390000%04d", i)},
+			})
+			if err != nil {
+				t.Errorf("Save() error = %v", err)
+				return
+			}
+			mu.Lock()
+			ids[u.ID] = true
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	if len(ids) != n {
+		t.Errorf("concurrent Save() assigned %d distinct IDs, want %d", len(ids), n)
+	}
+}
+
+func testIdentities(t *testing.T, repo biz.UserRepo) {
+	ctx := context.Background()
+	u := mustSave(t, repo, &biz.User{Nickname: "grace"})
+	other := mustSave(t, repo, &biz.User{Nickname: "heidi"})
+
+	for _, id := range []*biz.Identity{
+		{UserID: u.ID, Provider: biz.AuthTypeGoogle, Subject: "google-sub-1", Email: "grace@example.com"},
+		{UserID: u.ID, Provider: biz.AuthTypePhone, Subject: "13800000009"},
+	} {
+		saved, err := repo.AddIdentity(ctx, id)
+		if err != nil {
+			t.Fatalf("AddIdentity(%+v) error = %v", id, err)
+		}
+		if saved.CreatedAt.IsZero() {
+			t.Errorf("AddIdentity(%+v) did not set CreatedAt", id)
+		}
+	}
+
+	got, err := repo.FindByIdentity(ctx, biz.AuthTypeGoogle, "google-sub-1")
+	if err != nil || got.ID != u.ID {
+		t.Errorf("FindByIdentity() = %v, %v, want user %d", got, err, u.ID)
+	}
+	if _, err := repo.FindByIdentity(ctx, biz.AuthTypePhone, "google-sub-1"); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Errorf("FindByIdentity() other provider error = %v, want ErrUserNotFound", err)
+	}
+
+	// 同一身份不能绑定到两个用户
+	_, err = repo.AddIdentity(ctx, &biz.Identity{UserID: other.ID, Provider: biz.AuthTypeGoogle, Subject: "google-sub-1"})
+	if !errors.Is(err, biz.ErrIdentityAlreadyLinked) {
+		t.Errorf("AddIdentity() duplicate error = %v, want ErrIdentityAlreadyLinked", err)
+	}
+
+	list, err := repo.ListIdentities(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("ListIdentities() error = %v", err)
+	}
+	if len(list) != 2 || list[0].Provider != biz.AuthTypeGoogle || list[1].Provider != biz.AuthTypePhone {
+		t.Errorf("ListIdentities() = %+v, want google then phone", list)
+	}
+	if len(list) > 0 && list[0].Email != "grace@example.com" {
+		t.Errorf("ListIdentities()[0].Email = %q, want grace@example.com", list[0].Email)
+	}
+
+	if err := repo.RemoveIdentity(ctx, other.ID, biz.AuthTypeGoogle, "google-sub-1"); !errors.Is(err, biz.ErrIdentityNotFound) {
+		t.Errorf("RemoveIdentity() by non-owner error = %v, want ErrIdentityNotFound", err)
+	}
+	if err := repo.RemoveIdentity(ctx, u.ID, biz.AuthTypeGoogle, "google-sub-1"); err != nil {
+		t.Fatalf("RemoveIdentity() error = %v", err)
+	}
+	if err := repo.RemoveIdentity(ctx, u.ID, biz.AuthTypeGoogle, "google-sub-1"); !errors.Is(err, biz.ErrIdentityNotFound) {
+		t.Errorf("RemoveIdentity() twice error = %v, want ErrIdentityNotFound", err)
+	}
+	if _, err := repo.FindByIdentity(ctx, biz.AuthTypeGoogle, "google-sub-1"); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Errorf("FindByIdentity() after remove error = %v, want ErrUserNotFound", err)
+	}
+	if list, _ := repo.ListIdentities(ctx, u.ID); len(list) != 1 {
+		t.Errorf("ListIdentities() after remove = %+v, want 1 identity", list)
+	}
+
+	// 解绑后可以绑定到其他用户
+	if _, err := repo.AddIdentity(ctx, &biz.Identity{UserID: other.ID, Provider: biz.AuthTypeGoogle, Subject: "google-sub-1"}); err != nil {
+		t.Errorf("AddIdentity() after remove error = %v", err)
+	}
+}
+
+func testIdentityUnknownUser(t *testing.T, repo biz.UserRepo) {
+	_, err := repo.AddIdentity(context.Background(), &biz.Identity{UserID: 987654321, Provider: biz.AuthTypeGoogle, Subject: "x"})
+	if !errors.Is(err, biz.ErrUserNotFound) {
+		t.Errorf("AddIdentity() unknown user error = %v, want ErrUserNotFound", err)
+	}
+	if list, err := repo.ListIdentities(context.Background(), 987654321); err != nil || len(list) != 0 {
+		t.Errorf("ListIdentities() unknown user = %v, %v, want empty", list, err)
+	}
+}
+
+func testPassword(t *testing.T, repo biz.UserRepo) {
+	ctx := context.Background()
+	u := mustSave(t, repo, &biz.User{Nickname: "ivan", Password: "plaintext", PasswordHash: "hash-1"})
+	if u.Password != "" {
+		t.Errorf("Save() returned plaintext password %q", u.Password)
+	}
+	if _, err := repo.AddIdentity(ctx, &biz.Identity{UserID: u.ID, Provider: biz.AuthTypePhone, Subject: "13800000010"}); err != nil {
+		t.Fatalf("AddIdentity() error = %v", err)
+	}
+	passwordHash := func() string {
+		t.Helper()
+		got, err := repo.FindByIdentity(ctx, biz.AuthTypePhone, "13800000010")
+		if err != nil {
+			t.Fatalf("FindByIdentity() error = %v", err)
+		}
+		return got.PasswordHash
+	}
+	if h := passwordHash(); h != "hash-1" {
+		t.Errorf("FindByIdentity() PasswordHash = %q, want %q", h, "hash-1")
+	}
+
+	// Update 传入的用户可能来自缓存, 不带密码哈希, 不能把密码清掉
+	u.Nickname = "Ivan"
+	u.PasswordHash = ""
+	if _, err := repo.Update(ctx, u); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if h := passwordHash(); h != "hash-1" {
+		t.Errorf("PasswordHash after Update = %q, want %q", h, "hash-1")
+	}
+
+	if err := repo.UpdatePassword(ctx, u.ID, "hash-2"); err != nil {
+		t.Fatalf("UpdatePassword() error = %v", err)
+	}
+	if h := passwordHash(); h != "hash-2" {
+		t.Errorf("PasswordHash after UpdatePassword = %q, want %q", h, "hash-2")
+	}
+	if err := repo.UpdatePassword(ctx, 987654321, "hash"); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Errorf("UpdatePassword() unknown user error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func testEmailVerified(t *testing.T, repo biz.UserRepo) {
+	ctx := context.Background()
+	u := mustSave(t, repo, &biz.User{Nickname: "judy", AuthType: biz.AuthTypeEmail})
+	if u.EmailVerified {
+		t.Fatalf("Save() EmailVerified = true, want false")
+	}
+
+	if err := repo.MarkEmailVerified(ctx, u.ID); err != nil {
+		t.Fatalf("MarkEmailVerified() error = %v", err)
+	}
+	got, err := repo.FindByID(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if !got.EmailVerified {
+		t.Errorf("EmailVerified after MarkEmailVerified = false, want true")
+	}
+
+	// Update 传入的用户可能是验证之前读到的, 不能把验证状态改回去
+	u.Nickname = "Judy"
+	if _, err := repo.Update(ctx, u); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got, _ := repo.FindByID(ctx, u.ID); got == nil || !got.EmailVerified {
+		t.Errorf("EmailVerified after Update = false, want true")
+	}
+
+	if err := repo.MarkEmailVerified(ctx, 987654321); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Errorf("MarkEmailVerified() unknown user error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func mustSave(t *testing.T, repo biz.UserRepo, u *biz.User) *biz.User {
+	t.Helper()
+	saved, err := repo.Save(context.Background(), u)
+	if err != nil {
+		t.Fatalf("Save(%+v) error = %v", u, err)
+	}
+	return saved
+}
+
+func assertSameUser(t *testing.T, op string, got, want *biz.User) {
+	t.Helper()
+	if got.ID != want.ID || got.Username != want.Username || got.Nickname != want.Nickname ||
+		got.Avatar != want.Avatar || got.AuthType != want.AuthType || got.Phone.Number != want.Phone.Number {
+		t.Errorf("%s() = %+v, want %+v", op, got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+)
+
+// 邮件发送方式, 目前只有本地开发用的两种
+const (
+	EmailDriverLog  = "log"
+	EmailDriverFile = "file"
+)
+
+// NewEmailSender 根据 conf.Data.Email.driver 创建邮件发送器
+// 未配置 driver 时只有 dev 环境默认使用 log, 其他环境拒绝启动, 避免验证邮件被静默丢弃
+func NewEmailSender(c *conf.Data, app *conf.App, logHelper *takin_log.TakinLogger) (biz.EmailSender, error) {
+	ec := c.GetEmail()
+	switch ec.GetDriver() {
+	case "":
+		if !app.IsDev() {
+			return nil, fmt.Errorf("email driver is not configured")
+		}
+		return &logEmailSender{logHelper: logHelper}, nil
+	case EmailDriverLog:
+		return &logEmailSender{logHelper: logHelper}, nil
+	case EmailDriverFile:
+		if ec.GetFilePath() == "" {
+			return nil, fmt.Errorf("email file_path is not configured")
+		}
+		return &fileEmailSender{path: ec.GetFilePath()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported email driver: %s", ec.GetDriver())
+	}
+}
+
+// NewEmailPolicy 从 conf.Data.Email 读取验证链接参数
+func NewEmailPolicy(c *conf.Data) *biz.EmailPolicy {
+	ec := c.GetEmail()
+	return &biz.EmailPolicy{
+		VerifyURL:      ec.GetVerifyUrl(),
+		LinkTTL:        ec.GetLinkTtl().AsDuration(),
+		ResendInterval: ec.GetResendInterval().AsDuration(),
+		UnverifiedTTL:  ec.GetUnverifiedTtl().AsDuration(),
+	}
+}
+
+// logEmailSender 只记录发送了验证邮件, 不真正发送
+// 链接中的令牌可以直接完成验证, 不写入日志; 本地调试需要读取链接时使用 file
+type logEmailSender struct {
+	logHelper *takin_log.TakinLogger
+}
+
+func (s *logEmailSender) SendVerificationLink(ctx context.Context, email, _ string, ttl time.Duration) error {
+	s.logHelper.InfoContext(ctx, "email verification link sent", "email", email, "ttl", ttl.String())
+	return nil
+}
+
+// fileEmailSender 每封邮件追加一行 JSON, 便于本地调试与自动化测试读取
+type fileEmailSender struct {
+	mu   sync.Mutex
+	path string
+}
+
+type emailRecord struct {
+	Time time.Time `json:"time"`
+	To   string    `json:"to"`
+	Link string    `json:"link"`
+	TTL  string    `json:"ttl"`
+}
+
+func (s *fileEmailSender) SendVerificationLink(_ context.Context, email, link string, ttl time.Duration) error {
+	line, err := json.Marshal(&emailRecord{
+		Time: time.Now(),
+		To:   email,
+		Link: link,
+		TTL:  ttl.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open email file failed: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write email file failed: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,138 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+)
+
+func TestNewEmailSender(t *testing.T) {
+	dev := &conf.App{Env: conf.EnvDev}
+	prod := &conf.App{Env: "prod"}
+	tests := []struct {
+		name    string
+		email   *conf.Data_Email
+		app     *conf.App
+		wantErr bool
+	}{
+		{"unset in dev", nil, dev, false},
+		{"unset outside dev", nil, prod, true},
+		{"unset without env", nil, &conf.App{}, true},
+		{"explicit log", &conf.Data_Email{Driver: EmailDriverLog}, prod, false},
+		{"file without path", &conf.Data_Email{Driver: EmailDriverFile}, dev, true},
+		{"unknown driver", &conf.Data_Email{Driver: "smtp"}, dev, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewEmailSender(&conf.Data{Email: tt.email}, tt.app, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewEmailSender() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileEmailSender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "email.jsonl")
+	sender, err := NewEmailSender(&conf.Data{Email: &conf.Data_Email{Driver: EmailDriverFile, FilePath: path}}, &conf.App{}, nil)
+	if err != nil {
+		t.Fatalf("NewEmailSender() error = %v", err)
+	}
+	if err := sender.SendVerificationLink(context.Background(), "alice@example.com", "https://example.com/verify?token=t", time.Hour); err != nil {
+		t.Fatalf("SendVerificationLink() error = %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read email file: %v", err)
+	}
+	var rec emailRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		t.Fatalf("decode email record: %v", err)
+	}
+	if rec.To != "alice@example.com" || rec.Link != "https://example.com/verify?token=t" || rec.TTL != "1h0m0s" {
+		t.Errorf("record = %+v", rec)
+	}
+}
+
+func TestEmailVerification_ReleaseStale(t *testing.T) {
+	ctx := context.Background()
+	d := newTestData(t)
+	repo := NewUserRepo(d)
+	jwtCli, err := pkg.NewClient(&conf.Data{Jwt: &conf.Data_Jwt{SigningKey: "secret", ExpiresTime: 60}})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	const unverifiedTTL = 100 * time.Millisecond
+	uc := biz.NewEmailVerificationUsecase(&biz.EmailPolicy{LinkTTL: time.Millisecond, UnverifiedTTL: unverifiedTTL},
+		repo, nopEmailSender{}, NewLocker(d), jwtCli)
+
+	register := func(email string) *biz.User {
+		t.Helper()
+		u, err := repo.Save(ctx, &biz.User{AuthType: biz.AuthTypeEmail})
+		if err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		if _, err := repo.AddIdentity(ctx, &biz.Identity{UserID: u.ID, Provider: biz.AuthTypeEmail, Subject: email}); err != nil {
+			t.Fatalf("AddIdentity() error = %v", err)
+		}
+		return u
+	}
+	stale := register("stale@example.com")
+	verified := register("verified@example.com")
+	if err := repo.MarkEmailVerified(ctx, verified.ID); err != nil {
+		t.Fatalf("MarkEmailVerified() error = %v", err)
+	}
+
+	// 未过期的账号继续占用邮箱
+	if id, err := uc.ReleaseStale(ctx, "stale@example.com"); err != nil || id != 0 {
+		t.Fatalf("ReleaseStale(fresh) = %d, %v, want 0", id, err)
+	}
+	time.Sleep(unverifiedTTL)
+	fresh := register("fresh@example.com")
+
+	tests := []struct {
+		email string
+		want  int64
+	}{
+		{"stale@example.com", stale.ID},
+		{"stale@example.com", 0}, // 已经释放过
+		{"verified@example.com", 0},
+		{"fresh@example.com", 0},
+		{"nobody@example.com", 0},
+	}
+	for _, tt := range tests {
+		if id, err := uc.ReleaseStale(ctx, tt.email); err != nil || id != tt.want {
+			t.Errorf("ReleaseStale(%s) = %d, %v, want %d", tt.email, id, err, tt.want)
+		}
+	}
+
+	// 过期账号失去邮箱登录方式, 账号本身保留; 邮箱可以绑定到新账号
+	if _, err := repo.FindByIdentity(ctx, biz.AuthTypeEmail, "stale@example.com"); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Fatalf("FindByIdentity(released) error = %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.FindByID(ctx, stale.ID); err != nil {
+		t.Fatalf("FindByID(stale) error = %v", err)
+	}
+	owner := register("stale@example.com")
+	if got, err := repo.FindByIdentity(ctx, biz.AuthTypeEmail, "stale@example.com"); err != nil || got.ID != owner.ID {
+		t.Fatalf("FindByIdentity(reclaimed) = %v, %v, want user %d", got, err, owner.ID)
+	}
+	if got, err := repo.FindByIdentity(ctx, biz.AuthTypeEmail, "fresh@example.com"); err != nil || got.ID != fresh.ID {
+		t.Fatalf("FindByIdentity(fresh) = %v, %v, want user %d", got, err, fresh.ID)
+	}
+}
+
+type nopEmailSender struct{}
+
+func (nopEmailSender) SendVerificationLink(context.Context, string, string, time.Duration) error {
+	return nil
+}
@@ -1,3 +1,3 @@
 package data
 
-//go:generate go run -mod=mod entgo.io/ent/cmd/ent generate --feature sql/modifier ./schema --target ./ent
+//go:generate go run -mod=mod entgo.io/ent/cmd/ent generate --feature sql/modifier,sql/versioned-migration ./schema --target ./ent
@@ -0,0 +1,84 @@
+package data
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+)
+
+const (
+	defaultIntrospectionCacheTTL = 5 * time.Second
+	// introspectionCacheSize 条目上限, 超过时先清理过期条目, 仍然超过则整体清空
+	introspectionCacheSize = 10000
+)
+
+// introspectionCache 进程内缓存令牌解析结果, 各副本独立
+// key 为令牌的 SHA-256, 不在内存中保留令牌原文
+type introspectionCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]introspectionEntry
+}
+
+type introspectionEntry struct {
+	claims    *pkg.CustomClaims
+	expiresAt time.Time
+}
+
+// NewIntrospectionCache 根据 conf.Data.Jwt.introspection_cache_ttl 创建令牌自省缓存
+func NewIntrospectionCache(c *conf.Data) biz.IntrospectionCache {
+	ttl := c.GetJwt().GetIntrospectionCacheTtl().AsDuration()
+	if ttl <= 0 {
+		ttl = defaultIntrospectionCacheTTL
+	}
+	return &introspectionCache{
+		ttl:     ttl,
+		entries: make(map[[sha256.Size]byte]introspectionEntry),
+	}
+}
+
+func (c *introspectionCache) Get(token string) (*pkg.CustomClaims, bool) {
+	key := sha256.Sum256([]byte(token))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !time.Now().Before(e.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.claims, true
+}
+
+// Set 缓存 ttl, 令牌先过期时以 exp 为准
+func (c *introspectionCache) Set(token string, claims *pkg.CustomClaims) {
+	now := time.Now()
+	expiresAt := now.Add(c.ttl)
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(expiresAt) {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	key := sha256.Sum256([]byte(token))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= introspectionCacheSize {
+		for k, e := range c.entries {
+			if !now.Before(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= introspectionCacheSize {
+			c.entries = make(map[[sha256.Size]byte]introspectionEntry)
+		}
+	}
+	c.entries[key] = introspectionEntry{claims: claims, expiresAt: expiresAt}
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/YangZhaoWeblog/UserService/internal/observability"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestIntrospectionCache_ExpiresWithToken(t *testing.T) {
+	cache := NewIntrospectionCache(&conf.Data{Jwt: &conf.Data_Jwt{IntrospectionCacheTtl: durationpb.New(time.Minute)}})
+
+	long := &pkg.CustomClaims{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}}
+	cache.Set("long", long)
+	if got, ok := cache.Get("long"); !ok || got != long {
+		t.Fatalf("Get(long) = %v, %v, want cached claims", got, ok)
+	}
+
+	// 令牌先于 ttl 过期时条目随令牌一起失效; NewNumericDate 会截断到秒, 这里直接构造
+	short := &pkg.CustomClaims{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: &jwt.NumericDate{Time: time.Now().Add(50 * time.Millisecond)}}}
+	cache.Set("short", short)
+	if _, ok := cache.Get("short"); !ok {
+		t.Fatal("Get(short) missed before exp")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := cache.Get("short"); ok {
+		t.Fatal("Get(short) hit after exp")
+	}
+	if _, ok := cache.Get("missing"); ok {
+		t.Fatal("Get(missing) hit")
+	}
+}
+
+// countingIntrospectionCache 记录命中次数
+type countingIntrospectionCache struct {
+	biz.IntrospectionCache
+	hits atomic.Int32
+}
+
+func (c *countingIntrospectionCache) Get(token string) (*pkg.CustomClaims, bool) {
+	claims, ok := c.IntrospectionCache.Get(token)
+	if ok {
+		c.hits.Add(1)
+	}
+	return claims, ok
+}
+
+func TestIntrospection_RevocationBypassesCache(t *testing.T) {
+	cfg := &conf.Data{Jwt: &conf.Data_Jwt{SigningKey: "secret", ExpiresTime: 60, IntrospectionCacheTtl: durationpb.New(time.Hour)}}
+	jwtCli, err := pkg.NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		revoke func(ctx context.Context, dl *tokenDenylist, claims *pkg.CustomClaims) error
+	}{
+		{"token", func(ctx context.Context, dl *tokenDenylist, c *pkg.CustomClaims) error {
+			return dl.Revoke(ctx, c.ID, c.ExpiresAt.Time)
+		}},
+		{"session", func(ctx context.Context, dl *tokenDenylist, c *pkg.CustomClaims) error {
+			return dl.RevokeSession(ctx, c.SessionID, time.Minute)
+		}},
+		{"user", func(ctx context.Context, dl *tokenDenylist, c *pkg.CustomClaims) error {
+			return dl.RevokeUser(ctx, 1, time.Now().Add(time.Second), time.Minute)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			d := newTestData(t)
+			// 吊销发生在另一个副本上, 经广播同步过来
+			local := newTestDenylist(t, d, &observability.MetricsData{})
+			remote := newTestDenylist(t, d, &observability.MetricsData{})
+			cache := &countingIntrospectionCache{IntrospectionCache: NewIntrospectionCache(cfg)}
+			uc := biz.NewIntrospectionUsecase(jwtCli, local, cache)
+
+			pair, err := jwtCli.GenerateToken(1, "alice", "family-1", []string{biz.ScopeUser})
+			if err != nil {
+				t.Fatalf("GenerateToken() error = %v", err)
+			}
+			for i := 0; i < 2; i++ {
+				if _, ok := uc.Introspect(ctx, pair.AccessToken); !ok {
+					t.Fatalf("Introspect() #%d inactive before revocation", i)
+				}
+			}
+			if n := cache.hits.Load(); n != 1 {
+				t.Fatalf("cache hits = %d, want 1", n)
+			}
+
+			claims, _ := cache.Get(pair.AccessToken)
+			if err := tt.revoke(ctx, remote, claims); err != nil {
+				t.Fatalf("revoke: %v", err)
+			}
+			waitFor(t, func() bool {
+				_, ok := uc.Introspect(ctx, pair.AccessToken)
+				return !ok
+			})
+			if _, ok := cache.Get(pair.AccessToken); !ok {
+				t.Fatal("entry evicted, revocation was not checked against a cache hit")
+			}
+		})
+	}
+}
+
+func TestIntrospection_RejectsOtherTokens(t *testing.T) {
+	ctx := context.Background()
+	cfg := &conf.Data{Jwt: &conf.Data_Jwt{SigningKey: "secret", ExpiresTime: 60}}
+	jwtCli, err := pkg.NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	cache := NewIntrospectionCache(cfg)
+	uc := biz.NewIntrospectionUsecase(jwtCli, newTestDenylist(t, newTestData(t), &observability.MetricsData{}), cache)
+
+	pair, err := jwtCli.GenerateToken(1, "alice", "family-1", nil)
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+	for name, token := range map[string]string{"refresh": pair.RefreshToken, "malformed": "junk", "empty": ""} {
+		if _, ok := uc.Introspect(ctx, token); ok {
+			t.Errorf("Introspect(%s) active", name)
+		}
+		if _, ok := cache.Get(token); ok {
+			t.Errorf("invalid %s token cached", name)
+		}
+	}
+}
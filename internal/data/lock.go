@@ -0,0 +1,49 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/redis/go-redis/v9"
+)
+
+// unlockScript 只删除自己持有的锁, 避免锁过期后误删其他副本的锁
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type redisLocker struct {
+	rdb *redis.Client
+}
+
+// NewLocker 创建基于 Redis 的分布式锁
+func NewLocker(data *Data) biz.Locker {
+	return &redisLocker{rdb: data.rdb}
+}
+
+// TryLock 通过 SET NX PX 加锁, value 为随机串用于安全释放
+func (l *redisLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, false, err
+	}
+	token := hex.EncodeToString(b)
+
+	ok, err := l.rdb.SetNX(ctx, "lock:"+key, token, ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	unlock := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = unlockScript.Run(ctx, l.rdb, []string{"lock:" + key}, token).Err()
+	}
+	return unlock, true, nil
+}
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRedisLocker(t *testing.T) {
+	ctx := context.Background()
+	d, mr := newTestDataWithRedis(t)
+	a, b := NewLocker(d), NewLocker(d)
+
+	unlock, ok, err := a.TryLock(ctx, "job", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("TryLock() = %v, %v, want locked", ok, err)
+	}
+	if _, ok, err := b.TryLock(ctx, "job", time.Minute); err != nil || ok {
+		t.Fatalf("TryLock(held) = %v, %v, want not locked", ok, err)
+	}
+	// 不同的 key 互不影响
+	if _, ok, err := b.TryLock(ctx, "other", time.Minute); err != nil || !ok {
+		t.Fatalf("TryLock(other) = %v, %v, want locked", ok, err)
+	}
+	if ttl := mr.TTL("lock:job"); ttl != time.Minute {
+		t.Errorf("lock ttl = %v, want 1m", ttl)
+	}
+
+	unlock()
+	unlockB, ok, err := b.TryLock(ctx, "job", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("TryLock(after unlock) = %v, %v, want locked", ok, err)
+	}
+	unlockB()
+}
+
+// 持有者超时后锁被别的副本拿走, 迟到的 unlock 不能释放别人的锁
+func TestRedisLocker_ExpiredHolderDoesNotUnlockOthers(t *testing.T) {
+	ctx := context.Background()
+	d, mr := newTestDataWithRedis(t)
+	a, b := NewLocker(d), NewLocker(d)
+
+	unlockA, ok, err := a.TryLock(ctx, "job", time.Second)
+	if err != nil || !ok {
+		t.Fatalf("TryLock() = %v, %v, want locked", ok, err)
+	}
+	mr.FastForward(2 * time.Second)
+	if _, ok, err := b.TryLock(ctx, "job", time.Minute); err != nil || !ok {
+		t.Fatalf("TryLock(after expiry) = %v, %v, want locked", ok, err)
+	}
+
+	unlockA()
+	if !mr.Exists("lock:job") {
+		t.Fatal("stale unlock released the new holder's lock")
+	}
+	if _, ok, _ := a.TryLock(ctx, "job", time.Minute); ok {
+		t.Fatal("lock acquired while held by another replica")
+	}
+}
@@ -0,0 +1,224 @@
+// Package migration 负责版本化迁移文件的执行、回滚与状态查询
+//
+// 迁移文件由 ent schema 经 atlas 比对生成, 采用 golang-migrate 的 up/down 文件格式,
+// 已执行的版本记录在 schema_revisions 表中
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"ariga.io/atlas/sql/migrate"
+	"ariga.io/atlas/sql/sqltool"
+)
+
+// RevisionTable 记录已执行迁移的表名
+const RevisionTable = "schema_revisions"
+
+// Revision 是一条已执行的迁移记录
+type Revision struct {
+	Version     string
+	Description string
+	AppliedAt   time.Time
+	ExecutionMs int64
+}
+
+// Status 是单个迁移文件的执行状态
+type Status struct {
+	Version     string
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+// Migrator 执行迁移目录中的迁移文件
+type Migrator struct {
+	db  *sql.DB
+	dir *sqltool.GolangMigrateDir
+}
+
+// NewMigrator 创建迁移执行器, path 为迁移目录
+func NewMigrator(db *sql.DB, path string) (*Migrator, error) {
+	dir, err := sqltool.NewGolangMigrateDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("open migration dir failed: %w", err)
+	}
+	return &Migrator{db: db, dir: dir}, nil
+}
+
+// Up 依次执行未执行的迁移, n <= 0 时执行全部, 返回本次执行的版本
+func (m *Migrator) Up(ctx context.Context, n int) ([]string, error) {
+	if err := migrate.Validate(m.dir); err != nil {
+		return nil, fmt.Errorf("validate migration dir failed: %w", err)
+	}
+	files, applied, err := m.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []string
+	for _, f := range files {
+		if n > 0 && len(done) >= n {
+			break
+		}
+		if _, ok := applied[f.Version()]; ok {
+			continue
+		}
+		if err := m.apply(ctx, f); err != nil {
+			return done, err
+		}
+		done = append(done, f.Version())
+	}
+	return done, nil
+}
+
+// Down 按执行顺序倒序回滚最近的 n 个迁移, n <= 0 时回滚 1 个, 返回本次回滚的版本
+func (m *Migrator) Down(ctx context.Context, n int) ([]string, error) {
+	if n <= 0 {
+		n = 1
+	}
+	files, applied, err := m.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []string
+	for i := len(files) - 1; i >= 0 && len(done) < n; i-- {
+		f := files[i]
+		if _, ok := applied[f.Version()]; !ok {
+			continue
+		}
+		if err := m.revert(ctx, f); err != nil {
+			return done, err
+		}
+		done = append(done, f.Version())
+	}
+	return done, nil
+}
+
+// Status 返回迁移目录中每个文件的执行状态
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	files, applied, err := m.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Status, 0, len(files))
+	for _, f := range files {
+		s := Status{Version: f.Version(), Description: f.Desc()}
+		if rev, ok := applied[f.Version()]; ok {
+			s.Applied = true
+			s.AppliedAt = rev.AppliedAt
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// load 读取迁移文件与已执行的版本
+func (m *Migrator) load(ctx context.Context) ([]migrate.File, map[string]*Revision, error) {
+	if err := m.ensureRevisionTable(ctx); err != nil {
+		return nil, nil, err
+	}
+	files, err := m.dir.Files()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read migration files failed: %w", err)
+	}
+	applied, err := m.revisions(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return files, applied, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, f migrate.File) error {
+	stmts, err := f.Stmts()
+	if err != nil {
+		return fmt.Errorf("parse %s failed: %w", f.Name(), err)
+	}
+
+	start := time.Now()
+	return m.inTx(ctx, f.Name(), stmts, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO "+RevisionTable+" (version, description, applied_at, execution_ms) VALUES (?, ?, ?, ?)",
+			f.Version(), f.Desc(), time.Now(), time.Since(start).Milliseconds())
+		return err
+	})
+}
+
+func (m *Migrator) revert(ctx context.Context, f migrate.File) error {
+	name := strings.TrimSuffix(f.Name(), ".up.sql") + ".down.sql"
+	b, err := fs.ReadFile(m.dir, name)
+	if err != nil {
+		return fmt.Errorf("read %s failed: %w", name, err)
+	}
+	stmts, err := migrate.NewLocalFile(name, b).Stmts()
+	if err != nil {
+		return fmt.Errorf("parse %s failed: %w", name, err)
+	}
+
+	return m.inTx(ctx, name, stmts, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM "+RevisionTable+" WHERE version = ?", f.Version())
+		return err
+	})
+}
+
+// inTx 在事务中执行迁移语句并更新版本记录
+// 注意: MySQL 的 DDL 会隐式提交, 失败时需要人工检查执行到了哪一条
+func (m *Migrator) inTx(ctx context.Context, name string, stmts []string, record func(*sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("execute %s failed: %w\n%s", name, err, stmt)
+		}
+	}
+	if err := record(tx); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("record revision of %s failed: %w", name, err)
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) ensureRevisionTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+RevisionTable+` (
+	version VARCHAR(64) NOT NULL PRIMARY KEY,
+	description VARCHAR(255) NOT NULL,
+	applied_at TIMESTAMP NOT NULL,
+	execution_ms BIGINT NOT NULL
+)`)
+	if err != nil {
+		return fmt.Errorf("create revision table failed: %w", err)
+	}
+	return nil
+}
+
+func (m *Migrator) revisions(ctx context.Context) (map[string]*Revision, error) {
+	rows, err := m.db.QueryContext(ctx,
+		"SELECT version, description, applied_at, execution_ms FROM "+RevisionTable+" ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("query revisions failed: %w", err)
+	}
+	defer rows.Close()
+
+	revs := make(map[string]*Revision)
+	for rows.Next() {
+		var r Revision
+		if err := rows.Scan(&r.Version, &r.Description, &r.AppliedAt, &r.ExecutionMs); err != nil {
+			return nil, err
+		}
+		revs[r.Version] = &r
+	}
+	if err := rows.Err(); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return revs, nil
+}
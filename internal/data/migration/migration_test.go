@@ -0,0 +1,203 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ariga.io/atlas/sql/migrate"
+	"ariga.io/atlas/sql/sqltool"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// writeDir 把迁移文件写入临时目录并生成 atlas.sum
+func writeDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	path := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(path, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	dir, err := sqltool.NewGolangMigrateDir(path)
+	if err != nil {
+		t.Fatalf("NewGolangMigrateDir() error = %v", err)
+	}
+	sum, err := dir.Checksum()
+	if err != nil {
+		t.Fatalf("Checksum() error = %v", err)
+	}
+	if err := migrate.WriteSumFile(dir, sum); err != nil {
+		t.Fatalf("WriteSumFile() error = %v", err)
+	}
+	return path
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func newTestMigrator(t *testing.T, db *sql.DB, files map[string]string) *Migrator {
+	t.Helper()
+	m, err := NewMigrator(db, writeDir(t, files))
+	if err != nil {
+		t.Fatalf("NewMigrator() error = %v", err)
+	}
+	return m
+}
+
+// applied 返回每个迁移文件是否已执行
+func applied(t *testing.T, m *Migrator) []bool {
+	t.Helper()
+	list, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	got := make([]bool, 0, len(list))
+	for _, s := range list {
+		got = append(got, s.Applied)
+	}
+	return got
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n); err != nil {
+		t.Fatalf("query sqlite_master: %v", err)
+	}
+	return n == 1
+}
+
+var testFiles = map[string]string{
+	"1_users.up.sql":           "CREATE TABLE users (id INTEGER PRIMARY KEY);\n",
+	"1_users.down.sql":         "DROP TABLE users;\n",
+	"2_identities.up.sql":      "CREATE TABLE identities (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL);\n",
+	"2_identities.down.sql":    "DROP TABLE identities;\n",
+	"3_user_email.up.sql":      "ALTER TABLE users ADD COLUMN email TEXT NULL;\n",
+	"3_user_email.down.sql":    "ALTER TABLE users DROP COLUMN email;\n",
+	"4_user_profiles.up.sql":   "CREATE TABLE profiles (id INTEGER PRIMARY KEY);\n",
+	"4_user_profiles.down.sql": "DROP TABLE profiles;\n",
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	m := newTestMigrator(t, db, testFiles)
+
+	if got := applied(t, m); !reflect.DeepEqual(got, []bool{false, false, false, false}) {
+		t.Fatalf("Status() before Up = %v", got)
+	}
+	if done, err := m.Up(ctx, 1); err != nil || !reflect.DeepEqual(done, []string{"1"}) {
+		t.Fatalf("Up(1) = %v, %v, want [1]", done, err)
+	}
+	if done, err := m.Up(ctx, 0); err != nil || !reflect.DeepEqual(done, []string{"2", "3", "4"}) {
+		t.Fatalf("Up(0) = %v, %v, want [2 3 4]", done, err)
+	}
+	if done, err := m.Up(ctx, 0); err != nil || len(done) != 0 {
+		t.Fatalf("Up(0) again = %v, %v, want nothing", done, err)
+	}
+	if _, err := db.Exec("INSERT INTO users (id, email) VALUES (1, 'a@example.com')"); err != nil {
+		t.Fatalf("schema after Up: %v", err)
+	}
+
+	// 默认回滚一个, 按执行顺序倒序回滚
+	if done, err := m.Down(ctx, 0); err != nil || !reflect.DeepEqual(done, []string{"4"}) {
+		t.Fatalf("Down(0) = %v, %v, want [4]", done, err)
+	}
+	if done, err := m.Down(ctx, 2); err != nil || !reflect.DeepEqual(done, []string{"3", "2"}) {
+		t.Fatalf("Down(2) = %v, %v, want [3 2]", done, err)
+	}
+	if got := applied(t, m); !reflect.DeepEqual(got, []bool{true, false, false, false}) {
+		t.Fatalf("Status() after Down = %v", got)
+	}
+	if tableExists(t, db, "identities") || !tableExists(t, db, "users") {
+		t.Fatal("Down reverted the wrong migrations")
+	}
+
+	// 回滚后可以重新执行
+	if done, err := m.Up(ctx, 0); err != nil || !reflect.DeepEqual(done, []string{"2", "3", "4"}) {
+		t.Fatalf("Up(0) after Down = %v, %v, want [2 3 4]", done, err)
+	}
+}
+
+func TestMigrator_FailedMigrationIsNotRecorded(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	m := newTestMigrator(t, db, map[string]string{
+		"1_users.up.sql":     "CREATE TABLE users (id INTEGER PRIMARY KEY);\n",
+		"1_users.down.sql":   "DROP TABLE users;\n",
+		"2_broken.up.sql":    "CREATE TABLE partial (id INTEGER PRIMARY KEY);\nALTER TABLE missing ADD COLUMN x TEXT;\n",
+		"2_broken.down.sql":  "DROP TABLE partial;\n",
+		"3_pending.up.sql":   "CREATE TABLE pending (id INTEGER PRIMARY KEY);\n",
+		"3_pending.down.sql": "DROP TABLE pending;\n",
+	})
+
+	done, err := m.Up(ctx, 0)
+	if err == nil || !strings.Contains(err.Error(), "2_broken.up.sql") {
+		t.Fatalf("Up() error = %v, want failure in 2_broken.up.sql", err)
+	}
+	if !reflect.DeepEqual(done, []string{"1"}) {
+		t.Fatalf("Up() applied %v, want [1]", done)
+	}
+	if got := applied(t, m); !reflect.DeepEqual(got, []bool{true, false, false}) {
+		t.Fatalf("Status() = %v, want only 1 applied", got)
+	}
+	// SQLite 的 DDL 支持事务, 失败文件中已执行的语句随之回滚, 后续文件不再执行
+	if tableExists(t, db, "partial") || tableExists(t, db, "pending") {
+		t.Fatal("statements after the failure were applied")
+	}
+}
+
+func TestMigrator_RejectsTamperedDir(t *testing.T) {
+	db := openTestDB(t)
+	path := writeDir(t, testFiles)
+	// 生成 atlas.sum 之后改动已有的迁移文件
+	if err := os.WriteFile(filepath.Join(path, "1_users.up.sql"), []byte("CREATE TABLE users (id INTEGER);\n"), 0o644); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	m, err := NewMigrator(db, path)
+	if err != nil {
+		t.Fatalf("NewMigrator() error = %v", err)
+	}
+	if _, err := m.Up(context.Background(), 0); err == nil {
+		t.Fatal("Up() accepted a directory that does not match atlas.sum")
+	}
+	if tableExists(t, db, "users") {
+		t.Fatal("tampered migration was applied")
+	}
+}
+
+// 仓库中的迁移目录必须与 atlas.sum 一致, 且每个 up 都有对应的 down
+func TestMigrationsDir(t *testing.T) {
+	dir, err := sqltool.NewGolangMigrateDir("../migrations")
+	if err != nil {
+		t.Fatalf("NewGolangMigrateDir() error = %v", err)
+	}
+	if err := migrate.Validate(dir); err != nil {
+		t.Fatalf("Validate() error = %v, regenerate atlas.sum", err)
+	}
+	files, err := dir.Files()
+	if err != nil {
+		t.Fatalf("Files() error = %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no migrations")
+	}
+	for _, f := range files {
+		down := strings.TrimSuffix(f.Name(), ".up.sql") + ".down.sql"
+		if _, err := os.Stat(filepath.Join("../migrations", down)); err != nil {
+			t.Errorf("%s has no %s", f.Name(), down)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+-- reverse: create "users" table
+DROP TABLE `users`;
+-- reverse: create "app_logs" table
+DROP TABLE `app_logs`;
@@ -0,0 +1,4 @@
+-- create "app_logs" table
+CREATE TABLE `app_logs` (`id` bigint NOT NULL AUTO_INCREMENT, `time` timestamp NOT NULL, `level` varchar(255) NOT NULL, `msg` varchar(255) NOT NULL, `kind` varchar(255) NULL, `component` varchar(255) NULL, `operation` varchar(255) NULL, `user_id` bigint NULL, `trace_id` varchar(255) NULL, `span_id` varchar(255) NULL, `args` varchar(255) NULL, `code` bigint NULL, `reason` varchar(255) NULL, `stack` varchar(255) NULL, `latency` double NULL, `created_at` timestamp NOT NULL, `extra` json NOT NULL, `app_name` varchar(255) NOT NULL DEFAULT '', PRIMARY KEY (`id`), INDEX `applog_time` (`time`), INDEX `applog_trace_id` (`trace_id`), INDEX `applog_code` (`code`)) CHARSET utf8mb4 COLLATE utf8mb4_bin;
+-- create "users" table
+CREATE TABLE `users` (`id` bigint NOT NULL AUTO_INCREMENT, `username` varchar(255) NULL, `nickname` varchar(255) NOT NULL DEFAULT '', `avatar` varchar(255) NOT NULL DEFAULT '', `auth_type` varchar(255) NOT NULL DEFAULT '', `phone` varchar(255) NULL, `created_at` timestamp NOT NULL, `updated_at` timestamp NOT NULL, PRIMARY KEY (`id`), UNIQUE INDEX `username` (`username`), UNIQUE INDEX `phone` (`phone`), INDEX `user_created_at` (`created_at`)) CHARSET utf8mb4 COLLATE utf8mb4_bin;
@@ -0,0 +1,2 @@
+-- reverse: modify "app_logs" table
+ALTER TABLE `app_logs` MODIFY COLUMN `stack` varchar(255) NULL, MODIFY COLUMN `args` varchar(255) NULL, MODIFY COLUMN `msg` varchar(255) NOT NULL;
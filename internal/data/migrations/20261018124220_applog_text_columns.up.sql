@@ -0,0 +1,2 @@
+-- modify "app_logs" table
+ALTER TABLE `app_logs` MODIFY COLUMN `msg` longtext NOT NULL, MODIFY COLUMN `args` longtext NULL, MODIFY COLUMN `stack` longtext NULL;
@@ -0,0 +1,2 @@
+-- reverse: create "user_identities" table
+DROP TABLE `user_identities`;
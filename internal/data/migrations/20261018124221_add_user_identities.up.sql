@@ -0,0 +1,2 @@
+-- create "user_identities" table
+CREATE TABLE `user_identities` (`id` bigint NOT NULL AUTO_INCREMENT, `provider` varchar(255) NOT NULL, `subject` varchar(255) NOT NULL, `created_at` timestamp NOT NULL, `user_id` bigint NOT NULL, PRIMARY KEY (`id`), UNIQUE INDEX `useridentity_provider_subject` (`provider`, `subject`), INDEX `useridentity_user_id` (`user_id`), CONSTRAINT `user_identities_users_identities` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE NO ACTION) CHARSET utf8mb4 COLLATE utf8mb4_bin;
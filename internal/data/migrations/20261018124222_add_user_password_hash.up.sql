@@ -0,0 +1,2 @@
+-- modify "users" table
+ALTER TABLE `users` ADD COLUMN `password_hash` varchar(255) NULL;
@@ -0,0 +1,2 @@
+-- reverse: modify "user_identities" table
+ALTER TABLE `user_identities` DROP COLUMN `email`;
@@ -0,0 +1,2 @@
+-- modify "user_identities" table
+ALTER TABLE `user_identities` ADD COLUMN `email` varchar(255) NULL;
@@ -0,0 +1,2 @@
+-- modify "users" table
+ALTER TABLE `users` ADD COLUMN `email_verified` bool NOT NULL DEFAULT false;
@@ -0,0 +1,23 @@
+//go:build dev
+
+package data
+
+import (
+	"fmt"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// embeddedRedis 启动进程内的 miniredis, 本地运行不依赖任何外部服务
+func embeddedRedis() (*redis.Client, func(), error) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		return nil, nil, fmt.Errorf("start embedded redis failed: %w", err)
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	return rdb, func() {
+		_ = rdb.Close()
+		mr.Close()
+	}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !dev
+
+package data
+
+import (
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// embeddedRedis 生产构建不包含进程内 Redis, 未配置 Redis 地址时直接启动失败
+func embeddedRedis() (*redis.Client, func(), error) {
+	return nil, nil, fmt.Errorf("redis addr is not configured: set data.redis.addr or build with -tags dev")
+}
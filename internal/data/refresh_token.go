@@ -0,0 +1,111 @@
+package data
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/redis/go-redis/v9"
+)
+
+// refreshReuseGrace 轮换后上一个令牌的宽限期, 覆盖客户端并发刷新的时间差
+// 宽限期内提交上一个令牌只是输掉了并发, 不当作泄露处理
+const refreshReuseGrace = 10 * time.Second
+
+// rotateRefreshScript 比较并替换家族的当前令牌, 并发刷新时只有一个请求能成功
+// 同时延长用户家族集合的有效期, 保证不早于其中任何一个家族过期
+// ARGV: user_id, oldID, newID, ttl(ms), 当前时间(ms), 宽限期(ms)
+// 返回 1 表示成功, 0 表示家族不存在或用户不符, -2 表示 oldID 刚被并发的请求轮换过,
+// -1 表示令牌已被使用过, 此时整个家族被删除
+var rotateRefreshScript = redis.NewScript(`
+local f = redis.call("HMGET", KEYS[1], "user_id", "current", "previous", "rotated_at")
+if not f[1] or f[1] ~= ARGV[1] then
+	return 0
+end
+if f[2] ~= ARGV[2] then
+	if f[3] == ARGV[2] and tonumber(ARGV[5]) - tonumber(f[4]) <= tonumber(ARGV[6]) then
+		return -2
+	end
+	redis.call("DEL", KEYS[1])
+	return -1
+end
+redis.call("HSET", KEYS[1], "current", ARGV[3], "previous", ARGV[2], "rotated_at", ARGV[5])
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+if redis.call("PTTL", KEYS[2]) < tonumber(ARGV[4]) then
+	redis.call("PEXPIRE", KEYS[2], ARGV[4])
+end
+return 1
+`)
+
+type refreshTokenRepo struct {
+	rdb *redis.Client
+	now func() time.Time
+}
+
+// NewRefreshTokenRepo 创建基于 Redis 的刷新令牌家族仓库
+func NewRefreshTokenRepo(data *Data) biz.RefreshTokenRepo {
+	return &refreshTokenRepo{rdb: data.rdb, now: time.Now}
+}
+
+func refreshFamilyKey(family string) string {
+	return "rt:family:" + family
+}
+
+// refreshUserKey 记录用户的所有家族, 供注销全部会话使用, 已失效的家族在注销时一并清理
+func refreshUserKey(userID int64) string {
+	return "rt:user:" + strconv.FormatInt(userID, 10)
+}
+
+// Create 新建家族, 家族随最后一次签发的刷新令牌一起过期
+func (r *refreshTokenRepo) Create(ctx context.Context, family string, userID int64, tokenID string, ttl time.Duration) error {
+	key, userKey := refreshFamilyKey(family), refreshUserKey(userID)
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "user_id", userID, "current", tokenID)
+		pipe.Expire(ctx, key, ttl)
+		pipe.SAdd(ctx, userKey, family)
+		pipe.Expire(ctx, userKey, ttl)
+		return nil
+	})
+	return err
+}
+
+// Revoke 撤销整个家族
+func (r *refreshTokenRepo) Revoke(ctx context.Context, family string) error {
+	return r.rdb.Del(ctx, refreshFamilyKey(family)).Err()
+}
+
+// RevokeUser 撤销用户的所有家族
+func (r *refreshTokenRepo) RevokeUser(ctx context.Context, userID int64) error {
+	userKey := refreshUserKey(userID)
+	families, err := r.rdb.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(families)+1)
+	for _, f := range families {
+		keys = append(keys, refreshFamilyKey(f))
+	}
+	keys = append(keys, userKey)
+	return r.rdb.Del(ctx, keys...).Err()
+}
+
+// Rotate 轮换家族的当前令牌
+func (r *refreshTokenRepo) Rotate(ctx context.Context, family string, userID int64, oldID, newID string, ttl time.Duration) error {
+	n, err := rotateRefreshScript.Run(ctx, r.rdb, []string{refreshFamilyKey(family), refreshUserKey(userID)},
+		strconv.FormatInt(userID, 10), oldID, newID, ttl.Milliseconds(),
+		r.now().UnixMilli(), refreshReuseGrace.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	switch n {
+	case 1:
+		return nil
+	case -2:
+		return biz.ErrRefreshTokenSuperseded
+	case -1:
+		return biz.ErrRefreshTokenReused
+	default:
+		return biz.ErrInvalidRefreshToken
+	}
+}
@@ -0,0 +1,138 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+)
+
+func newTestRefreshTokenRepo(t *testing.T) (*refreshTokenRepo, *time.Time, func(string) bool) {
+	t.Helper()
+	d, mr := newTestDataWithRedis(t)
+	now := time.Unix(1700000000, 0)
+	repo := NewRefreshTokenRepo(d).(*refreshTokenRepo)
+	repo.now = func() time.Time { return now }
+	return repo, &now, mr.Exists
+}
+
+func TestRefreshTokenRepo_Rotate(t *testing.T) {
+	ctx := context.Background()
+	repo, _, exists := newTestRefreshTokenRepo(t)
+	if err := repo.Create(ctx, "f1", 1, "t1", time.Hour); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if err := repo.Rotate(ctx, "f1", 1, "t1", "t2", time.Hour); err != nil {
+		t.Fatalf("Rotate(t1) error = %v", err)
+	}
+	if err := repo.Rotate(ctx, "f1", 1, "t2", "t3", time.Hour); err != nil {
+		t.Fatalf("Rotate(t2) error = %v", err)
+	}
+	if err := repo.Rotate(ctx, "f1", 2, "t3", "t4", time.Hour); !errors.Is(err, biz.ErrInvalidRefreshToken) {
+		t.Fatalf("Rotate(other user) error = %v, want ErrInvalidRefreshToken", err)
+	}
+	if err := repo.Rotate(ctx, "missing", 1, "t1", "t2", time.Hour); !errors.Is(err, biz.ErrInvalidRefreshToken) {
+		t.Fatalf("Rotate(missing family) error = %v, want ErrInvalidRefreshToken", err)
+	}
+	if !exists(refreshFamilyKey("f1")) {
+		t.Fatal("family deleted by an invalid rotation")
+	}
+}
+
+func TestRefreshTokenRepo_ConcurrentRefresh(t *testing.T) {
+	ctx := context.Background()
+	repo, now, exists := newTestRefreshTokenRepo(t)
+	if err := repo.Create(ctx, "f1", 1, "t1", time.Hour); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := repo.Rotate(ctx, "f1", 1, "t1", "t2", time.Hour); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	// 宽限期内再次提交上一个令牌只是输掉了并发, 家族保持不变
+	*now = now.Add(refreshReuseGrace)
+	if err := repo.Rotate(ctx, "f1", 1, "t1", "t2b", time.Hour); !errors.Is(err, biz.ErrRefreshTokenSuperseded) {
+		t.Fatalf("Rotate(previous) error = %v, want ErrRefreshTokenSuperseded", err)
+	}
+	if !exists(refreshFamilyKey("f1")) {
+		t.Fatal("family revoked by a concurrent refresh")
+	}
+	if err := repo.Rotate(ctx, "f1", 1, "t2", "t3", time.Hour); err != nil {
+		t.Fatalf("Rotate(current) after superseded error = %v", err)
+	}
+}
+
+func TestRefreshTokenRepo_Reuse(t *testing.T) {
+	tests := []struct {
+		name    string
+		advance time.Duration
+		oldID   string
+	}{
+		{"previous after grace", refreshReuseGrace + time.Millisecond, "t2"},
+		{"older than previous", 0, "t1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo, now, exists := newTestRefreshTokenRepo(t)
+			if err := repo.Create(ctx, "f1", 1, "t1", time.Hour); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			for _, ids := range [][2]string{{"t1", "t2"}, {"t2", "t3"}} {
+				if err := repo.Rotate(ctx, "f1", 1, ids[0], ids[1], time.Hour); err != nil {
+					t.Fatalf("Rotate(%s) error = %v", ids[0], err)
+				}
+			}
+
+			*now = now.Add(tt.advance)
+			if err := repo.Rotate(ctx, "f1", 1, tt.oldID, "x", time.Hour); !errors.Is(err, biz.ErrRefreshTokenReused) {
+				t.Fatalf("Rotate(%s) error = %v, want ErrRefreshTokenReused", tt.oldID, err)
+			}
+			if exists(refreshFamilyKey("f1")) {
+				t.Fatal("family not revoked after reuse")
+			}
+			// 家族撤销后当前令牌也一并失效
+			if err := repo.Rotate(ctx, "f1", 1, "t3", "t4", time.Hour); !errors.Is(err, biz.ErrInvalidRefreshToken) {
+				t.Fatalf("Rotate(current) after reuse error = %v, want ErrInvalidRefreshToken", err)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenRepo_Revoke(t *testing.T) {
+	ctx := context.Background()
+	repo, _, exists := newTestRefreshTokenRepo(t)
+	for _, f := range []string{"f1", "f2", "f3"} {
+		if err := repo.Create(ctx, f, 1, f+"-t1", time.Hour); err != nil {
+			t.Fatalf("Create(%s) error = %v", f, err)
+		}
+	}
+	if err := repo.Create(ctx, "other", 2, "o-t1", time.Hour); err != nil {
+		t.Fatalf("Create(other) error = %v", err)
+	}
+
+	if err := repo.Revoke(ctx, "f1"); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if err := repo.Rotate(ctx, "f1", 1, "f1-t1", "f1-t2", time.Hour); !errors.Is(err, biz.ErrInvalidRefreshToken) {
+		t.Fatalf("Rotate(revoked) error = %v, want ErrInvalidRefreshToken", err)
+	}
+	if err := repo.Revoke(ctx, "f1"); err != nil {
+		t.Fatalf("Revoke(again) error = %v", err)
+	}
+
+	if err := repo.RevokeUser(ctx, 1); err != nil {
+		t.Fatalf("RevokeUser() error = %v", err)
+	}
+	for _, key := range []string{refreshFamilyKey("f2"), refreshFamilyKey("f3"), refreshUserKey(1)} {
+		if exists(key) {
+			t.Errorf("key %s still exists after RevokeUser", key)
+		}
+	}
+	if err := repo.Rotate(ctx, "other", 2, "o-t1", "o-t2", time.Hour); err != nil {
+		t.Fatalf("Rotate(other user) error = %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// UserIdentity 用户身份表, 一个用户可以绑定多个登录方式
+// (provider, subject) 全局唯一, 例如 ("phone", 手机号)、("google", Google 账号的 sub)
+type UserIdentity struct {
+	ent.Schema
+}
+
+// Fields of the UserIdentity.
+func (UserIdentity) Fields() []ent.Field {
+	return []ent.Field{
+		field.Int64("user_id"),
+		field.String("provider"),
+		field.String("subject"),
+		// 第三方账号提供的已验证邮箱, 只做展示, 不参与登录
+		field.String("email").
+			Optional(),
+		field.Time("created_at").
+			Default(time.Now).
+			Immutable(),
+	}
+}
+
+// Edges of the UserIdentity.
+func (UserIdentity) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("user", User.Type).
+			Ref("identities").
+			Field("user_id").
+			Unique().
+			Required(),
+	}
+}
+
+// Indexes of the UserIdentity.
+func (UserIdentity) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("provider", "subject").
+			Unique(),
+		index.Fields("user_id"),
+	}
+}
@@ -20,7 +20,7 @@ func (AppLog) Fields() []ent.Field {
 	return []ent.Field{
 		field.Time("time"),
 		field.String("level"),
-		field.String("msg"),
+		field.Text("msg"),
 		field.String("kind").
 			Optional(),
 		field.String("component").
@@ -33,13 +33,13 @@ func (AppLog) Fields() []ent.Field {
 			Optional(),
 		field.String("span_id").
 			Optional(),
-		field.String("args").
+		field.Text("args").
 			Optional(),
 		field.Int("code").
 			Optional(),
 		field.String("reason").
 			Optional(),
-		field.String("stack").
+		field.Text("stack").
 			Optional(),
 		field.Float("latency").
 			Optional(),
@@ -0,0 +1,61 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// User 用户表
+type User struct {
+	ent.Schema
+}
+
+// Fields of the User.
+func (User) Fields() []ent.Field {
+	return []ent.Field{
+		field.Int64("id"),
+		field.String("username").
+			Optional().
+			Nillable().
+			Unique(),
+		field.String("nickname").
+			Default(""),
+		field.String("avatar").
+			Default(""),
+		field.String("auth_type").
+			Default(""),
+		field.String("phone").
+			Optional().
+			Nillable().
+			Unique(),
+		field.String("password_hash").
+			Optional().
+			Sensitive(),
+		field.Bool("email_verified").
+			Default(false),
+		field.Time("created_at").
+			Default(time.Now).
+			Immutable(),
+		field.Time("updated_at").
+			Default(time.Now).
+			UpdateDefault(time.Now),
+	}
+}
+
+// Edges of the User.
+func (User) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.To("identities", UserIdentity.Type),
+	}
+}
+
+// Indexes of the User.
+func (User) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("created_at"),
+	}
+}
@@ -0,0 +1,248 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	sessionKeyPrefix = "session:"
+
+	defaultMaxDevices = 5
+
+	// createSessionAttempts 是用户的会话集合被并发修改时 Create 的最多尝试次数
+	createSessionAttempts = 5
+)
+
+// errSessionContention 同一用户并发登录过多, Create 多次重试仍未成功
+var errSessionContention = errors.New("session: too many concurrent changes")
+
+// createSessionScript 记录会话并挤掉多余的旧会话, 返回被挤掉的会话 ID
+// KEYS[1] 会话, KEYS[2] 用户的会话集合(按创建时间排序), KEYS[3..] 集合中已有会话的 key
+// ARGV: 会话 ID, ttl(ms), 设备数上限, 已有会话数 n, n 个已有会话 ID(与 KEYS[3..] 一一对应), 之后为 HSET 的字段与值
+// 已有会话在调用前读出并作为 KEYS 传入, 脚本只访问声明过的 key; 期间集合有变化时不做修改, 返回 false 由调用方重试
+// 集合中已过期的会话顺带清理; 同一设备的旧会话与超出上限的最早会话被删除
+var createSessionScript = redis.NewScript(`
+local id, ttl, max, n = ARGV[1], tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+local members = redis.call("ZRANGE", KEYS[2], 0, -1)
+if #members ~= n then
+	return false
+end
+for i = 1, n do
+	if members[i] ~= ARGV[4 + i] then
+		return false
+	end
+end
+
+local fields = {}
+for i = 5 + n, #ARGV do
+	fields[#fields + 1] = ARGV[i]
+end
+redis.call("HSET", KEYS[1], unpack(fields))
+redis.call("PEXPIRE", KEYS[1], ttl)
+
+local device = redis.call("HGET", KEYS[1], "device_id")
+local evicted = {}
+local alive = {}
+for i = 1, n do
+	local m, key = members[i], KEYS[2 + i]
+	if m ~= id then
+		local d = redis.call("HGET", key, "device_id")
+		if not d then
+			redis.call("ZREM", KEYS[2], m)
+		elseif device ~= "" and d == device then
+			redis.call("ZREM", KEYS[2], m)
+			redis.call("DEL", key)
+			evicted[#evicted + 1] = m
+		else
+			alive[#alive + 1] = i
+		end
+	end
+end
+
+if max > 0 then
+	for j = 1, #alive - max + 1 do
+		local i = alive[j]
+		redis.call("ZREM", KEYS[2], members[i])
+		redis.call("DEL", KEYS[2 + i])
+		evicted[#evicted + 1] = members[i]
+	end
+end
+
+redis.call("ZADD", KEYS[2], redis.call("HGET", KEYS[1], "created_at"), id)
+if redis.call("PTTL", KEYS[2]) < ttl then
+	redis.call("PEXPIRE", KEYS[2], ttl)
+end
+return evicted
+`)
+
+// touchSessionScript 更新最近活跃时间, 会话不存在或不属于该用户时返回 0
+// KEYS[1] 会话, KEYS[2] 用户的会话集合; ARGV: 用户 ID, 当前时间(ms), IP, ttl(ms)
+var touchSessionScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "user_id") ~= ARGV[1] then
+	return 0
+end
+redis.call("HSET", KEYS[1], "last_seen_at", ARGV[2])
+if ARGV[3] ~= "" then
+	redis.call("HSET", KEYS[1], "ip", ARGV[3])
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+if redis.call("PTTL", KEYS[2]) < tonumber(ARGV[4]) then
+	redis.call("PEXPIRE", KEYS[2], ARGV[4])
+end
+return 1
+`)
+
+// deleteSessionScript 删除属于该用户的会话, 不存在或不属于该用户时返回 0
+// KEYS[1] 会话, KEYS[2] 用户的会话集合; ARGV: 用户 ID, 会话 ID
+var deleteSessionScript = redis.NewScript(`
+redis.call("ZREM", KEYS[2], ARGV[2])
+if redis.call("HGET", KEYS[1], "user_id") ~= ARGV[1] then
+	return 0
+end
+redis.call("DEL", KEYS[1])
+return 1
+`)
+
+// sessionRepo 把会话保存在 Redis, 每个会话一个 hash, 另有按创建时间排序的用户会话集合
+type sessionRepo struct {
+	rdb        *redis.Client
+	maxDevices int
+}
+
+// NewSessionRepo 根据 conf.Data.Session 创建会话仓库
+func NewSessionRepo(c *conf.Data, data *Data) biz.SessionRepo {
+	maxDevices := int(c.GetSession().GetMaxDevices())
+	if maxDevices == 0 {
+		maxDevices = defaultMaxDevices
+	}
+	return &sessionRepo{rdb: data.rdb, maxDevices: maxDevices}
+}
+
+func sessionKey(id string) string {
+	return sessionKeyPrefix + id
+}
+
+func userSessionsKey(userID int64) string {
+	return "session:user:" + strconv.FormatInt(userID, 10)
+}
+
+// Create 记录新会话, 同一设备的旧会话与超出上限的最早会话一并删除
+func (r *sessionRepo) Create(ctx context.Context, s *biz.Session, ttl time.Duration) ([]string, error) {
+	userKey := userSessionsKey(s.UserID)
+	for i := 0; i < createSessionAttempts; i++ {
+		members, err := r.rdb.ZRange(ctx, userKey, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		keys := make([]string, 0, len(members)+2)
+		keys = append(keys, sessionKey(s.ID), userKey)
+		args := make([]any, 0, len(members)+16)
+		args = append(args, s.ID, ttl.Milliseconds(), r.maxDevices, len(members))
+		for _, m := range members {
+			keys = append(keys, sessionKey(m))
+			args = append(args, m)
+		}
+		args = append(args,
+			"user_id", s.UserID,
+			"device_id", s.DeviceID,
+			"user_agent", s.UserAgent,
+			"ip", s.IP,
+			"created_at", s.CreatedAt.UnixMilli(),
+			"last_seen_at", s.LastSeenAt.UnixMilli(),
+		)
+
+		evicted, err := createSessionScript.Run(ctx, r.rdb, keys, args...).StringSlice()
+		if errors.Is(err, redis.Nil) {
+			// 读出集合之后同一用户又有会话创建或删除, 重新读取
+			continue
+		}
+		return evicted, err
+	}
+	return nil, errSessionContention
+}
+
+// Touch 更新最近活跃时间与 IP, 本功能上线前登录的会话没有记录, 直接忽略
+func (r *sessionRepo) Touch(ctx context.Context, userID int64, id, ip string, ttl time.Duration) error {
+	return touchSessionScript.Run(ctx, r.rdb,
+		[]string{sessionKey(id), userSessionsKey(userID)},
+		strconv.FormatInt(userID, 10), time.Now().UnixMilli(), ip, ttl.Milliseconds(),
+	).Err()
+}
+
+// List 按创建时间倒序列出会话, 已过期的会话不返回
+func (r *sessionRepo) List(ctx context.Context, userID int64) ([]*biz.Session, error) {
+	ids, err := r.rdb.ZRevRange(ctx, userSessionsKey(userID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	pipe := r.rdb.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, sessionKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*biz.Session, 0, len(ids))
+	for i, id := range ids {
+		f := cmds[i].Val()
+		if len(f) == 0 {
+			continue
+		}
+		createdAt, _ := strconv.ParseInt(f["created_at"], 10, 64)
+		lastSeenAt, _ := strconv.ParseInt(f["last_seen_at"], 10, 64)
+		sessions = append(sessions, &biz.Session{
+			ID:         id,
+			UserID:     userID,
+			DeviceID:   f["device_id"],
+			UserAgent:  f["user_agent"],
+			IP:         f["ip"],
+			CreatedAt:  time.UnixMilli(createdAt),
+			LastSeenAt: time.UnixMilli(lastSeenAt),
+		})
+	}
+	return sessions, nil
+}
+
+// Delete 删除会话
+func (r *sessionRepo) Delete(ctx context.Context, userID int64, id string) error {
+	n, err := deleteSessionScript.Run(ctx, r.rdb,
+		[]string{sessionKey(id), userSessionsKey(userID)},
+		strconv.FormatInt(userID, 10), id,
+	).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return biz.ErrSessionNotFound
+	}
+	return nil
+}
+
+// DeleteUser 删除用户的所有会话
+func (r *sessionRepo) DeleteUser(ctx context.Context, userID int64) error {
+	userKey := userSessionsKey(userID)
+	ids, err := r.rdb.ZRange(ctx, userKey, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(ids)+1)
+	for _, id := range ids {
+		keys = append(keys, sessionKey(id))
+	}
+	keys = append(keys, userKey)
+	return r.rdb.Del(ctx, keys...).Err()
+}
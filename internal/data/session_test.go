@@ -0,0 +1,273 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestSessionRepo(t *testing.T, maxDevices int32) (biz.SessionRepo, *miniredis.Miniredis) {
+	t.Helper()
+	d, mr := newTestDataWithRedis(t)
+	return NewSessionRepo(&conf.Data{Session: &conf.Data_Session{MaxDevices: maxDevices}}, d), mr
+}
+
+// sessionClock 为每个会话分配递增的创建时间, 避免同一毫秒内创建导致顺序不确定
+type sessionClock struct{ now time.Time }
+
+func (c *sessionClock) session(id string, userID int64, deviceID string) *biz.Session {
+	c.now = c.now.Add(time.Second)
+	return &biz.Session{ID: id, UserID: userID, DeviceID: deviceID, CreatedAt: c.now, LastSeenAt: c.now}
+}
+
+// createSessions 依次创建会话, 返回每次被挤掉的会话
+func createSessions(t *testing.T, repo biz.SessionRepo, sessions ...*biz.Session) [][]string {
+	t.Helper()
+	evicted := make([][]string, len(sessions))
+	for i, s := range sessions {
+		var err error
+		if evicted[i], err = repo.Create(context.Background(), s, time.Hour); err != nil {
+			t.Fatalf("Create(%s) error = %v", s.ID, err)
+		}
+	}
+	return evicted
+}
+
+func sessionIDs(t *testing.T, repo biz.SessionRepo, userID int64) []string {
+	t.Helper()
+	list, err := repo.List(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	ids := make([]string, 0, len(list))
+	for _, s := range list {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func TestSessionRepo_Create(t *testing.T) {
+	c := &sessionClock{now: time.UnixMilli(1700000000000)}
+	tests := []struct {
+		name        string
+		maxDevices  int32
+		sessions    []*biz.Session
+		wantEvicted [][]string
+		wantList    []string // 按创建时间倒序
+	}{
+		{
+			name:        "device cap evicts the oldest",
+			maxDevices:  2,
+			sessions:    []*biz.Session{c.session("a", 1, "d1"), c.session("b", 1, "d2"), c.session("c", 1, "d3"), c.session("d", 1, "d4")},
+			wantEvicted: [][]string{{}, {}, {"a"}, {"b"}},
+			wantList:    []string{"d", "c"},
+		},
+		{
+			name:        "same device replaces its session",
+			maxDevices:  5,
+			sessions:    []*biz.Session{c.session("a", 1, "d1"), c.session("b", 1, "d2"), c.session("c", 1, "d1")},
+			wantEvicted: [][]string{{}, {}, {"a"}},
+			wantList:    []string{"c", "b"},
+		},
+		{
+			// 同设备的旧会话腾出了位置, 不再挤掉其他设备
+			name:        "same device frees a slot under the cap",
+			maxDevices:  2,
+			sessions:    []*biz.Session{c.session("a", 1, "d1"), c.session("b", 1, "d2"), c.session("c", 1, "d1")},
+			wantEvicted: [][]string{{}, {}, {"a"}},
+			wantList:    []string{"c", "b"},
+		},
+		{
+			// 没有设备号的会话互不替换, 只受上限约束
+			name:        "empty device ids are distinct",
+			maxDevices:  2,
+			sessions:    []*biz.Session{c.session("a", 1, ""), c.session("b", 1, ""), c.session("c", 1, "")},
+			wantEvicted: [][]string{{}, {}, {"a"}},
+			wantList:    []string{"c", "b"},
+		},
+		{
+			name:        "cap of one",
+			maxDevices:  1,
+			sessions:    []*biz.Session{c.session("a", 1, "d1"), c.session("b", 1, "d2")},
+			wantEvicted: [][]string{{}, {"a"}},
+			wantList:    []string{"b"},
+		},
+		{
+			name:        "negative cap is unlimited",
+			maxDevices:  -1,
+			sessions:    []*biz.Session{c.session("a", 1, "d1"), c.session("b", 1, "d2"), c.session("c", 1, "d3"), c.session("d", 1, "d1")},
+			wantEvicted: [][]string{{}, {}, {}, {"a"}},
+			wantList:    []string{"d", "c", "b"},
+		},
+		{
+			name:        "other users are not counted",
+			maxDevices:  1,
+			sessions:    []*biz.Session{c.session("a", 1, "d1"), c.session("b", 2, "d1"), c.session("c", 2, "d2")},
+			wantEvicted: [][]string{{}, {}, {"b"}},
+			wantList:    []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mr := newTestSessionRepo(t, tt.maxDevices)
+			evicted := createSessions(t, repo, tt.sessions...)
+			for i := range evicted {
+				if !reflect.DeepEqual(evicted[i], tt.wantEvicted[i]) {
+					t.Errorf("Create(%s) evicted = %v, want %v", tt.sessions[i].ID, evicted[i], tt.wantEvicted[i])
+				}
+				for _, id := range evicted[i] {
+					if mr.Exists(sessionKey(id)) {
+						t.Errorf("evicted session %s still stored", id)
+					}
+				}
+			}
+			if got := sessionIDs(t, repo, 1); !reflect.DeepEqual(got, tt.wantList) {
+				t.Errorf("List() = %v, want %v", got, tt.wantList)
+			}
+		})
+	}
+}
+
+// 已过期的会话从集合中清理, 不占用设备数, 也不出现在挤掉的列表里
+func TestSessionRepo_CreateSkipsExpired(t *testing.T) {
+	ctx := context.Background()
+	repo, mr := newTestSessionRepo(t, 2)
+	c := &sessionClock{now: time.UnixMilli(1700000000000)}
+
+	if _, err := repo.Create(ctx, c.session("short", 1, "d1"), time.Second); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	createSessions(t, repo, c.session("long", 1, "d2"))
+	mr.FastForward(2 * time.Second)
+
+	evicted := createSessions(t, repo, c.session("new", 1, "d1"))
+	if len(evicted[0]) != 0 {
+		t.Fatalf("evicted = %v, want none", evicted[0])
+	}
+	if members, _ := mr.ZMembers(userSessionsKey(1)); !reflect.DeepEqual(members, []string{"long", "new"}) {
+		t.Errorf("user sessions = %v, want [long new]", members)
+	}
+}
+
+func TestSessionRepo_TouchAndDelete(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newTestSessionRepo(t, 5)
+	c := &sessionClock{now: time.UnixMilli(1700000000000)}
+	createSessions(t, repo, c.session("a", 1, "d1"), c.session("b", 1, "d2"))
+
+	if err := repo.Touch(ctx, 1, "a", "10.0.0.1", time.Hour); err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+	// 其他用户的会话不受影响
+	if err := repo.Touch(ctx, 2, "b", "10.0.0.2", time.Hour); err != nil {
+		t.Fatalf("Touch(other user) error = %v", err)
+	}
+	list, err := repo.List(ctx, 1)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if list[1].ID != "a" || list[1].IP != "10.0.0.1" || !list[1].LastSeenAt.After(list[1].CreatedAt) {
+		t.Errorf("touched session = %+v", list[1])
+	}
+	if list[0].IP != "" {
+		t.Errorf("session touched by another user: %+v", list[0])
+	}
+
+	if err := repo.Delete(ctx, 2, "a"); !errors.Is(err, biz.ErrSessionNotFound) {
+		t.Fatalf("Delete(other user) error = %v, want ErrSessionNotFound", err)
+	}
+	if err := repo.Delete(ctx, 1, "a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := repo.Delete(ctx, 1, "a"); !errors.Is(err, biz.ErrSessionNotFound) {
+		t.Fatalf("Delete(again) error = %v, want ErrSessionNotFound", err)
+	}
+	if err := repo.DeleteUser(ctx, 1); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+	if ids := sessionIDs(t, repo, 1); len(ids) != 0 {
+		t.Errorf("List() after DeleteUser = %v", ids)
+	}
+}
+
+// 脚本只访问调用方声明的 key, 读出集合之后集合有变化时不做任何修改
+func TestCreateSessionScript_StaleMembers(t *testing.T) {
+	ctx := context.Background()
+	repo, mr := newTestSessionRepo(t, 1)
+	c := &sessionClock{now: time.UnixMilli(1700000000000)}
+	createSessions(t, repo, c.session("a", 1, "d1"))
+
+	// 调用方以为集合为空, 实际已有会话 a
+	s := c.session("b", 1, "d2")
+	err := createSessionScript.Run(ctx, repo.(*sessionRepo).rdb,
+		[]string{sessionKey(s.ID), userSessionsKey(1)},
+		s.ID, time.Hour.Milliseconds(), 1, 0,
+		"user_id", s.UserID, "device_id", s.DeviceID, "created_at", s.CreatedAt.UnixMilli(),
+	).Err()
+	if !errors.Is(err, redis.Nil) {
+		t.Fatalf("script error = %v, want redis.Nil", err)
+	}
+	if mr.Exists(sessionKey("b")) || !mr.Exists(sessionKey("a")) {
+		t.Fatal("script modified sessions with a stale member list")
+	}
+	if ids := sessionIDs(t, repo, 1); !reflect.DeepEqual(ids, []string{"a"}) {
+		t.Fatalf("List() = %v, want [a]", ids)
+	}
+}
+
+func TestSessionRepo_ConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+	repo, mr := newTestSessionRepo(t, 2)
+	start := time.UnixMilli(1700000000000)
+
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	evicted := make([][]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			at := start.Add(time.Duration(i) * time.Second)
+			evicted[i], errs[i] = repo.Create(ctx, &biz.Session{
+				ID: fmt.Sprintf("s%d", i), UserID: 1, DeviceID: fmt.Sprintf("d%d", i), CreatedAt: at, LastSeenAt: at,
+			}, time.Hour)
+		}(i)
+	}
+	wg.Wait()
+
+	// 并发创建时重试, 最终不超过设备数上限, 每个会话要么保留要么被挤掉一次
+	seen := make(map[string]int)
+	for i, err := range errs {
+		if err != nil && !errors.Is(err, errSessionContention) {
+			t.Fatalf("Create(s%d) error = %v", i, err)
+		}
+		for _, id := range evicted[i] {
+			seen[id]++
+			if mr.Exists(sessionKey(id)) {
+				t.Errorf("evicted session %s still stored", id)
+			}
+		}
+	}
+	ids := sessionIDs(t, repo, 1)
+	if len(ids) > 2 {
+		t.Fatalf("List() = %v, want at most 2 sessions", ids)
+	}
+	for _, id := range ids {
+		seen[id]++
+	}
+	for id, count := range seen {
+		if count != 1 {
+			t.Errorf("session %s kept or evicted %d times", id, count)
+		}
+	}
+}
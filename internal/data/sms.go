@@ -0,0 +1,100 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+)
+
+// 短信发送方式, 目前只有本地开发用的两种, 都只能在 dev 环境使用
+const (
+	SmsDriverLog  = "log"
+	SmsDriverFile = "file"
+)
+
+// NewSmsSender 根据 conf.Data.Sms.driver 创建短信发送器
+// log 与 file 都不会真正发出短信, 只允许在 dev 环境使用, 其他环境拒绝启动, 避免验证码被静默丢弃
+// 未配置 driver 时 dev 环境默认使用 log
+func NewSmsSender(c *conf.Data, app *conf.App, logHelper *takin_log.TakinLogger) (biz.SmsSender, error) {
+	sc := c.GetSms()
+	switch sc.GetDriver() {
+	case "":
+		if !app.IsDev() {
+			return nil, fmt.Errorf("sms driver is not configured")
+		}
+		return &logSmsSender{logHelper: logHelper}, nil
+	case SmsDriverLog:
+		if !app.IsDev() {
+			return nil, fmt.Errorf("sms driver %s is only allowed in dev", SmsDriverLog)
+		}
+		return &logSmsSender{logHelper: logHelper}, nil
+	case SmsDriverFile:
+		if !app.IsDev() {
+			return nil, fmt.Errorf("sms driver %s is only allowed in dev", SmsDriverFile)
+		}
+		if sc.GetFilePath() == "" {
+			return nil, fmt.Errorf("sms file_path is not configured")
+		}
+		return &fileSmsSender{path: sc.GetFilePath()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported sms driver: %s", sc.GetDriver())
+	}
+}
+
+// logSmsSender 只记录发送了短信, 不真正发送
+// 日志会被采集和落盘, 验证码本身不写入日志; 本地调试需要读取验证码时使用 file
+type logSmsSender struct {
+	logHelper *takin_log.TakinLogger
+}
+
+func (s *logSmsSender) SendCode(ctx context.Context, phone, purpose, _ string, ttl time.Duration) error {
+	s.logHelper.InfoContext(ctx, "sms verification code sent", "phone", phone, "purpose", purpose, "ttl", ttl.String())
+	return nil
+}
+
+// fileSmsSender 每条短信追加一行 JSON, 便于本地调试与自动化测试读取
+type fileSmsSender struct {
+	mu   sync.Mutex
+	path string
+}
+
+type smsRecord struct {
+	Time    time.Time `json:"time"`
+	Phone   string    `json:"phone"`
+	Purpose string    `json:"purpose"`
+	Code    string    `json:"code"`
+	TTL     string    `json:"ttl"`
+}
+
+func (s *fileSmsSender) SendCode(_ context.Context, phone, purpose, code string, ttl time.Duration) error {
+	line, err := json.Marshal(&smsRecord{
+		Time:    time.Now(),
+		Phone:   phone,
+		Purpose: purpose,
+		Code:    code,
+		TTL:     ttl.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open sms file failed: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write sms file failed: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/YangZhaoWeblog/UserService/internal/observability"
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// 触发限制的维度, 全局熔断在脚本中返回 global
+const (
+	smsScopePhone  = "phone"
+	smsScopeIP     = "ip"
+	smsScopeDevice = "device"
+)
+
+const defaultSmsGlobalCooldown = 5 * time.Minute
+
+var (
+	defaultPhoneSmsLimit  = smsLimit{interval: time.Minute, hourly: 5, daily: 10}
+	defaultIPSmsLimit     = smsLimit{hourly: 30, daily: 100}
+	defaultDeviceSmsLimit = smsLimit{interval: time.Minute, hourly: 10, daily: 20}
+)
+
+// smsLimitScript 先检查全部限制, 都通过后再统一计数, 被拦下的请求不占额度
+// KEYS[1] 熔断标记, KEYS[2] 本分钟全局计数, 之后每个维度 3 个 key: 发送间隔、小时计数、天计数
+// ARGV[1] 全局每分钟上限, ARGV[2] 熔断时长(ms), 之后每个维度 4 个参数: 间隔(ms)、小时上限、天上限、维度名
+// 返回空表示通过, 否则返回 {维度, 限制类型}
+var smsLimitScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return {"global", "circuit"}
+end
+
+local n = (#KEYS - 2) / 3
+for i = 0, n - 1 do
+	local k, a = 3 + i * 3, 3 + i * 4
+	local scope = ARGV[a + 3]
+	if tonumber(ARGV[a]) > 0 and redis.call("EXISTS", KEYS[k]) == 1 then
+		return {scope, "interval"}
+	end
+	if tonumber(ARGV[a + 1]) > 0 and tonumber(redis.call("GET", KEYS[k + 1]) or "0") >= tonumber(ARGV[a + 1]) then
+		return {scope, "hourly"}
+	end
+	if tonumber(ARGV[a + 2]) > 0 and tonumber(redis.call("GET", KEYS[k + 2]) or "0") >= tonumber(ARGV[a + 2]) then
+		return {scope, "daily"}
+	end
+end
+
+local limit = tonumber(ARGV[1])
+if limit > 0 then
+	local total = redis.call("INCR", KEYS[2])
+	if total == 1 then
+		redis.call("PEXPIRE", KEYS[2], 60000)
+	end
+	if total > limit then
+		redis.call("SET", KEYS[1], "1", "PX", ARGV[2])
+		return {"global", "circuit"}
+	end
+end
+
+for i = 0, n - 1 do
+	local k, a = 3 + i * 3, 3 + i * 4
+	if tonumber(ARGV[a]) > 0 then
+		redis.call("SET", KEYS[k], "1", "PX", ARGV[a])
+	end
+	if redis.call("INCR", KEYS[k + 1]) == 1 then
+		redis.call("PEXPIRE", KEYS[k + 1], 3600000)
+	end
+	if redis.call("INCR", KEYS[k + 2]) == 1 then
+		redis.call("PEXPIRE", KEYS[k + 2], 86400000)
+	end
+end
+return {}
+`)
+
+type smsLimit struct {
+	interval time.Duration
+	hourly   int
+	daily    int
+}
+
+func (l smsLimit) unlimited() bool {
+	return l.interval <= 0 && l.hourly <= 0 && l.daily <= 0
+}
+
+// smsLimiter 基于 Redis 的验证码发送限制, 多副本共享同一份计数
+// 小时与天按 UTC 的自然时间窗口计数
+type smsLimiter struct {
+	rdb             *redis.Client
+	phone           smsLimit
+	ip              smsLimit
+	device          smsLimit
+	globalPerMinute int
+	globalCooldown  time.Duration
+	blocked         metric.Int64Counter
+	now             func() time.Time
+}
+
+// NewSmsLimiter 根据 conf.Data.Sms 创建验证码发送限制
+func NewSmsLimiter(c *conf.Data, data *Data, metricsData *observability.MetricsData) biz.SmsLimiter {
+	sc := c.GetSms()
+	l := &smsLimiter{
+		rdb:             data.rdb,
+		phone:           toSmsLimit(sc.GetPhoneLimit(), defaultPhoneSmsLimit),
+		ip:              toSmsLimit(sc.GetIpLimit(), defaultIPSmsLimit),
+		device:          toSmsLimit(sc.GetDeviceLimit(), defaultDeviceSmsLimit),
+		globalPerMinute: int(sc.GetGlobalPerMinute()),
+		globalCooldown:  sc.GetGlobalCooldown().AsDuration(),
+		blocked:         metricsData.SmsBlocked,
+		now:             time.Now,
+	}
+	if l.globalCooldown <= 0 {
+		l.globalCooldown = defaultSmsGlobalCooldown
+	}
+	return l
+}
+
+func toSmsLimit(c *conf.Data_Sms_Limit, def smsLimit) smsLimit {
+	if c == nil {
+		return def
+	}
+	return smsLimit{
+		interval: c.GetMinInterval().AsDuration(),
+		hourly:   int(c.GetHourly()),
+		daily:    int(c.GetDaily()),
+	}
+}
+
+// Acquire 检查并占用一次发送额度
+// 设备号由客户端上报, 不带设备号即可绕过设备维度, 真正起约束作用的是手机号、IP 与全局熔断
+func (l *smsLimiter) Acquire(ctx context.Context, phone string, client biz.ClientInfo) error {
+	now := l.now().UTC()
+	hour, day := now.Format("2006010215"), now.Format("20060102")
+
+	keys := []string{"sms:breaker", "sms:global:" + now.Format("200601021504")}
+	args := []any{l.globalPerMinute, l.globalCooldown.Milliseconds()}
+	add := func(scope, value string, limit smsLimit) {
+		if value == "" || limit.unlimited() {
+			return
+		}
+		prefix := fmt.Sprintf("sms:limit:%s:%s", scope, value)
+		keys = append(keys, prefix+":last", prefix+":h:"+hour, prefix+":d:"+day)
+		args = append(args, limit.interval.Milliseconds(), limit.hourly, limit.daily, scope)
+	}
+	add(smsScopePhone, phone, l.phone)
+	add(smsScopeIP, client.IP, l.ip)
+	add(smsScopeDevice, client.DeviceID, l.device)
+
+	res, err := smsLimitScript.Run(ctx, l.rdb, keys, args...).StringSlice()
+	if err != nil {
+		return err
+	}
+	if len(res) != 2 {
+		return nil
+	}
+
+	scope, kind := res[0], res[1]
+	var blockErr *errors.Error
+	switch kind {
+	case "interval":
+		blockErr = biz.ErrSmsTooFrequent
+	case "hourly":
+		blockErr = biz.ErrSmsHourlyLimitExceeded
+	case "daily":
+		blockErr = biz.ErrSmsDailyLimitExceeded
+	default:
+		blockErr = biz.ErrSmsCircuitOpen
+	}
+	if l.blocked != nil {
+		l.blocked.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("reason", blockErr.Reason),
+			attribute.String("scope", scope),
+		))
+	}
+	return blockErr.WithMetadata(map[string]string{"scope": scope})
+}
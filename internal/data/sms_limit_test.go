@@ -0,0 +1,270 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-kratos/kratos/v2/errors"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/YangZhaoWeblog/UserService/internal/observability"
+)
+
+// noSmsLimit 关闭某个维度的限制
+var noSmsLimit = &conf.Data_Sms_Limit{}
+
+// testSmsLimiter 固定了时钟的 smsLimiter, advance 同时推进时钟与 miniredis 的过期时间
+type testSmsLimiter struct {
+	*smsLimiter
+	mr  *miniredis.Miniredis
+	now time.Time
+}
+
+func (l *testSmsLimiter) advance(d time.Duration) {
+	l.now = l.now.Add(d)
+	l.mr.FastForward(d)
+}
+
+func newTestSmsLimiter(t *testing.T, sc *conf.Data_Sms) (*testSmsLimiter, *sdkmetric.ManualReader) {
+	t.Helper()
+	d, mr := newTestDataWithRedis(t)
+	reader := sdkmetric.NewManualReader()
+	blocked, err := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test").Int64Counter("sms_send_blocked")
+	if err != nil {
+		t.Fatalf("Int64Counter() error = %v", err)
+	}
+	// 从整点开始, 用例中的时间推进不会意外跨过分钟、小时或天的窗口
+	l := &testSmsLimiter{
+		smsLimiter: NewSmsLimiter(&conf.Data{Sms: sc}, d, &observability.MetricsData{SmsBlocked: blocked}).(*smsLimiter),
+		mr:         mr,
+		now:        time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+	}
+	l.smsLimiter.now = func() time.Time { return l.now }
+	return l, reader
+}
+
+// acquire 返回被拦下时的错误原因与维度, 通过时两者为空
+func acquire(t *testing.T, l biz.SmsLimiter, phone, ip, device string) (reason, scope string) {
+	t.Helper()
+	err := l.Acquire(context.Background(), phone, biz.ClientInfo{IP: ip, DeviceID: device})
+	if err == nil {
+		return "", ""
+	}
+	e := errors.FromError(err)
+	if e.Reason == errors.UnknownReason {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	return e.Reason, e.Metadata["scope"]
+}
+
+func mustAcquire(t *testing.T, l biz.SmsLimiter, phone, ip, device string) {
+	t.Helper()
+	if reason, scope := acquire(t, l, phone, ip, device); reason != "" {
+		t.Fatalf("Acquire(%s, %s, %s) blocked by %s/%s", phone, ip, device, scope, reason)
+	}
+}
+
+func mustBlock(t *testing.T, l biz.SmsLimiter, phone, ip, device string, wantErr *errors.Error, wantScope string) {
+	t.Helper()
+	reason, scope := acquire(t, l, phone, ip, device)
+	if reason != wantErr.Reason || scope != wantScope {
+		t.Fatalf("Acquire(%s, %s, %s) = %q/%q, want %s/%s", phone, ip, device, scope, reason, wantScope, wantErr.Reason)
+	}
+}
+
+// blockedCounts 读取 sms_send_blocked 指标, key 为 scope/reason
+func blockedCounts(t *testing.T, reader sdkmetric.Reader) map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("collect metrics: %v", err)
+	}
+	got := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok {
+				continue
+			}
+			for _, dp := range sum.DataPoints {
+				scope, _ := dp.Attributes.Value(attribute.Key("scope"))
+				reason, _ := dp.Attributes.Value(attribute.Key("reason"))
+				got[scope.AsString()+"/"+reason.AsString()] += dp.Value
+			}
+		}
+	}
+	return got
+}
+
+func TestSmsLimiter_Interval(t *testing.T) {
+	l, reader := newTestSmsLimiter(t, &conf.Data_Sms{
+		PhoneLimit:  &conf.Data_Sms_Limit{MinInterval: durationpb.New(time.Minute)},
+		IpLimit:     noSmsLimit,
+		DeviceLimit: noSmsLimit,
+	})
+
+	mustAcquire(t, l, "13800000001", "", "")
+	mustBlock(t, l, "13800000001", "", "", biz.ErrSmsTooFrequent, smsScopePhone)
+	// 其他手机号不受影响
+	mustAcquire(t, l, "13800000002", "", "")
+
+	l.advance(time.Minute)
+	mustAcquire(t, l, "13800000001", "", "")
+
+	if got := blockedCounts(t, reader); len(got) != 1 || got["phone/SMS_TOO_FREQUENT"] != 1 {
+		t.Errorf("sms_send_blocked = %v, want phone/SMS_TOO_FREQUENT=1", got)
+	}
+}
+
+func TestSmsLimiter_HourlyAndDaily(t *testing.T) {
+	l, reader := newTestSmsLimiter(t, &conf.Data_Sms{
+		PhoneLimit:  &conf.Data_Sms_Limit{Hourly: 2},
+		IpLimit:     &conf.Data_Sms_Limit{Daily: 3},
+		DeviceLimit: noSmsLimit,
+	})
+
+	mustAcquire(t, l, "13800000001", "10.0.0.1", "")
+	mustAcquire(t, l, "13800000001", "10.0.0.1", "")
+	mustBlock(t, l, "13800000001", "10.0.0.1", "", biz.ErrSmsHourlyLimitExceeded, smsScopePhone)
+
+	// 被拦下的请求不占 IP 的额度, 同一 IP 还能再发一次
+	mustAcquire(t, l, "13800000002", "10.0.0.1", "")
+	mustBlock(t, l, "13800000003", "10.0.0.1", "", biz.ErrSmsDailyLimitExceeded, smsScopeIP)
+	// 不带 IP 时不检查 IP 维度
+	mustAcquire(t, l, "13800000003", "", "")
+
+	// 按自然小时与自然天计数: 下一个小时手机号恢复额度, IP 要到第二天
+	l.advance(time.Hour)
+	mustAcquire(t, l, "13800000001", "", "")
+	mustBlock(t, l, "13800000004", "10.0.0.1", "", biz.ErrSmsDailyLimitExceeded, smsScopeIP)
+	l.advance(14 * time.Hour)
+	mustAcquire(t, l, "13800000004", "10.0.0.1", "")
+
+	got := blockedCounts(t, reader)
+	if got["phone/SMS_HOURLY_LIMIT_EXCEEDED"] != 1 || got["ip/SMS_DAILY_LIMIT_EXCEEDED"] != 2 || len(got) != 2 {
+		t.Errorf("sms_send_blocked = %v, want phone/SMS_HOURLY_LIMIT_EXCEEDED=1 and ip/SMS_DAILY_LIMIT_EXCEEDED=2", got)
+	}
+}
+
+func TestSmsLimiter_Device(t *testing.T) {
+	l, reader := newTestSmsLimiter(t, &conf.Data_Sms{
+		PhoneLimit:  noSmsLimit,
+		IpLimit:     noSmsLimit,
+		DeviceLimit: &conf.Data_Sms_Limit{MinInterval: durationpb.New(time.Minute), Hourly: 1},
+	})
+
+	mustAcquire(t, l, "13800000001", "", "device-1")
+	mustBlock(t, l, "13800000002", "", "device-1", biz.ErrSmsTooFrequent, smsScopeDevice)
+	mustAcquire(t, l, "13800000002", "", "device-2")
+
+	mustBlock(t, l, "13800000003", "", "device-2", biz.ErrSmsTooFrequent, smsScopeDevice)
+	l.advance(time.Minute)
+	mustBlock(t, l, "13800000003", "", "device-2", biz.ErrSmsHourlyLimitExceeded, smsScopeDevice)
+
+	if got := blockedCounts(t, reader); got["device/SMS_TOO_FREQUENT"] != 2 || got["device/SMS_HOURLY_LIMIT_EXCEEDED"] != 1 {
+		t.Errorf("sms_send_blocked = %v, want device/SMS_TOO_FREQUENT=2 and device/SMS_HOURLY_LIMIT_EXCEEDED=1", got)
+	}
+}
+
+// 被拦下的请求不计数: 间隔、小时与天的额度, 以及其他维度的额度都不受影响
+func TestSmsLimiter_BlockedDoesNotConsumeQuota(t *testing.T) {
+	l, _ := newTestSmsLimiter(t, &conf.Data_Sms{
+		PhoneLimit:  &conf.Data_Sms_Limit{MinInterval: durationpb.New(time.Minute), Hourly: 2},
+		IpLimit:     &conf.Data_Sms_Limit{Hourly: 2},
+		DeviceLimit: noSmsLimit,
+	})
+
+	mustAcquire(t, l, "13800000001", "10.0.0.1", "")
+	for i := 0; i < 5; i++ {
+		mustBlock(t, l, "13800000001", "10.0.0.1", "", biz.ErrSmsTooFrequent, smsScopePhone)
+	}
+	// 手机号的小时额度只用了一次
+	l.advance(time.Minute)
+	mustAcquire(t, l, "13800000001", "10.0.0.2", "")
+	// IP 的小时额度也只用了一次
+	mustAcquire(t, l, "13800000002", "10.0.0.1", "")
+	mustBlock(t, l, "13800000003", "10.0.0.1", "", biz.ErrSmsHourlyLimitExceeded, smsScopeIP)
+}
+
+func TestSmsLimiter_GlobalBreaker(t *testing.T) {
+	l, reader := newTestSmsLimiter(t, &conf.Data_Sms{
+		PhoneLimit:      noSmsLimit,
+		IpLimit:         noSmsLimit,
+		DeviceLimit:     noSmsLimit,
+		GlobalPerMinute: 2,
+		GlobalCooldown:  durationpb.New(2 * time.Minute),
+	})
+
+	mustAcquire(t, l, "13800000001", "", "")
+	mustAcquire(t, l, "13800000002", "", "")
+	// 超过每分钟总量后熔断, 熔断期间拒绝所有发送
+	mustBlock(t, l, "13800000003", "", "", biz.ErrSmsCircuitOpen, "global")
+	if ttl := l.mr.TTL("sms:breaker"); ttl != 2*time.Minute {
+		t.Errorf("breaker TTL = %v, want 2m", ttl)
+	}
+	l.advance(time.Minute)
+	mustBlock(t, l, "13800000004", "", "", biz.ErrSmsCircuitOpen, "global")
+
+	l.advance(time.Minute)
+	mustAcquire(t, l, "13800000005", "", "")
+
+	if got := blockedCounts(t, reader); got["global/SMS_CIRCUIT_OPEN"] != 2 || len(got) != 1 {
+		t.Errorf("sms_send_blocked = %v, want global/SMS_CIRCUIT_OPEN=2", got)
+	}
+}
+
+// 全局总量按自然分钟计数, 每分钟都不超过上限时不熔断; 未配置熔断时长时默认 5m
+func TestSmsLimiter_GlobalWindow(t *testing.T) {
+	l, _ := newTestSmsLimiter(t, &conf.Data_Sms{
+		PhoneLimit:      noSmsLimit,
+		IpLimit:         noSmsLimit,
+		DeviceLimit:     noSmsLimit,
+		GlobalPerMinute: 2,
+	})
+
+	mustAcquire(t, l, "13800000001", "", "")
+	mustAcquire(t, l, "13800000002", "", "")
+	l.advance(time.Minute)
+	mustAcquire(t, l, "13800000003", "", "")
+	mustAcquire(t, l, "13800000004", "", "")
+	mustBlock(t, l, "13800000005", "", "", biz.ErrSmsCircuitOpen, "global")
+	if ttl := l.mr.TTL("sms:breaker"); ttl != defaultSmsGlobalCooldown {
+		t.Errorf("breaker TTL = %v, want %v", ttl, defaultSmsGlobalCooldown)
+	}
+}
+
+// 熔断检查在各维度之前, 被其他维度拦下的请求也不计入全局总量
+func TestSmsLimiter_GlobalIgnoresBlocked(t *testing.T) {
+	l, _ := newTestSmsLimiter(t, &conf.Data_Sms{
+		PhoneLimit:      &conf.Data_Sms_Limit{MinInterval: durationpb.New(time.Minute)},
+		IpLimit:         noSmsLimit,
+		DeviceLimit:     noSmsLimit,
+		GlobalPerMinute: 2,
+	})
+
+	mustAcquire(t, l, "13800000001", "", "")
+	for i := 0; i < 3; i++ {
+		mustBlock(t, l, "13800000001", "", "", biz.ErrSmsTooFrequent, smsScopePhone)
+	}
+	mustAcquire(t, l, "13800000002", "", "")
+	mustBlock(t, l, "13800000003", "", "", biz.ErrSmsCircuitOpen, "global")
+}
+
+func TestSmsLimiter_Defaults(t *testing.T) {
+	l, _ := newTestSmsLimiter(t, &conf.Data_Sms{})
+
+	// 未配置时按手机号默认间隔 60s
+	mustAcquire(t, l, "13800000001", "10.0.0.1", "device-1")
+	mustBlock(t, l, "13800000001", "10.0.0.2", "device-2", biz.ErrSmsTooFrequent, smsScopePhone)
+	// 设备默认间隔 60s
+	mustBlock(t, l, "13800000002", "10.0.0.1", "device-1", biz.ErrSmsTooFrequent, smsScopeDevice)
+	// IP 默认不限间隔
+	mustAcquire(t, l, "13800000002", "10.0.0.1", "")
+}
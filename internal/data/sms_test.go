@@ -0,0 +1,64 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+)
+
+func TestNewSmsSender(t *testing.T) {
+	dev := &conf.App{Env: conf.EnvDev}
+	prod := &conf.App{Env: "prod"}
+	tests := []struct {
+		name    string
+		sms     *conf.Data_Sms
+		app     *conf.App
+		wantErr bool
+	}{
+		{"unset in dev", nil, dev, false},
+		{"unset outside dev", nil, prod, true},
+		{"unset without env", nil, &conf.App{}, true},
+		{"explicit log in dev", &conf.Data_Sms{Driver: SmsDriverLog}, dev, false},
+		{"explicit log", &conf.Data_Sms{Driver: SmsDriverLog}, prod, true},
+		{"file in dev", &conf.Data_Sms{Driver: SmsDriverFile, FilePath: "sms.jsonl"}, dev, false},
+		{"file outside dev", &conf.Data_Sms{Driver: SmsDriverFile, FilePath: "sms.jsonl"}, prod, true},
+		{"file without path", &conf.Data_Sms{Driver: SmsDriverFile}, dev, true},
+		{"unknown driver", &conf.Data_Sms{Driver: "carrier-pigeon"}, dev, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSmsSender(&conf.Data{Sms: tt.sms}, tt.app, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewSmsSender() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileSmsSender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sms.jsonl")
+	sender, err := NewSmsSender(&conf.Data{Sms: &conf.Data_Sms{Driver: SmsDriverFile, FilePath: path}}, &conf.App{Env: conf.EnvDev}, nil)
+	if err != nil {
+		t.Fatalf("NewSmsSender() error = %v", err)
+	}
+	if err := sender.SendCode(context.Background(), "13800000001", "login", "123456", 5*time.Minute); err != nil {
+		t.Fatalf("SendCode() error = %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read sms file: %v", err)
+	}
+	var rec smsRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		t.Fatalf("decode sms record: %v", err)
+	}
+	if rec.Phone != "13800000001" || rec.Purpose != "login" || rec.Code != "123456" || rec.TTL != "5m0s" {
+		t.Errorf("record = %+v", rec)
+	}
+}
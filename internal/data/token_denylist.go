@@ -0,0 +1,322 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/observability"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	denyJTIPrefix     = "at:deny:"
+	denyUserPrefix    = "at:deny-user:"
+	denySessionPrefix = "at:deny-sid:"
+
+	// denylistChannel 吊销消息的广播频道, 每个副本收到后更新自己的进程内缓存
+	denylistChannel = "at:revocations"
+
+	denylistPruneInterval = time.Minute
+)
+
+// denylistRetryInterval 接收广播失败后的重试间隔, 测试中会调小
+var denylistRetryInterval = time.Second
+
+// revocation 是一条吊销记录, 也是广播消息的格式
+// JTI 非空时吊销单个令牌, SID 非空时吊销会话签发的全部令牌, 否则吊销 UserID 在 Before 之前签发的全部令牌
+type revocation struct {
+	JTI     string `json:"jti,omitempty"`
+	SID     string `json:"sid,omitempty"`
+	UserID  int64  `json:"user_id,omitempty"`
+	Before  int64  `json:"before,omitempty"` // unix 秒
+	Expires int64  `json:"exp"`              // unix 毫秒, 到期后记录没有意义, 可以丢弃
+}
+
+// tokenDenylist 把吊销记录写入 Redis, 并在进程内缓存一份完整副本
+// 校验只读进程内缓存; 其他副本的吊销通过 pub/sub 同步, 订阅建立或重连后从 Redis 全量加载一次
+type tokenDenylist struct {
+	rdb        *redis.Client
+	logHelper  *takin_log.TakinLogger
+	syncFailed metric.Int64Counter
+
+	mu       sync.RWMutex
+	jtis     map[string]time.Time     // jti -> 过期时间
+	sessions map[string]time.Time     // sid -> 过期时间
+	users    map[int64]userRevocation // 用户 -> 吊销时间点
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type userRevocation struct {
+	before  time.Time
+	expires time.Time
+}
+
+// NewTokenDenylist 创建访问令牌黑名单, 并启动订阅其他副本吊销消息的后台任务
+func NewTokenDenylist(data *Data, logHelper *takin_log.TakinLogger, metricsData *observability.MetricsData) (biz.TokenDenylist, func(), error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &tokenDenylist{
+		rdb:        data.rdb,
+		logHelper:  logHelper,
+		syncFailed: metricsData.DenylistSyncFailed,
+		jtis:       make(map[string]time.Time),
+		sessions:   make(map[string]time.Time),
+		users:      make(map[int64]userRevocation),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+
+	// 先订阅再加载, 加载期间产生的吊销消息不会丢失
+	ps := d.rdb.Subscribe(ctx, denylistChannel)
+	if _, err := ps.Receive(ctx); err != nil {
+		cancel()
+		_ = ps.Close()
+		return nil, nil, err
+	}
+	if err := d.load(ctx); err != nil {
+		cancel()
+		_ = ps.Close()
+		return nil, nil, err
+	}
+
+	go d.run(ctx, ps)
+	return d, d.close, nil
+}
+
+func (d *tokenDenylist) close() {
+	d.cancel()
+	<-d.done
+}
+
+// Revoke 吊销单个访问令牌直到 exp
+func (d *tokenDenylist) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	if !exp.After(time.Now()) {
+		return nil
+	}
+	if err := d.rdb.Set(ctx, denyJTIPrefix+jti, 1, time.Until(exp)).Err(); err != nil {
+		return err
+	}
+	return d.publish(ctx, &revocation{JTI: jti, Expires: exp.UnixMilli()})
+}
+
+// RevokeUser 吊销用户在 before 之前签发的全部访问令牌, ttl 为访问令牌的最长有效期
+func (d *tokenDenylist) RevokeUser(ctx context.Context, userID int64, before time.Time, ttl time.Duration) error {
+	key := denyUserPrefix + strconv.FormatInt(userID, 10)
+	if err := d.rdb.Set(ctx, key, before.Unix(), ttl).Err(); err != nil {
+		return err
+	}
+	return d.publish(ctx, &revocation{UserID: userID, Before: before.Unix(), Expires: before.Add(ttl).UnixMilli()})
+}
+
+// RevokeSession 吊销会话签发的全部访问令牌, ttl 为访问令牌的最长有效期
+func (d *tokenDenylist) RevokeSession(ctx context.Context, sid string, ttl time.Duration) error {
+	if err := d.rdb.Set(ctx, denySessionPrefix+sid, 1, ttl).Err(); err != nil {
+		return err
+	}
+	return d.publish(ctx, &revocation{SID: sid, Expires: time.Now().Add(ttl).UnixMilli()})
+}
+
+// IsRevoked 只查进程内缓存, 不访问 Redis
+func (d *tokenDenylist) IsRevoked(_ context.Context, jti, sid string, userID int64, issuedAt time.Time) bool {
+	now := time.Now()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if exp, ok := d.jtis[jti]; ok && now.Before(exp) {
+		return true
+	}
+	if exp, ok := d.sessions[sid]; ok && sid != "" && now.Before(exp) {
+		return true
+	}
+	if r, ok := d.users[userID]; ok && now.Before(r.expires) && issuedAt.Before(r.before) {
+		return true
+	}
+	return false
+}
+
+// publish 先写本地缓存, 再广播给其他副本; 本副本也会收到广播, 重复写入没有影响
+func (d *tokenDenylist) publish(ctx context.Context, r *revocation) error {
+	d.apply(r)
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return d.rdb.Publish(ctx, denylistChannel, b).Err()
+}
+
+func (d *tokenDenylist) apply(r *revocation) {
+	expires := time.UnixMilli(r.Expires)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if r.JTI != "" {
+		d.jtis[r.JTI] = expires
+		return
+	}
+	if r.SID != "" {
+		d.sessions[r.SID] = expires
+		return
+	}
+	// 多次 LogoutAll 时以最晚的时间点为准
+	if old, ok := d.users[r.UserID]; ok && old.before.Unix() > r.Before {
+		return
+	}
+	d.users[r.UserID] = userRevocation{before: time.Unix(r.Before, 0), expires: expires}
+}
+
+func (d *tokenDenylist) run(ctx context.Context, ps *redis.PubSub) {
+	defer close(d.done)
+	defer ps.Close()
+
+	ticker := time.NewTicker(denylistPruneInterval)
+	defer ticker.Stop()
+
+	msgs := make(chan interface{})
+	go func() {
+		defer close(msgs)
+		for {
+			msg, err := ps.Receive(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				// 连接断开时 go-redis 会自动重连并重新订阅, 稍后重试即可
+				// 断线期间其他副本的吊销不会生效, 需要告警
+				d.syncError(ctx, "receive", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(denylistRetryInterval):
+				}
+				continue
+			}
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.prune()
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+			switch m := msg.(type) {
+			case *redis.Subscription:
+				// 重连后重新订阅, 断线期间的消息可能丢失, 从 Redis 全量加载一次
+				if m.Kind == "subscribe" {
+					if err := d.load(ctx); err != nil && ctx.Err() == nil {
+						d.syncError(ctx, "reload", err)
+					}
+				}
+			case *redis.Message:
+				var r revocation
+				if err := json.Unmarshal([]byte(m.Payload), &r); err == nil {
+					d.apply(&r)
+				}
+			}
+		}
+	}
+}
+
+// syncError 记录同步失败, stage 为 receive 或 reload
+func (d *tokenDenylist) syncError(ctx context.Context, stage string, err error) {
+	d.logHelper.ErrorContext(ctx, "token denylist sync failed", "stage", stage, "err", err)
+	if d.syncFailed != nil {
+		d.syncFailed.Add(ctx, 1, metric.WithAttributes(attribute.String("stage", stage)))
+	}
+}
+
+// load 从 Redis 加载全部吊销记录, 与已有的缓存合并
+func (d *tokenDenylist) load(ctx context.Context) error {
+	for _, prefix := range []string{denyJTIPrefix, denySessionPrefix, denyUserPrefix} {
+		iter := d.rdb.Scan(ctx, 0, prefix+"*", 500).Iterator()
+		var keys []string
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		pipe := d.rdb.Pipeline()
+		gets := make([]*redis.StringCmd, len(keys))
+		ttls := make([]*redis.DurationCmd, len(keys))
+		for i, key := range keys {
+			gets[i] = pipe.Get(ctx, key)
+			ttls[i] = pipe.PTTL(ctx, key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return err
+		}
+
+		now := time.Now()
+		for i, key := range keys {
+			ttl := ttls[i].Val()
+			if gets[i].Err() != nil || ttl <= 0 {
+				continue
+			}
+			r := &revocation{Expires: now.Add(ttl).UnixMilli()}
+			switch prefix {
+			case denyJTIPrefix:
+				r.JTI = strings.TrimPrefix(key, prefix)
+			case denySessionPrefix:
+				r.SID = strings.TrimPrefix(key, prefix)
+			default:
+				userID, err := strconv.ParseInt(strings.TrimPrefix(key, prefix), 10, 64)
+				if err != nil {
+					continue
+				}
+				before, err := gets[i].Int64()
+				if err != nil {
+					continue
+				}
+				r.UserID, r.Before = userID, before
+			}
+			d.apply(r)
+		}
+	}
+	return nil
+}
+
+// prune 清理已过期的记录, 对应的令牌本身也已过期
+func (d *tokenDenylist) prune() {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for jti, exp := range d.jtis {
+		if !now.Before(exp) {
+			delete(d.jtis, jti)
+		}
+	}
+	for sid, exp := range d.sessions {
+		if !now.Before(exp) {
+			delete(d.sessions, sid)
+		}
+	}
+	for userID, r := range d.users {
+		if !now.Before(r.expires) {
+			delete(d.users, userID)
+		}
+	}
+}
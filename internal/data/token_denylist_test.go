@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	"github.com/YangZhaoWeblog/UserService/internal/observability"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// newTestDenylist 在 d 上创建一个副本, 同一个 Data 上的多个副本模拟多实例部署
+func newTestDenylist(t *testing.T, d *Data, metricsData *observability.MetricsData) *tokenDenylist {
+	t.Helper()
+	dl, cleanup, err := NewTokenDenylist(d, &takin_log.TakinLogger{}, metricsData)
+	if err != nil {
+		t.Fatalf("NewTokenDenylist() error = %v", err)
+	}
+	t.Cleanup(cleanup)
+	return dl.(*tokenDenylist)
+}
+
+func TestTokenDenylist_Propagation(t *testing.T) {
+	ctx := context.Background()
+	d := newTestData(t)
+	a := newTestDenylist(t, d, &observability.MetricsData{})
+	b := newTestDenylist(t, d, &observability.MetricsData{})
+	now := time.Now()
+
+	if err := a.Revoke(ctx, "jti-1", now.Add(time.Minute)); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if err := a.RevokeSession(ctx, "sid-1", time.Minute); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+	if err := a.RevokeUser(ctx, 7, now, time.Minute); err != nil {
+		t.Fatalf("RevokeUser() error = %v", err)
+	}
+	// 发起吊销的副本立即生效, 其他副本通过广播生效
+	for name, dl := range map[string]*tokenDenylist{"origin": a, "peer": b} {
+		waitFor(t, func() bool { return dl.IsRevoked(ctx, "jti-1", "", 1, now) })
+		waitFor(t, func() bool { return dl.IsRevoked(ctx, "other", "sid-1", 1, now) })
+		waitFor(t, func() bool { return dl.IsRevoked(ctx, "other", "", 7, now.Add(-time.Second)) })
+		if dl.IsRevoked(ctx, "other", "", 7, now.Add(time.Second)) {
+			t.Errorf("%s: token issued after RevokeUser is revoked", name)
+		}
+		if dl.IsRevoked(ctx, "other", "other", 1, now) {
+			t.Errorf("%s: unrelated token is revoked", name)
+		}
+	}
+
+	// 新启动的副本从 Redis 加载已有的吊销记录
+	c := newTestDenylist(t, d, &observability.MetricsData{})
+	if !c.IsRevoked(ctx, "jti-1", "", 1, now) || !c.IsRevoked(ctx, "other", "sid-1", 1, now) ||
+		!c.IsRevoked(ctx, "other", "", 7, now.Add(-time.Second)) {
+		t.Fatal("new replica did not load existing revocations")
+	}
+}
+
+func TestTokenDenylist_ReloadAfterReconnect(t *testing.T) {
+	old := denylistRetryInterval
+	denylistRetryInterval = 10 * time.Millisecond
+	t.Cleanup(func() { denylistRetryInterval = old })
+
+	ctx := context.Background()
+	d, mr := newTestDataWithRedis(t)
+	reader := sdkmetric.NewManualReader()
+	failed, err := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test").Int64Counter("token_denylist_sync_failed")
+	if err != nil {
+		t.Fatalf("Int64Counter() error = %v", err)
+	}
+	dl := newTestDenylist(t, d, &observability.MetricsData{DenylistSyncFailed: failed})
+
+	// 断线期间其他副本写入的吊销记录, 对应的广播已经丢失
+	mr.Close()
+	waitFor(t, func() bool { return syncFailures(t, reader, "receive") > 0 })
+	mr.Set(denyJTIPrefix+"jti-lost", "1")
+	mr.SetTTL(denyJTIPrefix+"jti-lost", time.Minute)
+	if dl.IsRevoked(ctx, "jti-lost", "", 1, time.Now()) {
+		t.Fatal("revoked before reconnect")
+	}
+
+	if err := mr.Restart(); err != nil {
+		t.Fatalf("restart redis: %v", err)
+	}
+	// 重新订阅后全量加载一次, 补上断线期间丢失的吊销
+	deadline := time.Now().Add(5 * time.Second)
+	for !dl.IsRevoked(ctx, "jti-lost", "", 1, time.Now()) {
+		if time.Now().After(deadline) {
+			t.Fatal("revocation written while disconnected not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// syncFailures 返回 stage 对应的同步失败次数
+func syncFailures(t *testing.T, reader sdkmetric.Reader, stage string) int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("collect metrics: %v", err)
+	}
+	var n int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok {
+				continue
+			}
+			for _, dp := range sum.DataPoints {
+				if v, _ := dp.Attributes.Value(attribute.Key("stage")); v.AsString() == stage {
+					n += dp.Value
+				}
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/data/ent"
+)
+
+// txKey 是事务在 context 中的 key
+type txKey struct{}
+
+type transaction struct {
+	data *Data
+}
+
+// NewTransaction 创建事务管理器
+// memory 驱动下使用 memoryTransaction, 失败时撤销已执行的写操作
+func NewTransaction(data *Data) biz.Transaction {
+	if data.db == nil {
+		return memoryTransaction{}
+	}
+	return &transaction{data: data}
+}
+
+// ExecTx 开启事务并把它放进 ctx, 仓库通过 Data.User 等方法透明地使用
+func (t *transaction) ExecTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if txFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	tx, err := t.data.db.Tx(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx failed: %w", err)
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			_ = tx.Rollback()
+			panic(v)
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			return fmt.Errorf("%w: rollback failed: %v", err, rerr)
+		}
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx failed: %w", err)
+	}
+	return nil
+}
+
+// memoryTransaction 是 memory 驱动下的事务管理器
+// 内存仓库把事务中每次写操作的撤销函数登记到 ctx 中的 memoryTx, fn 返回错误或 panic 时按相反顺序撤销
+// 只保证原子性, 不提供隔离: 事务中的写入对其他请求立即可见
+type memoryTransaction struct{}
+
+// memoryTxKey 是内存事务在 context 中的 key
+type memoryTxKey struct{}
+
+type memoryTx struct {
+	mu   sync.Mutex
+	undo []func()
+}
+
+func (tx *memoryTx) onRollback(fn func()) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.undo = append(tx.undo, fn)
+}
+
+func (tx *memoryTx) rollback() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = nil
+}
+
+func (memoryTransaction) ExecTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if memoryTxFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	tx := &memoryTx{}
+	defer func() {
+		if v := recover(); v != nil {
+			tx.rollback()
+			panic(v)
+		}
+	}()
+	if err = fn(context.WithValue(ctx, memoryTxKey{}, tx)); err != nil {
+		tx.rollback()
+	}
+	return err
+}
+
+func memoryTxFromContext(ctx context.Context) *memoryTx {
+	tx, _ := ctx.Value(memoryTxKey{}).(*memoryTx)
+	return tx
+}
+
+func txFromContext(ctx context.Context) *ent.Tx {
+	tx, _ := ctx.Value(txKey{}).(*ent.Tx)
+	return tx
+}
+
+// User 返回 ctx 中事务的 User 客户端, 不在事务中时返回普通客户端
+func (d *Data) User(ctx context.Context) *ent.UserClient {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx.User
+	}
+	return d.db.User
+}
+
+// UserIdentity 返回 ctx 中事务的 UserIdentity 客户端, 不在事务中时返回普通客户端
+func (d *Data) UserIdentity(ctx context.Context) *ent.UserIdentityClient {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx.UserIdentity
+	}
+	return d.db.UserIdentity
+}
+
+// AppLog 返回 ctx 中事务的 AppLog 客户端, 不在事务中时返回普通客户端
+func (d *Data) AppLog(ctx context.Context) *ent.AppLogClient {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx.AppLog
+	}
+	return d.db.AppLog
+}
+
+// afterCommit 在 ctx 中的事务提交后执行 fn, 不在事务中时立即执行
+// 用于删除缓存: 提交前删除的话, 并发的读请求可能把旧数据重新写回缓存
+func afterCommit(ctx context.Context, fn func()) {
+	tx := txFromContext(ctx)
+	if tx == nil {
+		fn()
+		return
+	}
+	tx.OnCommit(func(next ent.Committer) ent.Committer {
+		return ent.CommitFunc(func(ctx context.Context, tx *ent.Tx) error {
+			if err := next.Commit(ctx, tx); err != nil {
+				return err
+			}
+			fn()
+			return nil
+		})
+	})
+}
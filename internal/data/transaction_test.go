@@ -0,0 +1,74 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTransaction_AfterCommit(t *testing.T) {
+	ctx := context.Background()
+	tx := NewTransaction(newTestData(t))
+
+	// 提交后才执行
+	var calls []string
+	err := tx.ExecTx(ctx, func(ctx context.Context) error {
+		afterCommit(ctx, func() { calls = append(calls, "commit") })
+		if len(calls) != 0 {
+			t.Error("afterCommit() ran before commit")
+		}
+		return nil
+	})
+	if err != nil || len(calls) != 1 {
+		t.Fatalf("ExecTx() = %v, hooks ran %v, want one after commit", err, calls)
+	}
+
+	// 回滚时不执行
+	calls = nil
+	errAbort := errors.New("abort")
+	if err := tx.ExecTx(ctx, func(ctx context.Context) error {
+		afterCommit(ctx, func() { calls = append(calls, "rollback") })
+		return errAbort
+	}); !errors.Is(err, errAbort) {
+		t.Fatalf("ExecTx() error = %v, want %v", err, errAbort)
+	}
+	func() {
+		defer func() { _ = recover() }()
+		_ = tx.ExecTx(ctx, func(ctx context.Context) error {
+			afterCommit(ctx, func() { calls = append(calls, "panic") })
+			panic("boom")
+		})
+	}()
+	if len(calls) != 0 {
+		t.Fatalf("afterCommit() ran %v after rollback", calls)
+	}
+
+	// 不在事务中时立即执行
+	afterCommit(ctx, func() { calls = append(calls, "now") })
+	if len(calls) != 1 {
+		t.Fatalf("afterCommit() outside a transaction ran %v, want immediately", calls)
+	}
+}
+
+func TestTransaction_NestedAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	tx := NewTransaction(newTestData(t))
+
+	ran := false
+	err := tx.ExecTx(ctx, func(ctx context.Context) error {
+		if err := tx.ExecTx(ctx, func(ctx context.Context) error {
+			afterCommit(ctx, func() { ran = true })
+			return nil
+		}); err != nil {
+			return err
+		}
+		// 内层返回时外层还未提交
+		if ran {
+			t.Error("nested afterCommit() ran before the outer commit")
+		}
+		return nil
+	})
+	if err != nil || !ran {
+		t.Fatalf("ExecTx() = %v, ran = %v, want the hook after the outer commit", err, ran)
+	}
+}
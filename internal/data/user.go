@@ -4,6 +4,9 @@ import (
 	"context"
 
 	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/data/ent"
+	"github.com/YangZhaoWeblog/UserService/internal/data/ent/user"
+	"github.com/YangZhaoWeblog/UserService/internal/data/ent/useridentity"
 )
 
 // UserRepo 实现 biz.UserRepo 接口
@@ -11,50 +14,232 @@ type userRepo struct {
 	data *Data
 }
 
-// NewUserRepo 创建用户仓库实例
+// NewUserRepo 创建用户仓库实例, 数据库之上包了一层 Redis 缓存
+// memory 驱动下使用内存实现, 不需要缓存
 func NewUserRepo(data *Data) biz.UserRepo {
-	return &userRepo{
-		data: data,
+	if data.db == nil {
+		return newMemoryUserRepo()
 	}
+	return newUserCache(&userRepo{
+		data: data,
+	}, data.rdb)
 }
 
 // Save 保存用户
 func (r *userRepo) Save(ctx context.Context, u *biz.User) (*biz.User, error) {
-	// TODO: 实现用户保存逻辑
-	return u, nil
+	create := r.data.User(ctx).Create().
+		SetNillableUsername(nilIfEmpty(u.Username)).
+		SetNickname(u.Nickname).
+		SetAvatar(u.Avatar).
+		SetAuthType(u.AuthType).
+		SetNillablePhone(nilIfEmpty(u.Phone.Number)).
+		SetPasswordHash(u.PasswordHash).
+		SetEmailVerified(u.EmailVerified)
+	if u.ID != 0 {
+		create.SetID(u.ID)
+	}
+
+	po, err := create.Save(ctx)
+	if err != nil {
+		return nil, convertUserErr(err)
+	}
+	return toBizUser(po), nil
 }
 
-// Update 更新用户
+// Update 更新用户, 不修改密码
 func (r *userRepo) Update(ctx context.Context, u *biz.User) (*biz.User, error) {
-	// TODO: 实现用户更新逻辑
-	return u, nil
+	update := r.data.User(ctx).UpdateOneID(u.ID).
+		SetNickname(u.Nickname).
+		SetAvatar(u.Avatar).
+		SetAuthType(u.AuthType)
+	if u.Username == "" {
+		update.ClearUsername()
+	} else {
+		update.SetUsername(u.Username)
+	}
+	if u.Phone.Number == "" {
+		update.ClearPhone()
+	} else {
+		update.SetPhone(u.Phone.Number)
+	}
+
+	po, err := update.Save(ctx)
+	if err != nil {
+		return nil, convertUserErr(err)
+	}
+	return toBizUser(po), nil
+}
+
+// UpdatePassword 更新密码哈希
+func (r *userRepo) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
+	err := r.data.User(ctx).UpdateOneID(userID).
+		SetPasswordHash(passwordHash).
+		Exec(ctx)
+	return convertUserErr(err)
+}
+
+// MarkEmailVerified 把邮箱标记为已验证
+func (r *userRepo) MarkEmailVerified(ctx context.Context, userID int64) error {
+	err := r.data.User(ctx).UpdateOneID(userID).
+		SetEmailVerified(true).
+		Exec(ctx)
+	return convertUserErr(err)
 }
 
 // FindByID 通过ID查找用户
 func (r *userRepo) FindByID(ctx context.Context, id int64) (*biz.User, error) {
-	// TODO: 实现通过ID查找用户逻辑
-	return &biz.User{
-		ID:       id,
-		Username: "user_" + string(id),
-		Nickname: "用户" + string(id),
-	}, nil
+	po, err := r.data.User(ctx).Get(ctx, id)
+	if err != nil {
+		return nil, convertUserErr(err)
+	}
+	return toBizUser(po), nil
 }
 
 // FindByPhone 通过手机号查找用户
 func (r *userRepo) FindByPhone(ctx context.Context, phone string) (*biz.User, error) {
-	// TODO: 实现通过手机号查找用户逻辑
-	return &biz.User{
-		//Phone:    nil,
-		Username: "user_" + phone,
-		Nickname: "用户" + phone,
-	}, nil
+	po, err := r.data.User(ctx).Query().
+		Where(user.Phone(phone)).
+		Only(ctx)
+	if err != nil {
+		return nil, convertUserErr(err)
+	}
+	return toBizUser(po), nil
 }
 
 // FindByUsername 通过用户名查找用户
 func (r *userRepo) FindByUsername(ctx context.Context, username string) (*biz.User, error) {
-	// TODO: 实现通过用户名查找用户逻辑
-	return &biz.User{
-		Username: username,
-		Nickname: "用户" + username,
-	}, nil
+	po, err := r.data.User(ctx).Query().
+		Where(user.Username(username)).
+		Only(ctx)
+	if err != nil {
+		return nil, convertUserErr(err)
+	}
+	return toBizUser(po), nil
+}
+
+// FindByIdentity 通过登录方式查找用户
+func (r *userRepo) FindByIdentity(ctx context.Context, provider, subject string) (*biz.User, error) {
+	po, err := r.data.UserIdentity(ctx).Query().
+		Where(
+			useridentity.Provider(provider),
+			useridentity.Subject(subject),
+		).
+		QueryUser().
+		Only(ctx)
+	if err != nil {
+		return nil, convertUserErr(err)
+	}
+	return toBizUser(po), nil
+}
+
+// AddIdentity 给用户绑定登录方式
+func (r *userRepo) AddIdentity(ctx context.Context, id *biz.Identity) (*biz.Identity, error) {
+	// 先确认用户存在, 否则外键约束错误会被误判为身份已绑定
+	exist, err := r.data.User(ctx).Query().
+		Where(user.ID(id.UserID)).
+		Exist(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, biz.ErrUserNotFound
+	}
+
+	po, err := r.data.UserIdentity(ctx).Create().
+		SetUserID(id.UserID).
+		SetProvider(id.Provider).
+		SetSubject(id.Subject).
+		SetEmail(id.Email).
+		Save(ctx)
+	if err != nil {
+		if ent.IsConstraintError(err) {
+			return nil, biz.ErrIdentityAlreadyLinked
+		}
+		return nil, err
+	}
+	return toBizIdentity(po), nil
+}
+
+// RemoveIdentity 解绑登录方式
+func (r *userRepo) RemoveIdentity(ctx context.Context, userID int64, provider, subject string) error {
+	n, err := r.data.UserIdentity(ctx).Delete().
+		Where(
+			useridentity.UserID(userID),
+			useridentity.Provider(provider),
+			useridentity.Subject(subject),
+		).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return biz.ErrIdentityNotFound
+	}
+	return nil
+}
+
+// ListIdentities 按绑定时间顺序列出用户的所有登录方式
+func (r *userRepo) ListIdentities(ctx context.Context, userID int64) ([]*biz.Identity, error) {
+	pos, err := r.data.UserIdentity(ctx).Query().
+		Where(useridentity.UserID(userID)).
+		Order(ent.Asc(useridentity.FieldCreatedAt), ent.Asc(useridentity.FieldID)).
+		All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	identities := make([]*biz.Identity, 0, len(pos))
+	for _, po := range pos {
+		identities = append(identities, toBizIdentity(po))
+	}
+	return identities, nil
+}
+
+// convertUserErr 把 ent 的错误翻译成 biz 层定义的错误
+func convertUserErr(err error) error {
+	switch {
+	case ent.IsNotFound(err):
+		return biz.ErrUserNotFound
+	case ent.IsConstraintError(err):
+		return biz.ErrUserAlreadyExists
+	default:
+		return err
+	}
+}
+
+func toBizUser(po *ent.User) *biz.User {
+	u := &biz.User{
+		ID:            po.ID,
+		Nickname:      po.Nickname,
+		Avatar:        po.Avatar,
+		AuthType:      po.AuthType,
+		EmailVerified: po.EmailVerified,
+		PasswordHash:  po.PasswordHash,
+		CreatedAt:     po.CreatedAt,
+		UpdatedAt:     po.UpdatedAt,
+	}
+	if po.Username != nil {
+		u.Username = *po.Username
+	}
+	if po.Phone != nil {
+		u.Phone.Number = *po.Phone
+	}
+	return u
+}
+
+func toBizIdentity(po *ent.UserIdentity) *biz.Identity {
+	return &biz.Identity{
+		UserID:    po.UserID,
+		Provider:  po.Provider,
+		Subject:   po.Subject,
+		Email:     po.Email,
+		CreatedAt: po.CreatedAt,
+	}
+}
+
+func nilIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
 }
@@ -0,0 +1,301 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	userCacheTTL     = 10 * time.Minute
+	userCacheJitter  = time.Minute // 随机抖动, 避免同一批 key 同时过期
+	userNegativeTTL  = time.Minute // 不存在的用户也缓存, 挡住对不存在 ID 的反复查询
+	userNegativeMark = "-"
+	userLoadTimeout  = 3 * time.Second // 回源超时, 合并的请求共用同一次回源, 不受单个请求取消的影响
+)
+
+// userCache 是 biz.UserRepo 的读穿透缓存装饰器
+// FindByID/FindByPhone/ListIdentities 优先读 Redis, 未命中时经 singleflight 合并回源
+// 回源使用脱离请求的 ctx, 每个调用方拿到各自的副本
+// 写操作之后删除相关的 key, 由下一次读取重新加载
+// 事务中的读取直接回源, 删除 key 推迟到事务提交之后, 未提交的数据不会进缓存
+type userCache struct {
+	repo biz.UserRepo
+	rdb  *redis.Client
+	sf   singleflight.Group
+}
+
+func newUserCache(repo biz.UserRepo, rdb *redis.Client) biz.UserRepo {
+	return &userCache{
+		repo: repo,
+		rdb:  rdb,
+	}
+}
+
+func userIDKey(id int64) string {
+	return fmt.Sprintf("user:id:%d", id)
+}
+
+func userPhoneKey(phone string) string {
+	return "user:phone:" + phone
+}
+
+// userIdentitiesKey 挂在用户 key 下, 删除用户缓存时一并删除
+func userIdentitiesKey(id int64) string {
+	return userIDKey(id) + ":identities"
+}
+
+// Save 保存用户, 并清掉之前可能缓存的"不存在"
+func (c *userCache) Save(ctx context.Context, u *biz.User) (*biz.User, error) {
+	saved, err := c.repo.Save(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	c.invalidateAfterCommit(ctx, saved.ID, saved.Phone.Number)
+	return saved, nil
+}
+
+// Update 更新用户, 新旧手机号对应的 key 都需要删除
+func (c *userCache) Update(ctx context.Context, u *biz.User) (*biz.User, error) {
+	var oldPhone string
+	if old, ok := c.getUser(ctx, u.ID); ok && old != nil {
+		oldPhone = old.Phone.Number
+	}
+
+	updated, err := c.repo.Update(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	c.invalidateAfterCommit(ctx, u.ID, oldPhone, updated.Phone.Number)
+	return updated, nil
+}
+
+// FindByID 通过ID查找用户
+func (c *userCache) FindByID(ctx context.Context, id int64) (*biz.User, error) {
+	if txFromContext(ctx) != nil {
+		return c.repo.FindByID(ctx, id)
+	}
+	if u, ok := c.getUser(ctx, id); ok {
+		if u == nil {
+			return nil, biz.ErrUserNotFound
+		}
+		return u, nil
+	}
+
+	v, err, _ := c.sf.Do(userIDKey(id), func() (interface{}, error) {
+		ctx, cancel := loadContext(ctx)
+		defer cancel()
+		u, err := c.repo.FindByID(ctx, id)
+		if err != nil {
+			if errors.Is(err, biz.ErrUserNotFound) {
+				c.set(ctx, userIDKey(id), userNegativeMark, userNegativeTTL)
+			}
+			return nil, err
+		}
+		c.setUser(ctx, u)
+		return u, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(v.(*biz.User)), nil
+}
+
+// FindByPhone 通过手机号查找用户, 缓存中只保存手机号到 ID 的映射
+func (c *userCache) FindByPhone(ctx context.Context, phone string) (*biz.User, error) {
+	if txFromContext(ctx) != nil {
+		return c.repo.FindByPhone(ctx, phone)
+	}
+	key := userPhoneKey(phone)
+	val, err := c.rdb.Get(ctx, key).Result()
+	if err == nil {
+		if val == userNegativeMark {
+			return nil, biz.ErrUserNotFound
+		}
+		if id, err := strconv.ParseInt(val, 10, 64); err == nil {
+			u, err := c.FindByID(ctx, id)
+			// 映射可能已经过时(用户换了手机号), 校验后再返回
+			if err == nil && u.Phone.Number == phone {
+				return u, nil
+			}
+		}
+		c.rdb.Del(ctx, key)
+	}
+
+	v, err, _ := c.sf.Do(key, func() (interface{}, error) {
+		ctx, cancel := loadContext(ctx)
+		defer cancel()
+		u, err := c.repo.FindByPhone(ctx, phone)
+		if err != nil {
+			if errors.Is(err, biz.ErrUserNotFound) {
+				c.set(ctx, key, userNegativeMark, userNegativeTTL)
+			}
+			return nil, err
+		}
+		c.set(ctx, key, strconv.FormatInt(u.ID, 10), userTTL())
+		c.setUser(ctx, u)
+		return u, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(v.(*biz.User)), nil
+}
+
+// FindByUsername 通过用户名查找用户, 调用不频繁, 不走缓存
+func (c *userCache) FindByUsername(ctx context.Context, username string) (*biz.User, error) {
+	return c.repo.FindByUsername(ctx, username)
+}
+
+// FindByIdentity 通过登录方式查找用户, 不走缓存, 返回的用户带有密码哈希
+func (c *userCache) FindByIdentity(ctx context.Context, provider, subject string) (*biz.User, error) {
+	return c.repo.FindByIdentity(ctx, provider, subject)
+}
+
+// AddIdentity 绑定登录方式, 并删除缓存的身份列表
+func (c *userCache) AddIdentity(ctx context.Context, id *biz.Identity) (*biz.Identity, error) {
+	added, err := c.repo.AddIdentity(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.invalidateAfterCommit(ctx, id.UserID)
+	return added, nil
+}
+
+// RemoveIdentity 解绑登录方式, 并删除缓存的身份列表
+func (c *userCache) RemoveIdentity(ctx context.Context, userID int64, provider, subject string) error {
+	if err := c.repo.RemoveIdentity(ctx, userID, provider, subject); err != nil {
+		return err
+	}
+	c.invalidateAfterCommit(ctx, userID)
+	return nil
+}
+
+// ListIdentities 列出用户的登录方式, 获取用户信息时每次都会调用, 因此也走缓存
+func (c *userCache) ListIdentities(ctx context.Context, userID int64) ([]*biz.Identity, error) {
+	if txFromContext(ctx) != nil {
+		return c.repo.ListIdentities(ctx, userID)
+	}
+	key := userIdentitiesKey(userID)
+	if val, err := c.rdb.Get(ctx, key).Bytes(); err == nil {
+		var identities []*biz.Identity
+		if err := json.Unmarshal(val, &identities); err == nil {
+			return identities, nil
+		}
+	}
+
+	v, err, _ := c.sf.Do(key, func() (interface{}, error) {
+		ctx, cancel := loadContext(ctx)
+		defer cancel()
+		identities, err := c.repo.ListIdentities(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if b, err := json.Marshal(identities); err == nil {
+			c.set(ctx, key, b, userTTL())
+		}
+		return identities, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyIdentities(v.([]*biz.Identity)), nil
+}
+
+// UpdatePassword 更新密码, 缓存中不含密码哈希, 无需删除
+func (c *userCache) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
+	return c.repo.UpdatePassword(ctx, userID, passwordHash)
+}
+
+// MarkEmailVerified 标记邮箱已验证, 缓存的用户数据包含验证状态, 需要删除
+func (c *userCache) MarkEmailVerified(ctx context.Context, userID int64) error {
+	if err := c.repo.MarkEmailVerified(ctx, userID); err != nil {
+		return err
+	}
+	c.invalidateAfterCommit(ctx, userID)
+	return nil
+}
+
+// getUser 读取缓存, ok 为 false 表示未命中; 命中"不存在"时返回 nil, true
+func (c *userCache) getUser(ctx context.Context, id int64) (*biz.User, bool) {
+	val, err := c.rdb.Get(ctx, userIDKey(id)).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	if string(val) == userNegativeMark {
+		return nil, true
+	}
+
+	var u biz.User
+	if err := json.Unmarshal(val, &u); err != nil {
+		return nil, false
+	}
+	return &u, true
+}
+
+func (c *userCache) setUser(ctx context.Context, u *biz.User) {
+	cached := *u
+	// 令牌与验证码只在请求内有效, 密码哈希只在登录时从数据库读取, 都不能进缓存
+	cached.AuthToken = biz.AuthToken{}
+	cached.Phone.VerificationCode = ""
+	cached.Password = ""
+	cached.IDToken = ""
+	cached.PasswordHash = ""
+
+	b, err := json.Marshal(&cached)
+	if err != nil {
+		return
+	}
+	c.set(ctx, userIDKey(u.ID), b, userTTL())
+}
+
+// loadContext 返回回源用的 ctx: 保留 trace 等值, 但不随第一个调用方取消
+// 否则第一个请求超时或断开时, 合并在它上面的其他请求会一起失败
+func loadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), userLoadTimeout)
+}
+
+// set 写缓存失败只影响命中率, 不影响请求结果
+func (c *userCache) set(ctx context.Context, key string, val interface{}, ttl time.Duration) {
+	_ = c.rdb.Set(ctx, key, val, ttl).Err()
+}
+
+// invalidateAfterCommit 删除用户相关的 key, 在事务中时等提交后再删
+func (c *userCache) invalidateAfterCommit(ctx context.Context, id int64, phones ...string) {
+	afterCommit(ctx, func() {
+		// 提交钩子里的 ctx 可能已经取消, 删除缓存不能因此失败
+		c.invalidate(context.WithoutCancel(ctx), id, phones...)
+	})
+}
+
+func (c *userCache) invalidate(ctx context.Context, id int64, phones ...string) {
+	keys := []string{userIDKey(id), userIdentitiesKey(id)}
+	for _, phone := range phones {
+		if phone != "" {
+			keys = append(keys, userPhoneKey(phone))
+		}
+	}
+	_ = c.rdb.Del(ctx, keys...).Err()
+}
+
+// copyIdentities 复制 singleflight 共享的结果, 每个调用方拿到各自的副本
+func copyIdentities(identities []*biz.Identity) []*biz.Identity {
+	out := make([]*biz.Identity, 0, len(identities))
+	for _, id := range identities {
+		cp := *id
+		out = append(out, &cp)
+	}
+	return out
+}
+
+func userTTL() time.Duration {
+	return userCacheTTL + time.Duration(rand.Int63n(int64(userCacheJitter)))
+}
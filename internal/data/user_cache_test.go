@@ -0,0 +1,309 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/data/datatest"
+)
+
+func TestUserCache(t *testing.T) {
+	datatest.RunUserRepoSuite(t, func(t *testing.T) biz.UserRepo {
+		d := newTestData(t)
+		return newUserCache(&userRepo{data: d}, d.rdb)
+	})
+}
+
+func TestUserCacheTransaction(t *testing.T) {
+	datatest.RunTransactionSuite(t, func(t *testing.T) (biz.UserRepo, biz.Transaction) {
+		d := newTestData(t)
+		return newUserCache(&userRepo{data: d}, d.rdb), NewTransaction(d)
+	})
+}
+
+// countingRepo 记录回源次数, release 不为 nil 时回源会阻塞到它被关闭
+type countingRepo struct {
+	biz.UserRepo
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (r *countingRepo) FindByID(ctx context.Context, id int64) (*biz.User, error) {
+	r.calls.Add(1)
+	if r.release != nil {
+		<-r.release
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.UserRepo.FindByID(ctx, id)
+}
+
+func newCountingCache(t *testing.T) (*userCache, *countingRepo, *Data) {
+	t.Helper()
+	d := newTestData(t)
+	repo := &countingRepo{UserRepo: &userRepo{data: d}}
+	return newUserCache(repo, d.rdb).(*userCache), repo, d
+}
+
+func TestUserCache_MissThenHit(t *testing.T) {
+	ctx := context.Background()
+	cache, repo, d := newCountingCache(t)
+	u, err := cache.Save(ctx, &biz.User{Nickname: "alice", Phone: biz.Phone{Number: "13800000001"}})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	if _, err := cache.FindByID(ctx, u.ID); err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	// 绕过缓存直接改库, 命中缓存时读到的仍是旧值
+	if err := d.db.User.UpdateOneID(u.ID).SetNickname("changed").Exec(ctx); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	got, err := cache.FindByID(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if got.Nickname != "alice" {
+		t.Errorf("Nickname = %q, want cached %q", got.Nickname, "alice")
+	}
+	if n := repo.calls.Load(); n != 1 {
+		t.Errorf("repo calls = %d, want 1", n)
+	}
+
+	// 手机号映射命中后也不回源
+	byPhone, err := cache.FindByPhone(ctx, "13800000001")
+	if err != nil || byPhone.ID != u.ID {
+		t.Fatalf("FindByPhone() = %v, %v", byPhone, err)
+	}
+	if _, err := cache.FindByPhone(ctx, "13800000001"); err != nil {
+		t.Fatalf("FindByPhone() error = %v", err)
+	}
+	if n := repo.calls.Load(); n != 1 {
+		t.Errorf("repo calls = %d, want 1", n)
+	}
+}
+
+func TestUserCache_NegativeCache(t *testing.T) {
+	ctx := context.Background()
+	d, mr := newTestDataWithRedis(t)
+	cache := newUserCache(&userRepo{data: d}, d.rdb)
+
+	if _, err := cache.FindByID(ctx, 1); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Fatalf("FindByID() error = %v, want ErrUserNotFound", err)
+	}
+	if v, _ := mr.Get(userIDKey(1)); v != userNegativeMark {
+		t.Fatalf("cached value = %q, want negative mark", v)
+	}
+
+	// 绕过缓存写入 ID 为 1 的用户, 负缓存过期之前仍返回不存在
+	if _, err := (&userRepo{data: d}).Save(ctx, &biz.User{Nickname: "alice"}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if _, err := cache.FindByID(ctx, 1); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Fatalf("FindByID() error = %v, want cached ErrUserNotFound", err)
+	}
+
+	mr.FastForward(userNegativeTTL + time.Second)
+	if _, err := cache.FindByID(ctx, 1); err != nil {
+		t.Fatalf("FindByID() after negative ttl error = %v", err)
+	}
+}
+
+func TestUserCache_SaveClearsNegativeCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewUserRepo(newTestData(t))
+
+	if _, err := cache.FindByPhone(ctx, "13800000001"); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Fatalf("FindByPhone() error = %v, want ErrUserNotFound", err)
+	}
+	u, err := cache.Save(ctx, &biz.User{Nickname: "alice", Phone: biz.Phone{Number: "13800000001"}})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	got, err := cache.FindByPhone(ctx, "13800000001")
+	if err != nil || got.ID != u.ID {
+		t.Fatalf("FindByPhone() = %v, %v, want user %d", got, err, u.ID)
+	}
+}
+
+func TestUserCache_Singleflight(t *testing.T) {
+	ctx := context.Background()
+	cache, repo, _ := newCountingCache(t)
+	u, err := cache.Save(ctx, &biz.User{Nickname: "alice"})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	repo.release = make(chan struct{})
+
+	// 第一个调用方在回源期间取消, 不应影响合并在同一次回源上的其他调用方
+	firstCtx, cancel := context.WithCancel(ctx)
+	const waiters = 8
+	results := make([]*biz.User, waiters)
+	errs := make([]error, waiters)
+	var wg sync.WaitGroup
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := ctx
+			if i == 0 {
+				c = firstCtx
+			}
+			results[i], errs[i] = cache.FindByID(c, u.ID)
+		}(i)
+		if i == 0 {
+			waitFor(t, func() bool { return repo.calls.Load() == 1 })
+		}
+	}
+	time.Sleep(50 * time.Millisecond) // 让其余调用方进入 singleflight
+	cancel()
+	close(repo.release)
+	wg.Wait()
+
+	if n := repo.calls.Load(); n != 1 {
+		t.Errorf("repo calls = %d, want 1", n)
+	}
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatalf("caller %d error = %v", i, errs[i])
+		}
+		if results[i].ID != u.ID {
+			t.Fatalf("caller %d got user %d, want %d", i, results[i].ID, u.ID)
+		}
+	}
+	// 每个调用方拿到独立的副本, 修改一个不影响其他
+	results[0].Nickname = "mutated"
+	for i := 1; i < waiters; i++ {
+		if results[i] == results[0] || results[i].Nickname != "alice" {
+			t.Fatalf("caller %d shares the user with caller 0", i)
+		}
+	}
+}
+
+func TestUserCache_InvalidateAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	d, mr := newTestDataWithRedis(t)
+	cache := NewUserRepo(d)
+	tx := NewTransaction(d)
+
+	u, err := cache.Save(ctx, &biz.User{Nickname: "alice", Phone: biz.Phone{Number: "13800000001"}})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if _, err := cache.FindByPhone(ctx, "13800000001"); err != nil {
+		t.Fatalf("FindByPhone() error = %v", err)
+	}
+
+	errRollback := errors.New("rollback")
+	err = tx.ExecTx(ctx, func(ctx context.Context) error {
+		u.Nickname = "bob"
+		if _, err := cache.Update(ctx, u); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("ExecTx() error = %v", err)
+	}
+	if !mr.Exists(userIDKey(u.ID)) {
+		t.Fatal("cache invalidated by a rolled back transaction")
+	}
+
+	err = tx.ExecTx(ctx, func(ctx context.Context) error {
+		u.Nickname = "carol"
+		u.Phone.Number = "13800000002"
+		if _, err := cache.Update(ctx, u); err != nil {
+			return err
+		}
+		// 提交之前不删除, 否则并发的读请求会把旧数据重新写回缓存
+		if !mr.Exists(userIDKey(u.ID)) {
+			t.Error("cache invalidated before commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExecTx() error = %v", err)
+	}
+	for _, key := range []string{userIDKey(u.ID), userPhoneKey("13800000001")} {
+		if mr.Exists(key) {
+			t.Errorf("key %s still cached after commit", key)
+		}
+	}
+
+	got, err := cache.FindByID(ctx, u.ID)
+	if err != nil || got.Nickname != "carol" {
+		t.Fatalf("FindByID() = %v, %v, want carol", got, err)
+	}
+	if _, err := cache.FindByPhone(ctx, "13800000001"); !errors.Is(err, biz.ErrUserNotFound) {
+		t.Fatalf("FindByPhone(old) error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestUserCache_Identities(t *testing.T) {
+	ctx := context.Background()
+	d, mr := newTestDataWithRedis(t)
+	cache := NewUserRepo(d)
+	tx := NewTransaction(d)
+
+	u, err := cache.Save(ctx, &biz.User{Nickname: "alice"})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if _, err := cache.AddIdentity(ctx, &biz.Identity{UserID: u.ID, Provider: "google", Subject: "g-1"}); err != nil {
+		t.Fatalf("AddIdentity() error = %v", err)
+	}
+	list, err := cache.ListIdentities(ctx, u.ID)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("ListIdentities() = %v, %v, want 1 identity", list, err)
+	}
+	if !mr.Exists(userIdentitiesKey(u.ID)) {
+		t.Fatal("identities not cached")
+	}
+
+	// 绕过缓存直接删库, 命中缓存时仍返回旧列表
+	if err := (&userRepo{data: d}).RemoveIdentity(ctx, u.ID, "google", "g-1"); err != nil {
+		t.Fatalf("RemoveIdentity() error = %v", err)
+	}
+	if list, _ := cache.ListIdentities(ctx, u.ID); len(list) != 1 {
+		t.Fatalf("ListIdentities() = %d identities, want cached 1", len(list))
+	}
+
+	// 事务中绑定, 提交后缓存失效
+	err = tx.ExecTx(ctx, func(ctx context.Context) error {
+		_, err := cache.AddIdentity(ctx, &biz.Identity{UserID: u.ID, Provider: biz.AuthTypePhone, Subject: "13800000001"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ExecTx() error = %v", err)
+	}
+	list, err = cache.ListIdentities(ctx, u.ID)
+	if err != nil || len(list) != 1 || list[0].Provider != biz.AuthTypePhone {
+		t.Fatalf("ListIdentities() after add = %v, %v", list, err)
+	}
+
+	if err := cache.RemoveIdentity(ctx, u.ID, biz.AuthTypePhone, "13800000001"); err != nil {
+		t.Fatalf("RemoveIdentity() error = %v", err)
+	}
+	if list, _ := cache.ListIdentities(ctx, u.ID); len(list) != 0 {
+		t.Fatalf("ListIdentities() after remove = %d identities, want 0", len(list))
+	}
+}
+
+// waitFor 轮询直到 cond 成立, 超过一秒判定失败
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,318 @@
+package data
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+)
+
+// memoryUserRepo 是 biz.UserRepo 的内存实现, 并发安全
+// 手机号与用户名各自维护唯一索引, 行为与数据库实现保持一致
+// 事务中的写操作登记撤销函数, 事务失败时由 memoryTransaction 撤销, 见 onRollback
+type memoryUserRepo struct {
+	mu        sync.RWMutex
+	lastID    int64
+	users     map[int64]*biz.User
+	phones    map[string]int64
+	usernames map[string]int64
+
+	identities     map[identityKey]int64     // (provider, subject) -> 用户ID
+	userIdentities map[int64][]*biz.Identity // 按绑定顺序保存
+}
+
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+func newMemoryUserRepo() *memoryUserRepo {
+	return &memoryUserRepo{
+		users:          make(map[int64]*biz.User),
+		phones:         make(map[string]int64),
+		usernames:      make(map[string]int64),
+		identities:     make(map[identityKey]int64),
+		userIdentities: make(map[int64][]*biz.Identity),
+	}
+}
+
+// Save 保存用户, 未指定 ID 时自增分配
+func (r *memoryUserRepo) Save(ctx context.Context, u *biz.User) (*biz.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u.ID != 0 {
+		if _, ok := r.users[u.ID]; ok {
+			return nil, biz.ErrUserAlreadyExists
+		}
+	}
+	if r.conflicts(0, u) {
+		return nil, biz.ErrUserAlreadyExists
+	}
+
+	saved := copyUser(u)
+	if saved.ID == 0 {
+		saved.ID = r.lastID + 1
+	}
+	if saved.ID > r.lastID {
+		r.lastID = saved.ID
+	}
+	now := time.Now()
+	saved.CreatedAt = now
+	saved.UpdatedAt = now
+
+	r.users[saved.ID] = saved
+	r.index(saved)
+	r.onRollback(ctx, func() {
+		delete(r.users, saved.ID)
+		r.unindex(saved)
+	})
+	return copyUser(saved), nil
+}
+
+// Update 更新用户, 不修改密码
+func (r *memoryUserRepo) Update(ctx context.Context, u *biz.User) (*biz.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.users[u.ID]
+	if !ok {
+		return nil, biz.ErrUserNotFound
+	}
+	if r.conflicts(u.ID, u) {
+		return nil, biz.ErrUserAlreadyExists
+	}
+
+	updated := copyUser(u)
+	updated.PasswordHash = old.PasswordHash
+	updated.EmailVerified = old.EmailVerified
+	updated.CreatedAt = old.CreatedAt
+	updated.UpdatedAt = time.Now()
+
+	r.unindex(old)
+	r.users[updated.ID] = updated
+	r.index(updated)
+	r.onRollback(ctx, func() {
+		r.unindex(r.users[old.ID])
+		r.users[old.ID] = old
+		r.index(old)
+	})
+	return copyUser(updated), nil
+}
+
+// UpdatePassword 更新密码哈希
+func (r *memoryUserRepo) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userID]
+	if !ok {
+		return biz.ErrUserNotFound
+	}
+	old := u.PasswordHash
+	u.PasswordHash = passwordHash
+	u.UpdatedAt = time.Now()
+	r.onRollback(ctx, func() {
+		if u, ok := r.users[userID]; ok {
+			u.PasswordHash = old
+		}
+	})
+	return nil
+}
+
+// MarkEmailVerified 把邮箱标记为已验证
+func (r *memoryUserRepo) MarkEmailVerified(ctx context.Context, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userID]
+	if !ok {
+		return biz.ErrUserNotFound
+	}
+	old := u.EmailVerified
+	u.EmailVerified = true
+	u.UpdatedAt = time.Now()
+	r.onRollback(ctx, func() {
+		if u, ok := r.users[userID]; ok {
+			u.EmailVerified = old
+		}
+	})
+	return nil
+}
+
+// FindByID 通过ID查找用户
+func (r *memoryUserRepo) FindByID(_ context.Context, id int64) (*biz.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.get(id)
+}
+
+// FindByPhone 通过手机号查找用户
+func (r *memoryUserRepo) FindByPhone(_ context.Context, phone string) (*biz.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.phones[phone]
+	if !ok {
+		return nil, biz.ErrUserNotFound
+	}
+	return r.get(id)
+}
+
+// FindByUsername 通过用户名查找用户
+func (r *memoryUserRepo) FindByUsername(_ context.Context, username string) (*biz.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.usernames[username]
+	if !ok {
+		return nil, biz.ErrUserNotFound
+	}
+	return r.get(id)
+}
+
+// FindByIdentity 通过登录方式查找用户
+func (r *memoryUserRepo) FindByIdentity(_ context.Context, provider, subject string) (*biz.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.identities[identityKey{provider, subject}]
+	if !ok {
+		return nil, biz.ErrUserNotFound
+	}
+	return r.get(id)
+}
+
+// AddIdentity 给用户绑定登录方式
+func (r *memoryUserRepo) AddIdentity(ctx context.Context, id *biz.Identity) (*biz.Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[id.UserID]; !ok {
+		return nil, biz.ErrUserNotFound
+	}
+	key := identityKey{id.Provider, id.Subject}
+	if _, ok := r.identities[key]; ok {
+		return nil, biz.ErrIdentityAlreadyLinked
+	}
+
+	saved := *id
+	saved.CreatedAt = time.Now()
+	r.identities[key] = id.UserID
+	r.userIdentities[id.UserID] = append(r.userIdentities[id.UserID], &saved)
+	r.onRollback(ctx, func() {
+		delete(r.identities, key)
+		r.removeIdentity(id.UserID, key)
+	})
+
+	cp := saved
+	return &cp, nil
+}
+
+// RemoveIdentity 解绑登录方式
+func (r *memoryUserRepo) RemoveIdentity(ctx context.Context, userID int64, provider, subject string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := identityKey{provider, subject}
+	if owner, ok := r.identities[key]; !ok || owner != userID {
+		return biz.ErrIdentityNotFound
+	}
+	delete(r.identities, key)
+	if i, removed := r.removeIdentity(userID, key); removed != nil {
+		r.onRollback(ctx, func() {
+			r.identities[key] = userID
+			list := r.userIdentities[userID]
+			r.userIdentities[userID] = append(list[:i:i], append([]*biz.Identity{removed}, list[i:]...)...)
+		})
+	}
+	return nil
+}
+
+// removeIdentity 从用户的登录方式列表中移除 key, 返回它原来的位置
+func (r *memoryUserRepo) removeIdentity(userID int64, key identityKey) (int, *biz.Identity) {
+	list := r.userIdentities[userID]
+	for i, id := range list {
+		if id.Provider == key.provider && id.Subject == key.subject {
+			r.userIdentities[userID] = append(list[:i:i], list[i+1:]...)
+			return i, id
+		}
+	}
+	return 0, nil
+}
+
+// onRollback 在 ctx 处于内存事务中时登记撤销函数, 调用方须持有写锁, 撤销时同样在写锁内执行
+func (r *memoryUserRepo) onRollback(ctx context.Context, undo func()) {
+	tx := memoryTxFromContext(ctx)
+	if tx == nil {
+		return
+	}
+	tx.onRollback(func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		undo()
+	})
+}
+
+// ListIdentities 按绑定时间顺序列出用户的所有登录方式
+func (r *memoryUserRepo) ListIdentities(_ context.Context, userID int64) ([]*biz.Identity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := r.userIdentities[userID]
+	identities := make([]*biz.Identity, 0, len(list))
+	for _, id := range list {
+		cp := *id
+		identities = append(identities, &cp)
+	}
+	return identities, nil
+}
+
+func (r *memoryUserRepo) get(id int64) (*biz.User, error) {
+	u, ok := r.users[id]
+	if !ok {
+		return nil, biz.ErrUserNotFound
+	}
+	return copyUser(u), nil
+}
+
+// conflicts 判断手机号或用户名是否已被 self 以外的用户占用
+func (r *memoryUserRepo) conflicts(self int64, u *biz.User) bool {
+	if u.Phone.Number != "" {
+		if id, ok := r.phones[u.Phone.Number]; ok && id != self {
+			return true
+		}
+	}
+	if u.Username != "" {
+		if id, ok := r.usernames[u.Username]; ok && id != self {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memoryUserRepo) index(u *biz.User) {
+	if u.Phone.Number != "" {
+		r.phones[u.Phone.Number] = u.ID
+	}
+	if u.Username != "" {
+		r.usernames[u.Username] = u.ID
+	}
+}
+
+func (r *memoryUserRepo) unindex(u *biz.User) {
+	delete(r.phones, u.Phone.Number)
+	delete(r.usernames, u.Username)
+}
+
+// copyUser 只保存持久化的字段, 避免调用方修改到仓库内部的数据
+func copyUser(u *biz.User) *biz.User {
+	cp := *u
+	cp.AuthToken = biz.AuthToken{}
+	cp.Phone.VerificationCode = ""
+	cp.Password = ""
+	cp.IDToken = ""
+	return &cp
+}
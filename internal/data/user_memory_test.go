@@ -0,0 +1,20 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/data/datatest"
+)
+
+func TestMemoryUserRepo(t *testing.T) {
+	datatest.RunUserRepoSuite(t, func(t *testing.T) biz.UserRepo {
+		return newMemoryUserRepo()
+	})
+}
+
+func TestMemoryTransaction(t *testing.T) {
+	datatest.RunTransactionSuite(t, func(t *testing.T) (biz.UserRepo, biz.Transaction) {
+		return newMemoryUserRepo(), memoryTransaction{}
+	})
+}
@@ -0,0 +1,21 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/data/datatest"
+)
+
+func TestUserRepo(t *testing.T) {
+	datatest.RunUserRepoSuite(t, func(t *testing.T) biz.UserRepo {
+		return &userRepo{data: newTestData(t)}
+	})
+}
+
+func TestTransaction(t *testing.T) {
+	datatest.RunTransactionSuite(t, func(t *testing.T) (biz.UserRepo, biz.Transaction) {
+		d := newTestData(t)
+		return &userRepo{data: d}, NewTransaction(d)
+	})
+}
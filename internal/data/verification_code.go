@@ -0,0 +1,71 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/redis/go-redis/v9"
+)
+
+// verifyCodeScript 校验与计数在一个脚本里完成, 并发请求不能绕过次数限制
+// 返回 1 表示正确, 0 表示错误或不存在
+var verifyCodeScript = redis.NewScript(`
+local code = redis.call("HGET", KEYS[1], "code")
+if not code then
+	return 0
+end
+if code == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+	return 1
+end
+if redis.call("HINCRBY", KEYS[1], "attempts", 1) >= tonumber(ARGV[2]) then
+	redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type verificationCodeRepo struct {
+	rdb *redis.Client
+}
+
+// NewVerificationCodeRepo 创建基于 Redis 的验证码仓库
+func NewVerificationCodeRepo(data *Data) biz.VerificationCodeRepo {
+	return &verificationCodeRepo{rdb: data.rdb}
+}
+
+// NewVerificationPolicy 从 conf.Data.Sms 读取验证码参数
+func NewVerificationPolicy(c *conf.Data) *biz.VerificationPolicy {
+	sc := c.GetSms()
+	return &biz.VerificationPolicy{
+		CodeTTL:     sc.GetCodeTtl().AsDuration(),
+		CodeLength:  int(sc.GetCodeLength()),
+		MaxAttempts: int(sc.GetMaxAttempts()),
+	}
+}
+
+func verificationCodeKey(purpose, phone string) string {
+	return "vcode:" + purpose + ":" + phone
+}
+
+// Save 保存验证码, 覆盖旧验证码并重置输错次数
+func (r *verificationCodeRepo) Save(ctx context.Context, purpose, phone, code string, ttl time.Duration) error {
+	key := verificationCodeKey(purpose, phone)
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, "code", code, "attempts", 0)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+// Verify 校验验证码, 正确时删除, 错误次数达到上限时作废
+func (r *verificationCodeRepo) Verify(ctx context.Context, purpose, phone, code string, maxAttempts int) (bool, error) {
+	n, err := verifyCodeScript.Run(ctx, r.rdb, []string{verificationCodeKey(purpose, phone)}, code, maxAttempts).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
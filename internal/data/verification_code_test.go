@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+)
+
+func newTestVerificationCodeRepo(t *testing.T) biz.VerificationCodeRepo {
+	t.Helper()
+	return NewVerificationCodeRepo(newTestData(t))
+}
+
+func mustVerify(t *testing.T, repo biz.VerificationCodeRepo, purpose, phone, code string, want bool) {
+	t.Helper()
+	ok, err := repo.Verify(context.Background(), purpose, phone, code, 3)
+	if err != nil {
+		t.Fatalf("Verify(%s, %s) error = %v", purpose, code, err)
+	}
+	if ok != want {
+		t.Fatalf("Verify(%s, %s) = %v, want %v", purpose, code, ok, want)
+	}
+}
+
+func TestVerificationCodeRepo_SingleUse(t *testing.T) {
+	repo := newTestVerificationCodeRepo(t)
+	if err := repo.Save(context.Background(), biz.CodePurposeLogin, "13800000001", "123456", time.Minute); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "123456", true)
+	// 正确的验证码只能使用一次
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "123456", false)
+	// 不存在的验证码
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000002", "123456", false)
+}
+
+func TestVerificationCodeRepo_MaxAttempts(t *testing.T) {
+	d, mr := newTestDataWithRedis(t)
+	repo := NewVerificationCodeRepo(d)
+	key := verificationCodeKey(biz.CodePurposeLogin, "13800000001")
+	if err := repo.Save(context.Background(), biz.CodePurposeLogin, "13800000001", "123456", time.Minute); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "000000", false)
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "000001", false)
+	if !mr.Exists(key) {
+		t.Fatal("code deleted before reaching max attempts")
+	}
+	// 第三次输错后作废, 之后正确的验证码也无法通过
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "000002", false)
+	if mr.Exists(key) {
+		t.Fatal("code not deleted after max attempts")
+	}
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "123456", false)
+}
+
+func TestVerificationCodeRepo_SaveResetsAttempts(t *testing.T) {
+	d, mr := newTestDataWithRedis(t)
+	repo := NewVerificationCodeRepo(d)
+	ctx := context.Background()
+	key := verificationCodeKey(biz.CodePurposeLogin, "13800000001")
+
+	if err := repo.Save(ctx, biz.CodePurposeLogin, "13800000001", "111111", time.Minute); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "000000", false)
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "000001", false)
+
+	// 重新发送覆盖旧验证码, 输错次数从零开始
+	if err := repo.Save(ctx, biz.CodePurposeLogin, "13800000001", "222222", 2*time.Minute); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if ttl := mr.TTL(key); ttl != 2*time.Minute {
+		t.Errorf("TTL = %v, want 2m", ttl)
+	}
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "111111", false)
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "000002", false)
+	if !mr.Exists(key) {
+		t.Fatal("attempts were not reset by Save")
+	}
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "222222", true)
+}
+
+func TestVerificationCodeRepo_Expires(t *testing.T) {
+	d, mr := newTestDataWithRedis(t)
+	repo := NewVerificationCodeRepo(d)
+	if err := repo.Save(context.Background(), biz.CodePurposeLogin, "13800000001", "123456", time.Minute); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	mr.FastForward(time.Minute)
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "123456", false)
+}
+
+func TestVerificationCodeRepo_PurposesAreSeparate(t *testing.T) {
+	repo := newTestVerificationCodeRepo(t)
+	ctx := context.Background()
+	if err := repo.Save(ctx, biz.CodePurposeLogin, "13800000001", "111111", time.Minute); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := repo.Save(ctx, biz.CodePurposeReset, "13800000001", "222222", time.Minute); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// 登录验证码不能用于重置密码, 输错也不影响另一用途
+	mustVerify(t, repo, biz.CodePurposeReset, "13800000001", "111111", false)
+	mustVerify(t, repo, biz.CodePurposeRegister, "13800000001", "111111", false)
+	mustVerify(t, repo, biz.CodePurposeLogin, "13800000001", "111111", true)
+	mustVerify(t, repo, biz.CodePurposeReset, "13800000001", "222222", true)
+}
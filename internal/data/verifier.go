@@ -0,0 +1,386 @@
+package data
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultJWKSCacheTTL = time.Hour
+	defaultJWKSTimeout  = 5 * time.Second
+
+	// jwksMinRefresh 遇到未知 kid 时重新拉取 JWKS 的最小间隔, 避免伪造的 kid 把请求打到提供方
+	jwksMinRefresh = time.Minute
+)
+
+// google 提供方的默认配置
+const googleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// NewProviderRegistry 根据 conf.Data.OidcProviders 创建第三方登录提供方注册表
+// 配置不完整的提供方会导致启动失败, 而不是在登录时才发现
+func NewProviderRegistry(c *conf.Data) (*biz.ProviderRegistry, error) {
+	providers := make([]biz.IdentityProvider, 0, len(c.GetOidcProviders()))
+	for _, pc := range c.GetOidcProviders() {
+		p, err := newOIDCProvider(pc)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return biz.NewProviderRegistry(providers...)
+}
+
+func newOIDCProvider(c *conf.Data_OidcProvider) (*oidcProvider, error) {
+	url, issuers := c.GetJwksUrl(), c.GetIssuers()
+	if c.GetName() == biz.AuthTypeGoogle {
+		if url == "" {
+			url = googleJWKSURL
+		}
+		if len(issuers) == 0 {
+			issuers = googleIssuers
+		}
+	}
+	switch {
+	case url == "":
+		return nil, fmt.Errorf("oidc provider %q: jwks_url is required", c.GetName())
+	case len(issuers) == 0:
+		return nil, fmt.Errorf("oidc provider %q: issuers is required", c.GetName())
+	case len(c.GetClientIds()) == 0:
+		return nil, fmt.Errorf("oidc provider %q: client_ids is required", c.GetName())
+	}
+
+	ttl := c.GetJwksCacheTtl().AsDuration()
+	if ttl <= 0 {
+		ttl = defaultJWKSCacheTTL
+	}
+	timeout := c.GetHttpTimeout().AsDuration()
+	if timeout <= 0 {
+		timeout = defaultJWKSTimeout
+	}
+
+	m := c.GetClaims()
+	return &oidcProvider{
+		name:          c.GetName(),
+		audiences:     c.GetClientIds(),
+		issuers:       issuers,
+		emailOptional: c.GetEmailOptional(),
+		claims: claimMapping{
+			subject:       orDefault(m.GetSubject(), "sub"),
+			email:         orDefault(m.GetEmail(), "email"),
+			emailVerified: orDefault(m.GetEmailVerified(), "email_verified"),
+			name:          orDefault(m.GetName(), "name"),
+			picture:       orDefault(m.GetPicture(), "picture"),
+		},
+		keys: newJWKSCache(url, ttl, &http.Client{Timeout: timeout}),
+	}, nil
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// claimMapping 是 ExternalIdentity 各字段对应的声明名
+type claimMapping struct {
+	subject       string
+	email         string
+	emailVerified string
+	name          string
+	picture       string
+}
+
+// oidcProvider 校验签名、exp、iss、aud 与邮箱是否已验证
+type oidcProvider struct {
+	name          string
+	audiences     []string
+	issuers       []string
+	emailOptional bool
+	claims        claimMapping
+	keys          *jwksCache
+}
+
+func (p *oidcProvider) Name() string {
+	return p.name
+}
+
+func (p *oidcProvider) VerifyIDToken(ctx context.Context, idToken string) (*biz.ExternalIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.keys.key(ctx, kid)
+	}, jwt.WithValidMethods([]string{"RS256", "ES256"}))
+	if err != nil {
+		// JWKS 拉取失败不是凭证的问题, 单独返回便于客户端重试
+		if errors.Is(err, biz.ErrVerificationUnavailable) {
+			return nil, biz.ErrVerificationUnavailable
+		}
+		return nil, biz.ErrInvalidIDToken.WithCause(err)
+	}
+
+	// ParseWithClaims 已校验 exp、nbf 与 iat, 但 exp 缺失时不会报错
+	if _, ok := claims["exp"]; !ok {
+		return nil, biz.ErrInvalidIDToken.WithCause(fmt.Errorf("missing exp"))
+	}
+	if iss, _ := claims["iss"].(string); !containsString(p.issuers, iss) {
+		return nil, biz.ErrInvalidIDToken.WithCause(fmt.Errorf("unexpected issuer %q", iss))
+	}
+	if !p.audienceAllowed(claims["aud"]) {
+		return nil, biz.ErrInvalidIDToken.WithCause(fmt.Errorf("unexpected audience %v", claims["aud"]))
+	}
+
+	ext := &biz.ExternalIdentity{
+		Subject:       claimString(claims, p.claims.subject),
+		Email:         claimString(claims, p.claims.email),
+		EmailVerified: claimBool(claims, p.claims.emailVerified),
+		Name:          claimString(claims, p.claims.name),
+		Picture:       claimString(claims, p.claims.picture),
+	}
+	if ext.Subject == "" {
+		return nil, biz.ErrInvalidIDToken.WithCause(fmt.Errorf("missing %s", p.claims.subject))
+	}
+	// 邮箱未验证的账号可能被他人抢注, 不接受
+	if ext.Email != "" && !ext.EmailVerified {
+		return nil, biz.ErrInvalidIDToken.WithCause(fmt.Errorf("email not verified"))
+	}
+	if ext.Email == "" && !p.emailOptional {
+		return nil, biz.ErrInvalidIDToken.WithCause(fmt.Errorf("missing %s", p.claims.email))
+	}
+	return ext, nil
+}
+
+// audienceAllowed aud 可以是字符串或字符串数组, 任意一个在 client_ids 中即可
+func (p *oidcProvider) audienceAllowed(aud interface{}) bool {
+	switch v := aud.(type) {
+	case string:
+		return containsString(p.audiences, v)
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && containsString(p.audiences, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// claimBool 兼容布尔值与字符串, Apple 的 email_verified 是字符串
+func claimBool(claims jwt.MapClaims, name string) bool {
+	switch v := claims[name].(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// jwksCache 缓存签名公钥, 过期时间优先取响应的 Cache-Control max-age
+// 拉取失败时继续使用已过期的公钥, 避免提供方短暂不可用导致全部登录失败
+// 拉取在锁外进行, 并发的拉取经 singleflight 合并为一次
+type jwksCache struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+	sf     singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	expiresAt time.Time
+	fetchedAt time.Time
+}
+
+func newJWKSCache(url string, ttl time.Duration, client *http.Client) *jwksCache {
+	return &jwksCache{url: url, ttl: ttl, client: client}
+}
+
+// key 返回 kid 对应的公钥, 拉取失败且没有可用公钥时返回 ErrVerificationUnavailable
+func (c *jwksCache) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k, ok, stale := c.lookup(kid, time.Now())
+	if stale {
+		if err := c.refresh(ctx); err != nil {
+			if ok {
+				return k, nil
+			}
+			return nil, biz.ErrVerificationUnavailable.WithCause(err)
+		}
+		k, ok, _ = c.lookup(kid, time.Now())
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	return k, nil
+}
+
+// lookup 查找 kid 对应的公钥, stale 表示需要重新拉取
+func (c *jwksCache) lookup(kid string, now time.Time) (k crypto.PublicKey, ok, stale bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k, ok = c.keys[kid]
+	// 公钥轮换后新 kid 可能先于缓存过期出现, 此时按最小间隔提前刷新
+	stale = now.After(c.expiresAt) || (!ok && now.Sub(c.fetchedAt) >= jwksMinRefresh)
+	return k, ok, stale
+}
+
+// refresh 重新拉取 JWKS
+// 拉取使用脱离请求的 ctx, 第一个请求取消时合并在它上面的其他请求仍能拿到结果; 耗时由 http.Client 的超时限制
+func (c *jwksCache) refresh(ctx context.Context) error {
+	ch := c.sf.DoChan(c.url, func() (interface{}, error) {
+		return nil, c.fetch(context.WithoutCancel(ctx))
+	})
+	select {
+	case res := <-ch:
+		return res.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *jwksCache) fetch(ctx context.Context) error {
+	now := time.Now()
+	c.mu.Lock()
+	c.fetchedAt = now
+	c.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decode jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		// 不认识的密钥类型直接跳过, 不影响其他公钥
+		if k, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = k
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("jwks contains no usable keys")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys = keys
+	c.expiresAt = now.Add(cacheMaxAge(resp.Header.Get("Cache-Control"), c.ttl))
+	return nil
+}
+
+// cacheMaxAge 解析 Cache-Control 中的 max-age, 没有时返回 def
+func cacheMaxAge(header string, def time.Duration) time.Duration {
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		if sec, err := strconv.Atoi(value); err == nil && sec > 0 {
+			return time.Duration(sec) * time.Second
+		}
+	}
+	return def
+}
+
+// jsonWebKey 是 RFC 7517 中的公钥, 只支持 RSA 与 P-256
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
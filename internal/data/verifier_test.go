@@ -0,0 +1,488 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jwksServer 是本地的 JWKS 桩服务, 可以切换公钥、Cache-Control 与故障
+type jwksServer struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	keys         map[string]*rsa.PrivateKey
+	cacheControl string
+	fail         bool
+	block        chan struct{} // 不为 nil 时响应前等待它被关闭
+	hits         atomic.Int32
+}
+
+func newJWKSServer(t *testing.T, kids ...string) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: map[string]*rsa.PrivateKey{}}
+	for _, kid := range kids {
+		s.addKey(t, kid)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) addKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[kid] = key
+}
+
+func (s *jwksServer) set(fn func(s *jwksServer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s)
+}
+
+func (s *jwksServer) serve(w http.ResponseWriter, _ *http.Request) {
+	s.hits.Add(1)
+	s.mu.Lock()
+	block, fail, cacheControl := s.block, s.fail, s.cacheControl
+	keys := make([]map[string]string, 0, len(s.keys))
+	for kid, key := range s.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	s.mu.Unlock()
+
+	if block != nil {
+		<-block
+	}
+	if fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+}
+
+// sign 用 kid 对应的私钥签发 id_token
+func (s *jwksServer) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	s.mu.Lock()
+	key := s.keys[kid]
+	s.mu.Unlock()
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = kid
+	signed, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed
+}
+
+func newTestOIDCProvider(t *testing.T, pc *conf.Data_OidcProvider) *oidcProvider {
+	t.Helper()
+	p, err := newOIDCProvider(pc)
+	if err != nil {
+		t.Fatalf("newOIDCProvider() error = %v", err)
+	}
+	return p
+}
+
+func googleClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            "https://accounts.google.com",
+		"aud":            "client-id",
+		"sub":            "google-sub",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+}
+
+// expire 让缓存的公钥过期, 并允许立即重新拉取
+func expire(c *jwksCache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expiresAt = time.Now().Add(-time.Second)
+	c.fetchedAt = time.Now().Add(-jwksMinRefresh)
+}
+
+func TestJWKSCache_KidRotation(t *testing.T) {
+	ctx := context.Background()
+	srv := newJWKSServer(t, "k1")
+	p := newTestOIDCProvider(t, &conf.Data_OidcProvider{Name: "google", ClientIds: []string{"client-id"}, JwksUrl: srv.URL})
+
+	if _, err := p.VerifyIDToken(ctx, srv.sign(t, "k1", googleClaims())); err != nil {
+		t.Fatalf("VerifyIDToken(k1) error = %v", err)
+	}
+
+	// 提供方轮换出新 kid, 最小间隔内不会为未知 kid 重新拉取
+	srv.addKey(t, "k2")
+	if _, err := p.VerifyIDToken(ctx, srv.sign(t, "k2", googleClaims())); !errors.Is(err, biz.ErrInvalidIDToken) {
+		t.Fatalf("VerifyIDToken(k2) error = %v, want ErrInvalidIDToken", err)
+	}
+	if n := srv.hits.Load(); n != 1 {
+		t.Fatalf("jwks hits = %d, want 1", n)
+	}
+
+	// 超过最小间隔后, 未知 kid 触发提前刷新, 不必等缓存过期
+	p.keys.mu.Lock()
+	p.keys.fetchedAt = time.Now().Add(-jwksMinRefresh)
+	p.keys.mu.Unlock()
+	if _, err := p.VerifyIDToken(ctx, srv.sign(t, "k2", googleClaims())); err != nil {
+		t.Fatalf("VerifyIDToken(k2) after refresh error = %v", err)
+	}
+	if n := srv.hits.Load(); n != 2 {
+		t.Errorf("jwks hits = %d, want 2", n)
+	}
+}
+
+func TestJWKSCache_CacheControlMaxAge(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name         string
+		cacheControl string
+		want         time.Duration
+	}{
+		{"max-age", "public, max-age=600, must-revalidate", 600 * time.Second},
+		{"no header", "", 2 * time.Hour},
+		{"invalid max-age", "max-age=abc", 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newJWKSServer(t, "k1")
+			srv.set(func(s *jwksServer) { s.cacheControl = tt.cacheControl })
+			cache := newJWKSCache(srv.URL, 2*time.Hour, srv.Client())
+
+			before := time.Now()
+			if _, err := cache.key(ctx, "k1"); err != nil {
+				t.Fatalf("key() error = %v", err)
+			}
+			if got := cache.expiresAt.Sub(before); got < tt.want || got > tt.want+time.Minute {
+				t.Errorf("expires in %s, want %s", got, tt.want)
+			}
+
+			// 过期前不再拉取
+			if _, err := cache.key(ctx, "k1"); err != nil {
+				t.Fatalf("key() error = %v", err)
+			}
+			if n := srv.hits.Load(); n != 1 {
+				t.Errorf("jwks hits = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestJWKSCache_StaleOnError(t *testing.T) {
+	ctx := context.Background()
+	srv := newJWKSServer(t, "k1")
+	p := newTestOIDCProvider(t, &conf.Data_OidcProvider{Name: "google", ClientIds: []string{"client-id"}, JwksUrl: srv.URL})
+	if _, err := p.VerifyIDToken(ctx, srv.sign(t, "k1", googleClaims())); err != nil {
+		t.Fatalf("VerifyIDToken() error = %v", err)
+	}
+
+	// 提供方故障时继续使用已过期的公钥
+	srv.set(func(s *jwksServer) { s.fail = true })
+	expire(p.keys)
+	if _, err := p.VerifyIDToken(ctx, srv.sign(t, "k1", googleClaims())); err != nil {
+		t.Fatalf("VerifyIDToken() with stale key error = %v", err)
+	}
+	if n := srv.hits.Load(); n != 2 {
+		t.Errorf("jwks hits = %d, want 2", n)
+	}
+
+	// 没有任何可用公钥时返回可重试的错误, 而不是凭证无效
+	fresh := newTestOIDCProvider(t, &conf.Data_OidcProvider{Name: "google", ClientIds: []string{"client-id"}, JwksUrl: srv.URL})
+	if _, err := fresh.VerifyIDToken(ctx, srv.sign(t, "k1", googleClaims())); !errors.Is(err, biz.ErrVerificationUnavailable) {
+		t.Errorf("VerifyIDToken() without keys error = %v, want ErrVerificationUnavailable", err)
+	}
+}
+
+func TestJWKSCache_ConcurrentFetch(t *testing.T) {
+	srv := newJWKSServer(t, "k1")
+	block := make(chan struct{})
+	srv.set(func(s *jwksServer) { s.block = block })
+	cache := newJWKSCache(srv.URL, time.Hour, srv.Client())
+
+	// 第一个调用方在拉取期间取消, 其他调用方共用同一次拉取且不受影响
+	firstCtx, cancel := context.WithCancel(context.Background())
+	const callers = 8
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		ctx := context.Background()
+		if i == 0 {
+			ctx = firstCtx
+		}
+		wg.Add(1)
+		go func(i int, ctx context.Context) {
+			defer wg.Done()
+			_, errs[i] = cache.key(ctx, "k1")
+		}(i, ctx)
+		if i == 0 {
+			waitFor(t, func() bool { return srv.hits.Load() == 1 })
+		}
+	}
+
+	// 拉取期间锁已释放, 其他 kid 的查询不会被阻塞
+	if _, ok, _ := cache.lookup("other", time.Now()); ok {
+		t.Fatal("lookup() found an unexpected key")
+	}
+
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	close(block)
+	wg.Wait()
+
+	if !errors.Is(errs[0], biz.ErrVerificationUnavailable) {
+		t.Errorf("cancelled caller error = %v, want ErrVerificationUnavailable", errs[0])
+	}
+	for i := 1; i < callers; i++ {
+		if errs[i] != nil {
+			t.Errorf("caller %d error = %v", i, errs[i])
+		}
+	}
+	if n := srv.hits.Load(); n != 1 {
+		t.Errorf("jwks hits = %d, want 1", n)
+	}
+}
+
+func TestOIDCProvider_EmailVerified(t *testing.T) {
+	ctx := context.Background()
+	srv := newJWKSServer(t, "k1")
+	p := newTestOIDCProvider(t, &conf.Data_OidcProvider{Name: "google", ClientIds: []string{"client-id"}, JwksUrl: srv.URL})
+
+	tests := []struct {
+		name     string
+		verified interface{} // nil 表示不带该声明
+		wantErr  bool
+	}{
+		{"bool true", true, false},
+		{"bool false", false, true},
+		{"string true", "true", false},
+		{"string false", "false", true},
+		{"garbage string", "yes please", true},
+		{"missing", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := googleClaims()
+			if tt.verified == nil {
+				delete(claims, "email_verified")
+			} else {
+				claims["email_verified"] = tt.verified
+			}
+			ext, err := p.VerifyIDToken(ctx, srv.sign(t, "k1", claims))
+			if tt.wantErr {
+				if !errors.Is(err, biz.ErrInvalidIDToken) {
+					t.Errorf("VerifyIDToken() error = %v, want ErrInvalidIDToken", err)
+				}
+				return
+			}
+			if err != nil || !ext.EmailVerified || ext.Email != "alice@example.com" {
+				t.Errorf("VerifyIDToken() = %+v, %v", ext, err)
+			}
+		})
+	}
+}
+
+func appleClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            "https://appleid.apple.com",
+		"aud":            []string{"web-client", "ios-client"},
+		"sub":            "001234.apple",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email":          "relay@privaterelay.appleid.com",
+		"email_verified": "true", // Apple 以字符串表示
+	}
+}
+
+func TestOIDCProvider_ClaimMapping(t *testing.T) {
+	ctx := context.Background()
+	srv := newJWKSServer(t, "k1")
+	reg, err := NewProviderRegistry(&conf.Data{OidcProviders: []*conf.Data_OidcProvider{
+		{Name: "google", ClientIds: []string{"client-id"}, JwksUrl: srv.URL},
+		{
+			Name:      "apple",
+			Issuers:   []string{"https://appleid.apple.com"},
+			ClientIds: []string{"ios-client"},
+			JwksUrl:   srv.URL,
+		},
+		{
+			Name:          "corp",
+			Issuers:       []string{"https://sso.example.com"},
+			ClientIds:     []string{"corp-client"},
+			JwksUrl:       srv.URL,
+			EmailOptional: true,
+			Claims: &conf.Data_OidcProvider_ClaimMapping{
+				Subject:       "employee_id",
+				Email:         "mail",
+				EmailVerified: "mail_verified",
+				Name:          "display_name",
+				Picture:       "avatar_url",
+			},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("NewProviderRegistry() error = %v", err)
+	}
+
+	t.Run("google standard claims", func(t *testing.T) {
+		claims := googleClaims()
+		claims["name"] = "Alice"
+		claims["picture"] = "https://example.com/a.png"
+		ext, err := reg.Verify(ctx, "google", srv.sign(t, "k1", claims))
+		if err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+		want := biz.ExternalIdentity{Subject: "google-sub", Email: "alice@example.com", EmailVerified: true,
+			Name: "Alice", Picture: "https://example.com/a.png"}
+		if *ext != want {
+			t.Errorf("Verify() = %+v, want %+v", *ext, want)
+		}
+	})
+
+	t.Run("apple string email_verified and audience list", func(t *testing.T) {
+		ext, err := reg.Verify(ctx, "apple", srv.sign(t, "k1", appleClaims()))
+		if err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+		if ext.Subject != "001234.apple" || ext.Email != "relay@privaterelay.appleid.com" || !ext.EmailVerified {
+			t.Errorf("Verify() = %+v", ext)
+		}
+
+		claims := appleClaims()
+		claims["email_verified"] = "false"
+		if _, err := reg.Verify(ctx, "apple", srv.sign(t, "k1", claims)); !errors.Is(err, biz.ErrInvalidIDToken) {
+			t.Errorf("Verify(email_verified=\"false\") error = %v, want ErrInvalidIDToken", err)
+		}
+	})
+
+	t.Run("apple rejects google issuer", func(t *testing.T) {
+		claims := googleClaims()
+		claims["aud"] = "ios-client"
+		if _, err := reg.Verify(ctx, "apple", srv.sign(t, "k1", claims)); !errors.Is(err, biz.ErrInvalidIDToken) {
+			t.Errorf("Verify() error = %v, want ErrInvalidIDToken", err)
+		}
+	})
+
+	t.Run("custom claim names", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"iss":           "https://sso.example.com",
+			"aud":           "corp-client",
+			"exp":           time.Now().Add(time.Hour).Unix(),
+			"employee_id":   "e-42",
+			"mail":          "bob@example.com",
+			"mail_verified": true,
+			"display_name":  "Bob",
+			"avatar_url":    "https://example.com/b.png",
+			// 标准声明名不再生效
+			"sub":   "ignored",
+			"email": "ignored@example.com",
+		}
+		ext, err := reg.Verify(ctx, "corp", srv.sign(t, "k1", claims))
+		if err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+		want := biz.ExternalIdentity{Subject: "e-42", Email: "bob@example.com", EmailVerified: true,
+			Name: "Bob", Picture: "https://example.com/b.png"}
+		if *ext != want {
+			t.Errorf("Verify() = %+v, want %+v", *ext, want)
+		}
+
+		// 映射后的主体声明缺失时拒绝, 即使带了标准的 sub
+		delete(claims, "employee_id")
+		if _, err := reg.Verify(ctx, "corp", srv.sign(t, "k1", claims)); !errors.Is(err, biz.ErrInvalidIDToken) {
+			t.Errorf("Verify(no subject) error = %v, want ErrInvalidIDToken", err)
+		}
+	})
+
+	t.Run("email optional", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"iss":         "https://sso.example.com",
+			"aud":         "corp-client",
+			"exp":         time.Now().Add(time.Hour).Unix(),
+			"employee_id": "e-43",
+		}
+		ext, err := reg.Verify(ctx, "corp", srv.sign(t, "k1", claims))
+		if err != nil || ext.Email != "" {
+			t.Fatalf("Verify() = %+v, %v", ext, err)
+		}
+		// 没有邮箱的 google 账号不被接受
+		claims = googleClaims()
+		delete(claims, "email")
+		delete(claims, "email_verified")
+		if _, err := reg.Verify(ctx, "google", srv.sign(t, "k1", claims)); !errors.Is(err, biz.ErrInvalidIDToken) {
+			t.Errorf("Verify(google without email) error = %v, want ErrInvalidIDToken", err)
+		}
+	})
+
+	t.Run("standard validation", func(t *testing.T) {
+		for name, mutate := range map[string]func(jwt.MapClaims){
+			"wrong audience": func(c jwt.MapClaims) { c["aud"] = "other" },
+			"wrong issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+			"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+			"missing exp":    func(c jwt.MapClaims) { delete(c, "exp") },
+		} {
+			claims := googleClaims()
+			mutate(claims)
+			if _, err := reg.Verify(ctx, "google", srv.sign(t, "k1", claims)); !errors.Is(err, biz.ErrInvalidIDToken) {
+				t.Errorf("%s: Verify() error = %v, want ErrInvalidIDToken", name, err)
+			}
+		}
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		if _, err := reg.Verify(ctx, "github", srv.sign(t, "k1", googleClaims())); !errors.Is(err, biz.ErrUnknownProvider) {
+			t.Errorf("Verify() error = %v, want ErrUnknownProvider", err)
+		}
+	})
+}
+
+func TestNewProviderRegistry_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers []*conf.Data_OidcProvider
+	}{
+		{"builtin name", []*conf.Data_OidcProvider{{Name: biz.AuthTypePhone, ClientIds: []string{"a"}, JwksUrl: "u", Issuers: []string{"i"}}}},
+		{"missing issuers", []*conf.Data_OidcProvider{{Name: "apple", ClientIds: []string{"a"}, JwksUrl: "u"}}},
+		{"missing client ids", []*conf.Data_OidcProvider{{Name: "apple", JwksUrl: "u", Issuers: []string{"i"}}}},
+		{"missing jwks url", []*conf.Data_OidcProvider{{Name: "apple", ClientIds: []string{"a"}, Issuers: []string{"i"}}}},
+		{"duplicate", []*conf.Data_OidcProvider{
+			{Name: "google", ClientIds: []string{"a"}},
+			{Name: "google", ClientIds: []string{"b"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewProviderRegistry(&conf.Data{OidcProviders: tt.providers}); err == nil {
+				t.Error("NewProviderRegistry() error = nil")
+			}
+		})
+	}
+}
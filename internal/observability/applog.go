@@ -1,12 +1,16 @@
 package observability
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	takin_adapter "github.com/YangZhaoWeblog/GoldenTakin/takin_log/adapter"
 	"github.com/go-kratos/kratos/v2/log"
 
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
 	"github.com/YangZhaoWeblog/UserService/internal/conf"
 
 	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
@@ -41,10 +45,47 @@ func NewAppLogger(confLog *conf.Log, confApp *conf.App) (*takin_log.TakinLogger,
 	return applogger, cleanUp
 }
 
-// // 初始化全局日志器
-func InitGlobalLogger(takinLogger *takin_log.TakinLogger) log.Logger {
+// InitGlobalLogger 初始化全局日志器
+// error 及以上级别的日志同时交给 sink 落盘, 框架与 log.Helper 输出的错误也能在库里查到
+func InitGlobalLogger(takinLogger *takin_log.TakinLogger, sink *AppLogSink) log.Logger {
 	// 创建适配器并设置为全局日志器
-	adapter := takin_adapter.NewKratosAdapter(takinLogger)
-	log.SetLogger(adapter)
-	return adapter
+	var logger log.Logger = takin_adapter.NewKratosAdapter(takinLogger)
+	logger = &sinkLogger{Logger: logger, sink: sink}
+	log.SetLogger(logger)
+	return logger
+}
+
+// sinkLogger 在原日志器之外把 error 级别的日志写入 AppLogSink
+type sinkLogger struct {
+	log.Logger
+	sink *AppLogSink
+}
+
+func (l *sinkLogger) Log(level log.Level, keyvals ...interface{}) error {
+	err := l.Logger.Log(level, keyvals...)
+	if level >= log.LevelError {
+		l.sink.Write(context.Background(), appLogFromKV(level, keyvals))
+	}
+	return err
+}
+
+// appLogFromKV 把 kratos 的键值对转成 AppLog, msg 之外的键放进 Extra
+func appLogFromKV(level log.Level, keyvals []interface{}) *biz.AppLog {
+	l := &biz.AppLog{
+		Time:  time.Now(),
+		Level: strings.ToLower(level.String()),
+		Kind:  "log",
+	}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		if key == log.DefaultMessageKey {
+			l.Msg = fmt.Sprint(keyvals[i+1])
+			continue
+		}
+		if l.Extra == nil {
+			l.Extra = make(map[string]any)
+		}
+		l.Extra[key] = fmt.Sprint(keyvals[i+1])
+	}
+	return l
 }
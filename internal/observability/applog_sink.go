@@ -0,0 +1,193 @@
+package observability
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+)
+
+const (
+	defaultAppLogBufferSize    = 4096
+	defaultAppLogBatchSize     = 100
+	defaultAppLogFlushInterval = time.Second
+	appLogWriteTimeout         = 5 * time.Second
+)
+
+// AppLogSink 把 error 级别的日志异步、批量写入 AppLog 表
+// Loki 中的日志轮转后，仍可以按 trace_id 在库里查到失败请求
+// 缓冲区写满时直接丢弃并计数，绝不阻塞请求
+// 审计事件也经由它落盘, level 为 audit
+type AppLogSink struct {
+	repo          biz.AppLogRepo
+	appName       string
+	batchSize     int
+	flushInterval time.Duration
+
+	ch      chan *biz.AppLog
+	done    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+	dropped atomic.Int64
+
+	droppedCounter metric.Int64Counter
+	logHelper      *takin_log.TakinLogger
+}
+
+// NewAppLogSink 创建错误日志落盘器，未开启时返回的 sink 丢弃所有写入
+func NewAppLogSink(confLog *conf.Log, confApp *conf.App, repo biz.AppLogRepo,
+	metricsData *MetricsData, logHelper *takin_log.TakinLogger,
+) (*AppLogSink, func()) {
+	persist := confLog.GetPersist()
+	if !persist.GetEnabled() {
+		return &AppLogSink{}, func() {}
+	}
+
+	s := &AppLogSink{
+		repo:           repo,
+		appName:        confApp.GetAppName(),
+		batchSize:      int(persist.GetBatchSize()),
+		flushInterval:  persist.GetFlushInterval().AsDuration(),
+		ch:             make(chan *biz.AppLog, bufferSize(persist.GetBufferSize())),
+		done:           make(chan struct{}),
+		droppedCounter: metricsData.AppLogDropped,
+		logHelper:      logHelper,
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = defaultAppLogBatchSize
+	}
+	if s.flushInterval <= 0 {
+		s.flushInterval = defaultAppLogFlushInterval
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s, s.Close
+}
+
+func bufferSize(n int32) int {
+	if n <= 0 {
+		return defaultAppLogBufferSize
+	}
+	return int(n)
+}
+
+// Write 非阻塞地提交一条错误日志，trace_id/span_id 从 ctx 中补齐
+func (s *AppLogSink) Write(ctx context.Context, l *biz.AppLog) {
+	if s == nil || s.ch == nil {
+		return
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		l.TraceID = sc.TraceID().String()
+		l.SpanID = sc.SpanID().String()
+	}
+	if l.AppName == "" {
+		l.AppName = s.appName
+	}
+	if l.Time.IsZero() {
+		l.Time = time.Now()
+	}
+
+	// 先单独判断是否已关闭: 与写入放在同一个 select 里时两者随机命中, 关闭后的日志可能进入缓冲区而不再被写出
+	select {
+	case <-s.done:
+		s.drop(1, "closed")
+		return
+	default:
+	}
+	select {
+	case s.ch <- l:
+	default:
+		s.drop(1, "buffer_full")
+	}
+}
+
+// Dropped 返回累计丢弃的条数
+func (s *AppLogSink) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close 停止接收新日志，把缓冲区中剩余的日志写完后返回
+func (s *AppLogSink) Close() {
+	if s == nil || s.ch == nil {
+		return
+	}
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+		if n := s.Dropped(); n > 0 {
+			s.logHelper.Info("applog sink closed", "dropped", n)
+		}
+	})
+}
+
+func (s *AppLogSink) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*biz.AppLog, 0, s.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		s.flush(batch)
+		batch = make([]*biz.AppLog, 0, s.batchSize)
+	}
+
+	for {
+		select {
+		case l := <-s.ch:
+			batch = append(batch, l)
+			if len(batch) >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-s.done:
+			// 退出前把缓冲区排空
+			for {
+				select {
+				case l := <-s.ch:
+					batch = append(batch, l)
+					if len(batch) >= s.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *AppLogSink) flush(batch []*biz.AppLog) {
+	ctx, cancel := context.WithTimeout(context.Background(), appLogWriteTimeout)
+	defer cancel()
+
+	if err := s.repo.BatchSave(ctx, batch); err != nil {
+		s.drop(len(batch), "write_failed")
+		// sink 只由日志中间件写入，这里记普通日志不会回流
+		s.logHelper.ErrorContext(ctx, "persist applog failed", "count", len(batch), "err", err)
+	}
+}
+
+func (s *AppLogSink) drop(n int, reason string) {
+	s.dropped.Add(int64(n))
+	if s.droppedCounter != nil {
+		s.droppedCounter.Add(context.Background(), int64(n),
+			metric.WithAttributes(attribute.String("reason", reason)))
+	}
+}
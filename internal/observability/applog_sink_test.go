@@ -0,0 +1,196 @@
+package observability
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+)
+
+// batchRecorder 记录写入的日志与每批的条数, gate 不为空时每批写入前等待放行
+type batchRecorder struct {
+	biz.AppLogRepo
+	mu      sync.Mutex
+	logs    []*biz.AppLog
+	batches []int
+	gate    chan struct{}
+	err     error
+}
+
+func (r *batchRecorder) BatchSave(_ context.Context, logs []*biz.AppLog) error {
+	if r.gate != nil {
+		<-r.gate
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs = append(r.logs, logs...)
+	r.batches = append(r.batches, len(logs))
+	return r.err
+}
+
+func (r *batchRecorder) snapshot() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.batches...)
+}
+
+func (r *batchRecorder) total() int {
+	n := 0
+	for _, b := range r.snapshot() {
+		n += b
+	}
+	return n
+}
+
+func newTestSink(t *testing.T, persist *conf.Log_Persist, repo biz.AppLogRepo) (*AppLogSink, *sdkmetric.ManualReader) {
+	t.Helper()
+	reader := sdkmetric.NewManualReader()
+	dropped, err := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test").Int64Counter("applog_dropped")
+	if err != nil {
+		t.Fatalf("Int64Counter() error = %v", err)
+	}
+	persist.Enabled = true
+	sink, cleanup := NewAppLogSink(&conf.Log{Persist: persist}, &conf.App{AppName: "user"}, repo,
+		&MetricsData{AppLogDropped: dropped}, takin_log.NewTakinLogger(takin_log.TakinLoggerOptions{}))
+	t.Cleanup(cleanup)
+	return sink, reader
+}
+
+// droppedByReason 读取 applog_dropped 指标中各 reason 的累计值
+func droppedByReason(t *testing.T, reader *sdkmetric.ManualReader) map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	got := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok {
+				continue
+			}
+			for _, dp := range sum.DataPoints {
+				reason, _ := dp.Attributes.Value(attribute.Key("reason"))
+				got[reason.AsString()] += dp.Value
+			}
+		}
+	}
+	return got
+}
+
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAppLogSink_FlushesFullBatches(t *testing.T) {
+	repo := &batchRecorder{}
+	sink, _ := newTestSink(t, &conf.Log_Persist{BatchSize: 3, FlushInterval: durationpb.New(time.Hour)}, repo)
+
+	for i := 0; i < 7; i++ {
+		sink.Write(context.Background(), &biz.AppLog{Msg: "boom"})
+	}
+	// 攒满的两批立即写入, 剩下的一条等待定时器或 Close
+	waitUntil(t, func() bool { return repo.total() == 6 })
+	if got := repo.snapshot(); len(got) != 2 || got[0] != 3 || got[1] != 3 {
+		t.Fatalf("batches = %v, want [3 3]", got)
+	}
+}
+
+func TestAppLogSink_FlushesOnInterval(t *testing.T) {
+	repo := &batchRecorder{}
+	sink, _ := newTestSink(t, &conf.Log_Persist{BatchSize: 100, FlushInterval: durationpb.New(10 * time.Millisecond)}, repo)
+
+	sink.Write(context.Background(), &biz.AppLog{Msg: "boom"})
+	waitUntil(t, func() bool { return repo.total() == 1 })
+}
+
+func TestAppLogSink_CloseDrainsBuffer(t *testing.T) {
+	repo := &batchRecorder{}
+	sink, reader := newTestSink(t, &conf.Log_Persist{BatchSize: 4, FlushInterval: durationpb.New(time.Hour)}, repo)
+
+	for i := 0; i < 10; i++ {
+		sink.Write(context.Background(), &biz.AppLog{Msg: "boom"})
+	}
+	sink.Close()
+	if got := repo.total(); got != 10 {
+		t.Fatalf("persisted %d logs after Close, want 10", got)
+	}
+
+	// 关闭之后的写入直接丢弃, 重复 Close 不阻塞
+	sink.Write(context.Background(), &biz.AppLog{Msg: "late"})
+	sink.Close()
+	if got := droppedByReason(t, reader); got["closed"] != 1 || sink.Dropped() != 1 {
+		t.Errorf("dropped = %v (total %d), want closed=1", got, sink.Dropped())
+	}
+}
+
+func TestAppLogSink_DropsWhenBufferFull(t *testing.T) {
+	repo := &batchRecorder{gate: make(chan struct{})}
+	sink, reader := newTestSink(t, &conf.Log_Persist{BufferSize: 2, BatchSize: 1, FlushInterval: durationpb.New(time.Hour)}, repo)
+
+	// 第一条被取出后卡在写库上, 之后缓冲区最多容纳两条
+	sink.Write(context.Background(), &biz.AppLog{Msg: "blocked"})
+	waitUntil(t, func() bool { return len(sink.ch) == 0 })
+	for i := 0; i < 5; i++ {
+		sink.Write(context.Background(), &biz.AppLog{Msg: "boom"})
+	}
+	if got := droppedByReason(t, reader); got["buffer_full"] != 3 {
+		t.Errorf("dropped = %v, want buffer_full=3", got)
+	}
+
+	close(repo.gate)
+	sink.Close()
+	if got := repo.total(); got != 3 {
+		t.Errorf("persisted %d logs, want 3", got)
+	}
+}
+
+func TestAppLogSink_CountsWriteFailures(t *testing.T) {
+	repo := &batchRecorder{err: errors.New("db down")}
+	sink, reader := newTestSink(t, &conf.Log_Persist{BatchSize: 2, FlushInterval: durationpb.New(time.Hour)}, repo)
+
+	for i := 0; i < 3; i++ {
+		sink.Write(context.Background(), &biz.AppLog{Msg: "boom"})
+	}
+	sink.Close()
+	if got := droppedByReason(t, reader); got["write_failed"] != 3 || sink.Dropped() != 3 {
+		t.Errorf("dropped = %v (total %d), want write_failed=3", got, sink.Dropped())
+	}
+}
+
+func TestAppLogSink_FillsDefaults(t *testing.T) {
+	repo := &batchRecorder{}
+	sink, _ := newTestSink(t, &conf.Log_Persist{BatchSize: 1}, repo)
+
+	sink.Write(context.Background(), &biz.AppLog{Msg: "boom"})
+	sink.Close()
+	if len(repo.logs) != 1 || repo.logs[0].AppName != "user" || repo.logs[0].Time.IsZero() {
+		t.Fatalf("saved = %+v, want app name and time filled", repo.logs)
+	}
+
+	// 未开启落盘时写入与关闭都是空操作
+	disabled, cleanup := NewAppLogSink(&conf.Log{}, &conf.App{}, repo, &MetricsData{}, nil)
+	disabled.Write(context.Background(), &biz.AppLog{})
+	cleanup()
+	if disabled.Dropped() != 0 || len(repo.logs) != 1 {
+		t.Errorf("disabled sink persisted or dropped logs")
+	}
+}
@@ -0,0 +1,56 @@
+package observability
+
+import (
+	"context"
+	"sort"
+
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	"github.com/go-kratos/kratos/v2/transport"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+)
+
+// AuditLevel 是审计事件在 AppLog 表中的 level, 可以按 level 查询与设置保留策略
+const AuditLevel = "audit"
+
+// auditor 把审计事件同时写入日志与 AppLog 表
+type auditor struct {
+	sink      *AppLogSink
+	logHelper *takin_log.TakinLogger
+}
+
+// NewAuditor 创建审计记录器
+func NewAuditor(sink *AppLogSink, logHelper *takin_log.TakinLogger) biz.Auditor {
+	return &auditor{
+		sink:      sink,
+		logHelper: logHelper,
+	}
+}
+
+// Audit 记录一条审计事件
+func (a *auditor) Audit(ctx context.Context, e *biz.AuditEvent) {
+	var operation string
+	if tr, ok := transport.FromServerContext(ctx); ok {
+		operation = tr.Operation()
+	}
+
+	kv := []any{"kind", AuditLevel, "action", e.Action, "user_id", e.UserID, "operation", operation}
+	keys := make([]string, 0, len(e.Details))
+	for k := range e.Details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		kv = append(kv, k, e.Details[k])
+	}
+	a.logHelper.InfoContext(ctx, "audit", kv...)
+
+	a.sink.Write(ctx, &biz.AppLog{
+		Level:     AuditLevel,
+		Kind:      AuditLevel,
+		Msg:       e.Action,
+		Operation: operation,
+		UserID:    e.UserID,
+		Extra:     e.Details,
+	})
+}
@@ -12,6 +12,11 @@ import (
 type MetricsData struct {
 	Seconds  metric.Float64Histogram
 	Requests metric.Int64Counter
+
+	AppLogDropped metric.Int64Counter // 错误日志落盘时被丢弃的条数
+	SmsBlocked    metric.Int64Counter // 被频率限制或熔断拦下的验证码发送次数
+
+	DenylistSyncFailed metric.Int64Counter // 令牌黑名单同步失败次数, 期间其他副本的吊销可能没有生效
 }
 
 // 为什么高版本Kratos要用OpenTelemetry？
@@ -52,6 +57,33 @@ func NewMetrics(app *conf.App) (*MetricsData, error) {
 		return nil, err
 	}
 
+	// 业务指标: 错误日志落盘丢弃数，缓冲区写满或写库失败时累加
+	appLogDropped, err := meter.Int64Counter("applog_dropped",
+		metric.WithDescription("The number of error logs dropped before persisted to the AppLog table"),
+		metric.WithUnit("{log}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// 业务指标: 验证码发送被拦截次数，按 reason(触发的限制) 与 scope(phone/ip/device/global) 区分
+	smsBlocked, err := meter.Int64Counter("sms_send_blocked",
+		metric.WithDescription("The number of verification code sends blocked by rate limits or the circuit breaker"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// 业务指标: 令牌黑名单同步失败次数，按 stage(receive 接收广播 / reload 重连后全量加载) 区分
+	denylistSyncFailed, err := meter.Int64Counter("token_denylist_sync_failed",
+		metric.WithDescription("The number of failures receiving or reloading token revocations from other replicas"),
+		metric.WithUnit("{error}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	// 通过上述配置，已经启用了完整的指标收集系统
 	// 除了这两个核心HTTP/gRPC指标外，还会自动收集Go运行时指标(GC、内存、goroutine等)
 	// 其他添加业务指标，可以使用meter创建额外的计数器、仪表盘或直方图
@@ -59,5 +91,10 @@ func NewMetrics(app *conf.App) (*MetricsData, error) {
 	return &MetricsData{
 		Seconds:  seconds,
 		Requests: requests,
+
+		AppLogDropped: appLogDropped,
+		SmsBlocked:    smsBlocked,
+
+		DenylistSyncFailed: denylistSyncFailed,
 	}, nil
 }
@@ -6,7 +6,7 @@ import "github.com/google/wire"
 
 //var ProviderSet = wire.NewSet(NewAppLogger, InitGlobalLogger)
 
-// 将 NewAppLogger、InitGlobalLogger、NewMetrics、NewTracerProvider, 全部串到一个 ProviderSet 中，并且合并它们的 cleanup
+// 将 NewAppLogger、InitGlobalLogger、NewMetrics、NewTracerProvider、NewAppLogSink, 全部串到一个 ProviderSet 中，并且合并它们的 cleanup
 var ProviderSet = wire.NewSet(
 	// 1. 日志, 被 kratos log 所依赖，所以无需被显式使用
 	NewAppLogger,
@@ -17,4 +17,10 @@ var ProviderSet = wire.NewSet(
 
 	// 3. 追踪
 	NewTracerProvider,
+
+	// 4. 错误日志落盘
+	NewAppLogSink,
+
+	// 5. 审计事件
+	NewAuditor,
 )
@@ -0,0 +1,146 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"google.golang.org/protobuf/proto"
+)
+
+// scriptedClock 依次返回 values 中的毫秒数, 用完后一直返回最后一个
+func scriptedClock(values ...int64) func() int64 {
+	i := 0
+	return func() int64 {
+		v := values[i]
+		if i < len(values)-1 {
+			i++
+		}
+		return v
+	}
+}
+
+func decodeID(id int64) (ms, workerID, sequence int64) {
+	return id>>timestampShift + idEpoch, id >> workerIDShift & MaxWorkerID, id & maxSequence
+}
+
+func newTestIDGenerator(t *testing.T, workerID uint32, now func() int64) *IDGenerator {
+	t.Helper()
+	g, err := NewIDGenerator(&conf.App{WorkerId: proto.Uint32(workerID)})
+	if err != nil {
+		t.Fatalf("NewIDGenerator() error = %v", err)
+	}
+	g.now = now
+	return g
+}
+
+func TestNewIDGenerator_WorkerID(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    *conf.App
+		wantErr bool
+	}{
+		{"unset outside dev", &conf.App{}, true},
+		{"unset in prod", &conf.App{Env: "prod"}, true},
+		{"unset in dev", &conf.App{Env: conf.EnvDev}, false},
+		{"explicit zero", &conf.App{WorkerId: proto.Uint32(0)}, false},
+		{"max", &conf.App{WorkerId: proto.Uint32(MaxWorkerID)}, false},
+		{"out of range", &conf.App{WorkerId: proto.Uint32(MaxWorkerID + 1), Env: conf.EnvDev}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewIDGenerator(tt.conf)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewIDGenerator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIDGenerator_UniqueAndIncreasing(t *testing.T) {
+	g, err := NewIDGenerator(&conf.App{WorkerId: proto.Uint32(7)})
+	if err != nil {
+		t.Fatalf("NewIDGenerator() error = %v", err)
+	}
+	var last int64
+	for i := 0; i < 20000; i++ {
+		id, err := g.NextID()
+		if err != nil {
+			t.Fatalf("NextID() error = %v", err)
+		}
+		if id <= last {
+			t.Fatalf("NextID() = %d, not greater than previous %d", id, last)
+		}
+		if _, worker, _ := decodeID(id); worker != 7 {
+			t.Fatalf("worker id = %d, want 7", worker)
+		}
+		last = id
+	}
+}
+
+func TestIDGenerator_SequenceOverflow(t *testing.T) {
+	const base = int64(1_750_000_000_000)
+	// 同一毫秒内发满 maxSequence+1 个号, 下一次发号时时钟才前进
+	ticks := make([]int64, maxSequence+2)
+	for i := range ticks {
+		ticks[i] = base
+	}
+	g := newTestIDGenerator(t, 1, scriptedClock(append(ticks, base+1)...))
+
+	var last int64
+	for i := 0; i <= maxSequence; i++ {
+		id, err := g.NextID()
+		if err != nil {
+			t.Fatalf("NextID() error = %v", err)
+		}
+		ms, _, seq := decodeID(id)
+		if ms != base || seq != int64(i) {
+			t.Fatalf("id %d = (%d, seq %d), want (%d, seq %d)", i, ms, seq, base, i)
+		}
+		last = id
+	}
+
+	id, err := g.NextID()
+	if err != nil {
+		t.Fatalf("NextID() error = %v", err)
+	}
+	ms, _, seq := decodeID(id)
+	if ms != base+1 || seq != 0 {
+		t.Errorf("after overflow = (%d, seq %d), want (%d, seq 0)", ms, seq, base+1)
+	}
+	if id <= last {
+		t.Errorf("after overflow id %d not greater than %d", id, last)
+	}
+}
+
+func TestIDGenerator_ClockRollback(t *testing.T) {
+	const base = int64(1_750_000_000_000)
+
+	t.Run("small rollback waits", func(t *testing.T) {
+		// 第一次发号在 base+5, 之后时钟回拨 5ms, 等待期间逐步追上
+		g := newTestIDGenerator(t, 1, scriptedClock(base+5, base, base+3, base+5))
+		first, err := g.NextID()
+		if err != nil {
+			t.Fatalf("NextID() error = %v", err)
+		}
+		second, err := g.NextID()
+		if err != nil {
+			t.Fatalf("NextID() after small rollback error = %v", err)
+		}
+		ms, _, seq := decodeID(second)
+		if ms != base+5 || seq != 1 || second <= first {
+			t.Errorf("after rollback = (%d, seq %d), want (%d, seq 1)", ms, seq, base+5)
+		}
+	})
+
+	t.Run("large rollback fails", func(t *testing.T) {
+		backward := maxClockBackward.Milliseconds() + 1
+		g := newTestIDGenerator(t, 1, scriptedClock(base, base-backward))
+		if _, err := g.NextID(); err != nil {
+			t.Fatalf("NextID() error = %v", err)
+		}
+		if _, err := g.NextID(); !errors.Is(err, ErrClockMovedBackwards) {
+			t.Errorf("NextID() error = %v, want ErrClockMovedBackwards", err)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// minRSABits 低于 2048 位的 RSA 密钥不安全, 启动时拒绝
+const minRSABits = 2048
+
+// signingKey 是一把非对称签名密钥
+type signingKey struct {
+	kid        string
+	method     jwt.SigningMethod
+	private    crypto.Signer
+	public     crypto.PublicKey
+	activeFrom time.Time
+}
+
+// loadSigningKeys 从磁盘加载签名密钥, 按启用时间升序返回
+func loadSigningKeys(cfgs []*conf.Data_Jwt_Key) ([]*signingKey, error) {
+	keys := make([]*signingKey, 0, len(cfgs))
+	seen := make(map[string]bool, len(cfgs))
+	for _, kc := range cfgs {
+		kid := kc.GetKid()
+		if kid == "" {
+			return nil, fmt.Errorf("jwt key %q: kid is required", kc.GetPrivateKeyFile())
+		}
+		if seen[kid] {
+			return nil, fmt.Errorf("jwt key %q: duplicate kid", kid)
+		}
+		seen[kid] = true
+
+		b, err := os.ReadFile(kc.GetPrivateKeyFile())
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", kid, err)
+		}
+		private, method, err := parsePrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", kid, err)
+		}
+
+		k := &signingKey{kid: kid, method: method, private: private, public: private.Public()}
+		if kc.GetActiveFrom() != nil {
+			k.activeFrom = kc.GetActiveFrom().AsTime()
+		}
+		keys = append(keys, k)
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].activeFrom.Before(keys[j].activeFrom)
+	})
+	return keys, nil
+}
+
+// parsePrivateKey 解析 PEM 格式的私钥, RSA 使用 RS256, Ed25519 使用 EdDSA
+func parsePrivateKey(b []byte) (crypto.Signer, jwt.SigningMethod, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no PEM block found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSABits {
+			return nil, nil, fmt.Errorf("rsa key must be at least %d bits", minRSABits)
+		}
+		return k, jwt.SigningMethodRS256, nil
+	case ed25519.PrivateKey:
+		return k, jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// JSONWebKey 是 RFC 7517 格式的公钥
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet 是 /.well-known/jwks.json 的响应
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS 返回全部签名公钥, 包括尚未启用和已经停用的, 下游服务据此校验令牌
+// HS256 密钥不能公开, 使用 HS256 时返回空集合
+func (c *JwtClient) JWKS() *JSONWebKeySet {
+	set := &JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(c.keys))}
+	for _, k := range c.keys {
+		jwk := JSONWebKey{Kid: k.kid, Use: "sig", Alg: k.method.Alg()}
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -1,77 +1,381 @@
 package pkg
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/YangZhaoWeblog/UserService/internal/conf"
 	"github.com/golang-jwt/jwt/v4" // 使用v4版本
 )
 
+// typ 声明的取值, 区分访问令牌、刷新令牌与邮箱验证令牌, 三者签名密钥相同, 不能互相冒用
+const (
+	TokenTypeAccess            = "access"
+	TokenTypeRefresh           = "refresh"
+	TokenTypeEmailVerification = "email_verification"
+)
+
+// defaultIssuer 未配置 conf.Data.Jwt.issuer 时使用的 iss
+const defaultIssuer = "user-service"
+
+// 解析令牌的错误, 调用方据此返回不同的错误原因
+var (
+	// ErrTokenMalformed 不是合法的 JWT
+	ErrTokenMalformed = errors.New("token malformed")
+	// ErrTokenExpired 令牌已过期
+	ErrTokenExpired = errors.New("token expired")
+	// ErrTokenAudience aud 中没有本服务接受的受众
+	ErrTokenAudience = errors.New("token audience mismatch")
+	// ErrTokenInvalid 签名错误、未到生效时间, 或 iss、typ 等声明不符
+	ErrTokenInvalid = errors.New("token invalid")
+)
+
 type CustomClaims struct {
-	UserID               string `json:"user_id"`
+	UserID               string `json:"user_id"` // 与 sub 相同, 保留给只读 user_id 的调用方
 	Username             string `json:"username"`
+	SessionID            string `json:"sid,omitempty"` // 同时签发的刷新令牌所属的家族, 注销时一并撤销
+	Type                 string `json:"typ"`
+	Scope                string `json:"scope,omitempty"` // 权限范围, 以空格分隔
 	jwt.RegisteredClaims        // 使用RegisteredClaims替代StandardClaims
 }
 
+// Scopes 返回访问令牌的权限范围
+func (c *CustomClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// RefreshClaims 是刷新令牌的声明, sub 为用户 ID, jti 为令牌 ID
+type RefreshClaims struct {
+	SessionID string `json:"sid"` // 令牌家族 ID, 轮换时保持不变
+	Type      string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// EmailClaims 是邮箱验证令牌的声明, sub 为用户 ID, 只发给自己, aud 为 issuer
+type EmailClaims struct {
+	Email string `json:"email"`
+	Type  string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// TokenPair 是一次签发的访问令牌与刷新令牌
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	SessionID    string // 刷新令牌所属的家族
+	RefreshID    string // 刷新令牌的 jti
+}
+
+// errNoSigningKey 所有密钥都未到启用时间, 且没有可用的 HS256 密钥
+var errNoSigningKey = errors.New("jwt: no active signing key")
+
+// errEmptySecret 既没有配置 keys 也没有配置 signing_key, 空密钥签名的令牌任何人都能伪造
+var errEmptySecret = errors.New("jwt: signing_key is empty and no keys are configured")
+
 type JwtClient struct {
-	signingKey  string
-	expiresTime int32
+	// secret 为 HS256 密钥, 配置了非对称密钥且没有 signing_key 时为 nil, 不再接受 HS256 令牌
+	secret             []byte
+	keys               []*signingKey // 按启用时间升序
+	keysByID           map[string]*signingKey
+	validMethods       []string
+	issuer             string
+	audiences          []string // 访问令牌的 aud, 刷新令牌只发给自己, aud 为 issuer
+	expiresTime        int32
+	refreshExpiresTime int32
 }
 
 // NewClient 创建一个新的JWT客户端
-func NewClient(cfg *conf.Data) *JwtClient {
-	return &JwtClient{
-		signingKey:  cfg.Jwt.SigningKey,
-		expiresTime: cfg.Jwt.ExpiresTime,
+// 配置了 keys 时使用非对称密钥签名, 否则沿用 HS256, 此时 signing_key 不能为空
+func NewClient(cfg *conf.Data) (*JwtClient, error) {
+	refresh := cfg.Jwt.RefreshExpiresTime
+	if refresh <= 0 {
+		refresh = cfg.Jwt.ExpiresTime * 7
+	}
+	keys, err := loadSigningKeys(cfg.Jwt.GetKeys())
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 && cfg.Jwt.GetSigningKey() == "" {
+		return nil, errEmptySecret
+	}
+
+	c := &JwtClient{
+		keys:               keys,
+		keysByID:           make(map[string]*signingKey, len(keys)),
+		issuer:             cfg.Jwt.GetIssuer(),
+		audiences:          cfg.Jwt.GetAudiences(),
+		expiresTime:        cfg.Jwt.ExpiresTime,
+		refreshExpiresTime: refresh,
+	}
+	if c.issuer == "" {
+		c.issuer = defaultIssuer
+	}
+	if len(c.audiences) == 0 {
+		c.audiences = []string{c.issuer}
 	}
+	if len(keys) == 0 || cfg.Jwt.SigningKey != "" {
+		c.secret = []byte(cfg.Jwt.SigningKey)
+		c.validMethods = append(c.validMethods, jwt.SigningMethodHS256.Alg())
+	}
+	for _, k := range keys {
+		c.keysByID[k.kid] = k
+		if !containsString(c.validMethods, k.method.Alg()) {
+			c.validMethods = append(c.validMethods, k.method.Alg())
+		}
+	}
+	// 启动时就要能签发令牌, 不要等到第一次登录才发现
+	if _, err := c.signer(time.Now()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ExpiresIn 返回访问令牌的有效期, 单位秒
+func (c *JwtClient) ExpiresIn() int64 {
+	return int64(c.expiresTime)
+}
+
+// RefreshTTL 返回刷新令牌的有效期
+func (c *JwtClient) RefreshTTL() time.Duration {
+	return time.Duration(c.refreshExpiresTime) * time.Second
 }
 
 // GenerateToken 生成访问令牌和刷新令牌
-func (c *JwtClient) GenerateToken(userID int64, username string) (accessToken string, refreshToken string, err error) {
-	// 生成访问令牌
+// sessionID 为空时开启新的令牌家族, 刷新时传入原家族 ID; scopes 只写入访问令牌
+func (c *JwtClient) GenerateToken(userID int64, username, sessionID string, scopes []string) (*TokenPair, error) {
+	var err error
+	if sessionID == "" {
+		if sessionID, err = newTokenID(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 生成访问令牌, jti 用于注销时加入黑名单
 	now := time.Now()
 	expireTime := now.Add(time.Duration(c.expiresTime) * time.Second)
+	accessID, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
 
+	subject := strconv.FormatInt(userID, 10)
 	accessClaims := CustomClaims{
-		UserID:   strconv.FormatInt(userID, 10),
-		Username: username,
+		UserID:    subject,
+		Username:  username,
+		SessionID: sessionID,
+		Type:      TokenTypeAccess,
+		Scope:     strings.Join(scopes, " "),
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    c.issuer,
+			Subject:   subject,
+			Audience:  c.audiences,
+			ID:        accessID,
 			ExpiresAt: jwt.NewNumericDate(expireTime),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessToken, err = token.SignedString([]byte(c.signingKey))
+	accessToken, err := c.sign(accessClaims, now)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	// 生成刷新令牌
-	refreshExpireTime := now.Add(time.Duration(c.expiresTime*7) * time.Second)
-	refreshClaims := jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(refreshExpireTime),
-		IssuedAt:  jwt.NewNumericDate(now),
-		NotBefore: jwt.NewNumericDate(now),
+	refreshID, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	refreshClaims := RefreshClaims{
+		SessionID: sessionID,
+		Type:      TokenTypeRefresh,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    c.issuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{c.issuer},
+			ID:        refreshID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(c.RefreshTTL())),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+		},
 	}
 
-	token = jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshToken, err = token.SignedString([]byte(c.signingKey))
+	refreshToken, err := c.sign(refreshClaims, now)
+	if err != nil {
+		return nil, err
+	}
 
-	return accessToken, refreshToken, err
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		SessionID:    sessionID,
+		RefreshID:    refreshID,
+	}, nil
 }
 
-// ParseToken 解析令牌
+// ParseToken 解析访问令牌, 校验签名、有效期、iss、aud 与 typ
+// sub、jti、iat 与 exp 缺一不可, 失败时返回 ErrToken 开头的错误
 func (c *JwtClient) ParseToken(tokenString string) (*CustomClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(c.signingKey), nil
-	})
+	claims := &CustomClaims{}
+	if err := c.parse(tokenString, claims, c.audiences); err != nil {
+		return nil, err
+	}
+	if claims.Type != TokenTypeAccess || claims.IssuedAt == nil || claims.UserID != claims.Subject {
+		return nil, ErrTokenInvalid
+	}
+	return claims, nil
+}
+
+// ParseRefreshToken 解析刷新令牌, 校验项与 ParseToken 相同, 另外要求 sid
+func (c *JwtClient) ParseRefreshToken(tokenString string) (*RefreshClaims, error) {
+	claims := &RefreshClaims{}
+	if err := c.parse(tokenString, claims, []string{c.issuer}); err != nil {
+		return nil, err
+	}
+	if claims.Type != TokenTypeRefresh || claims.SessionID == "" {
+		return nil, ErrTokenInvalid
+	}
+	return claims, nil
+}
+
+// GenerateEmailToken 生成邮箱验证令牌, 放在验证链接里, ttl 后过期
+func (c *JwtClient) GenerateEmailToken(userID int64, email string, ttl time.Duration) (string, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return c.sign(EmailClaims{
+		Email: email,
+		Type:  TokenTypeEmailVerification,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    c.issuer,
+			Subject:   strconv.FormatInt(userID, 10),
+			Audience:  jwt.ClaimStrings{c.issuer},
+			ID:        id,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}, now)
+}
 
-	if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
-		return claims, nil
+// ParseEmailToken 解析邮箱验证令牌, 校验项与 ParseRefreshToken 相同, 另外要求 email
+func (c *JwtClient) ParseEmailToken(tokenString string) (*EmailClaims, error) {
+	claims := &EmailClaims{}
+	if err := c.parse(tokenString, claims, []string{c.issuer}); err != nil {
+		return nil, err
 	}
+	if claims.Type != TokenTypeEmailVerification || claims.Email == "" {
+		return nil, ErrTokenInvalid
+	}
+	return claims, nil
+}
 
-	return nil, err
+// registered 用于取出各种声明中的 RegisteredClaims
+type registered interface {
+	jwt.Claims
+	registered() *jwt.RegisteredClaims
+}
+
+func (c *CustomClaims) registered() *jwt.RegisteredClaims  { return &c.RegisteredClaims }
+func (c *RefreshClaims) registered() *jwt.RegisteredClaims { return &c.RegisteredClaims }
+func (c *EmailClaims) registered() *jwt.RegisteredClaims   { return &c.RegisteredClaims }
+
+// parse 校验签名与公共声明, aud 中至少有一个在 audiences 里
+func (c *JwtClient) parse(tokenString string, claims registered, audiences []string) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, c.keyFunc, jwt.WithValidMethods(c.validMethods))
+	switch {
+	case err == nil:
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return ErrTokenMalformed
+	// 签名错误的令牌内容不可信, 即使同时过期也不能报告为过期
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return ErrTokenInvalid
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrTokenExpired
+	default:
+		return ErrTokenInvalid
+	}
+
+	rc := claims.registered()
+	if rc.Issuer != c.issuer || rc.Subject == "" || rc.ID == "" || rc.ExpiresAt == nil {
+		return ErrTokenInvalid
+	}
+	for _, aud := range audiences {
+		if rc.VerifyAudience(aud, true) {
+			return nil
+		}
+	}
+	return ErrTokenAudience
+}
+
+// signer 返回 now 时刻用于签名的密钥, 返回 nil 表示使用 HS256
+// 已启用的密钥中取最晚启用的一个, 第一个密钥启用前沿用 HS256
+func (c *JwtClient) signer(now time.Time) (*signingKey, error) {
+	for i := len(c.keys) - 1; i >= 0; i-- {
+		if !c.keys[i].activeFrom.After(now) {
+			return c.keys[i], nil
+		}
+	}
+	if c.secret == nil {
+		return nil, errNoSigningKey
+	}
+	return nil, nil
+}
+
+func (c *JwtClient) sign(claims jwt.Claims, now time.Time) (string, error) {
+	k, err := c.signer(now)
+	if err != nil {
+		return "", err
+	}
+	if k == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(c.secret)
+	}
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.kid
+	return token.SignedString(k.private)
+}
+
+// keyFunc 按 kid 选择校验公钥, 算法必须与密钥一致, 防止用公钥冒充 HS256 密钥
+// 没有 kid 的令牌是切换到非对称密钥之前签发的, 只能用 HS256 校验
+func (c *JwtClient) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if c.secret == nil || token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+			return nil, errors.New("missing kid")
+		}
+		return c.secret, nil
+	}
+	k, ok := c.keysByID[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for kid %q", token.Method.Alg(), kid)
+	}
+	return k.public, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// newTokenID 生成 128 位随机 ID, 用作 jti 与令牌家族 ID
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
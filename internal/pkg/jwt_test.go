@@ -0,0 +1,263 @@
+package pkg
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// writeTestKey 把私钥以 PKCS#8 PEM 写入临时目录, 返回文件路径
+func writeTestKey(t *testing.T, name string, key interface{}) string {
+	t.Helper()
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return path
+}
+
+// testAccessClaims 返回一份能通过 ParseToken 的访问令牌声明, 调用方按需改动
+func testAccessClaims() *CustomClaims {
+	now := time.Now()
+	return &CustomClaims{
+		UserID: "1",
+		Type:   TokenTypeAccess,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    defaultIssuer,
+			Subject:   "1",
+			Audience:  jwt.ClaimStrings{defaultIssuer},
+			ID:        "jti",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+}
+
+// signTestToken 用指定的算法与密钥签名, kid 为空时不写入头部
+func signTestToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return s
+}
+
+func TestJwtClient_KeyFunc(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+	_, otherEdKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+	// 公钥是公开的, 攻击者可以拿它当 HS256 密钥签名
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	rsaPublicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPublic})
+
+	c, err := NewClient(&conf.Data{Jwt: &conf.Data_Jwt{SigningKey: "secret", ExpiresTime: 60, Keys: []*conf.Data_Jwt_Key{
+		{Kid: "rsa", PrivateKeyFile: writeTestKey(t, "rsa.pem", rsaKey)},
+		{Kid: "ed", PrivateKeyFile: writeTestKey(t, "ed.pem", edKey)},
+	}}})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		method  jwt.SigningMethod
+		kid     string
+		key     interface{}
+		wantErr error
+	}{
+		{"rsa with its kid", jwt.SigningMethodRS256, "rsa", rsaKey, nil},
+		{"ed25519 with its kid", jwt.SigningMethodEdDSA, "ed", edKey, nil},
+		{"legacy hs256 without kid", jwt.SigningMethodHS256, "", []byte("secret"), nil},
+		{"hs256 with kid", jwt.SigningMethodHS256, "rsa", []byte("secret"), ErrTokenInvalid},
+		{"hs256 keyed with the public key", jwt.SigningMethodHS256, "rsa", rsaPublicPEM, ErrTokenInvalid},
+		{"kid of another algorithm", jwt.SigningMethodEdDSA, "rsa", edKey, ErrTokenInvalid},
+		{"kid of another key", jwt.SigningMethodEdDSA, "ed", otherEdKey, ErrTokenInvalid},
+		{"unknown kid", jwt.SigningMethodRS256, "gone", rsaKey, ErrTokenInvalid},
+		{"asymmetric without kid", jwt.SigningMethodRS256, "", rsaKey, ErrTokenInvalid},
+		{"algorithm not configured", jwt.SigningMethodHS512, "", []byte("secret"), ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.ParseToken(signTestToken(t, tt.method, tt.kid, tt.key, testAccessClaims()))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 只配置了非对称密钥时不再接受任何 HS256 令牌
+func TestJwtClient_KeyFuncWithoutSecret(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	c, err := NewClient(&conf.Data{Jwt: &conf.Data_Jwt{ExpiresTime: 60, Keys: []*conf.Data_Jwt_Key{
+		{Kid: "rsa", PrivateKeyFile: writeTestKey(t, "rsa.pem", rsaKey)},
+	}}})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	// 空密钥签名的 HS256 令牌
+	for _, kid := range []string{"", "rsa"} {
+		token := signTestToken(t, jwt.SigningMethodHS256, kid, []byte{}, testAccessClaims())
+		if _, err := c.ParseToken(token); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("ParseToken(hs256, kid %q) error = %v, want ErrTokenInvalid", kid, err)
+		}
+	}
+	if _, err := c.ParseToken(signTestToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, testAccessClaims())); err != nil {
+		t.Fatalf("ParseToken(rs256) error = %v", err)
+	}
+}
+
+func TestNewClient_RequiresSecret(t *testing.T) {
+	// 没有 keys 时不能用空密钥签名, 否则任何人都能伪造令牌
+	for _, cfg := range []*conf.Data_Jwt{
+		{ExpiresTime: 60},
+		{ExpiresTime: 60, Keys: []*conf.Data_Jwt_Key{}},
+	} {
+		if _, err := NewClient(&conf.Data{Jwt: cfg}); !errors.Is(err, errEmptySecret) {
+			t.Errorf("NewClient(%v) error = %v, want errEmptySecret", cfg, err)
+		}
+	}
+	if _, err := NewClient(&conf.Data{Jwt: &conf.Data_Jwt{SigningKey: "secret", ExpiresTime: 60}}); err != nil {
+		t.Errorf("NewClient(signing_key) error = %v", err)
+	}
+}
+
+func TestJwtClient_ParseErrors(t *testing.T) {
+	c, err := NewClient(&conf.Data{Jwt: &conf.Data_Jwt{SigningKey: "secret", ExpiresTime: 60, Audiences: []string{"gateway", "video"}}})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	sign := func(mutate func(*CustomClaims)) string {
+		claims := testAccessClaims()
+		claims.Audience = jwt.ClaimStrings{"video"}
+		mutate(claims)
+		return signTestToken(t, jwt.SigningMethodHS256, "", []byte("secret"), claims)
+	}
+	past := jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", sign(func(*CustomClaims) {}), nil},
+		{"malformed", "not.a.jwt", ErrTokenMalformed},
+		{"empty", "", ErrTokenMalformed},
+		{"expired", sign(func(c *CustomClaims) { c.ExpiresAt = past }), ErrTokenExpired},
+		{"other audience", sign(func(c *CustomClaims) { c.Audience = jwt.ClaimStrings{"comment"} }), ErrTokenAudience},
+		{"no audience", sign(func(c *CustomClaims) { c.Audience = nil }), ErrTokenAudience},
+		{"other issuer", sign(func(c *CustomClaims) { c.Issuer = "someone-else" }), ErrTokenInvalid},
+		{"not yet valid", sign(func(c *CustomClaims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour)) }), ErrTokenInvalid},
+		{"missing jti", sign(func(c *CustomClaims) { c.ID = "" }), ErrTokenInvalid},
+		{"missing sub", sign(func(c *CustomClaims) { c.Subject, c.UserID = "", "" }), ErrTokenInvalid},
+		{"missing exp", sign(func(c *CustomClaims) { c.ExpiresAt = nil }), ErrTokenInvalid},
+		{"missing iat", sign(func(c *CustomClaims) { c.IssuedAt = nil }), ErrTokenInvalid},
+		{"user_id differs from sub", sign(func(c *CustomClaims) { c.UserID = "2" }), ErrTokenInvalid},
+		{"missing typ", sign(func(c *CustomClaims) { c.Type = "" }), ErrTokenInvalid},
+		{
+			// 签名错误的令牌内容不可信, 即使已过期也不报告为过期
+			"expired with bad signature",
+			signTestToken(t, jwt.SigningMethodHS256, "", []byte("other"), &CustomClaims{
+				UserID: "1", Type: TokenTypeAccess,
+				RegisteredClaims: jwt.RegisteredClaims{Issuer: defaultIssuer, Subject: "1", ID: "jti", ExpiresAt: past},
+			}),
+			ErrTokenInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.ParseToken(tt.token); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 三种令牌使用同一把密钥签名, 靠 typ 与 aud 区分, 不能互相冒用
+func TestJwtClient_TokenTypesNotInterchangeable(t *testing.T) {
+	tests := []struct {
+		name      string
+		audiences []string
+	}{
+		// 默认 aud 为 issuer, 刷新令牌与邮箱令牌的 aud 也能通过校验, 只能靠 typ 拦下
+		{"default audience", nil},
+		{"gateway audience", []string{"gateway"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(&conf.Data{Jwt: &conf.Data_Jwt{SigningKey: "secret", ExpiresTime: 60, Audiences: tt.audiences}})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			pair, err := c.GenerateToken(1, "alice", "", []string{"user"})
+			if err != nil {
+				t.Fatalf("GenerateToken() error = %v", err)
+			}
+			email, err := c.GenerateEmailToken(1, "alice@example.com", time.Hour)
+			if err != nil {
+				t.Fatalf("GenerateEmailToken() error = %v", err)
+			}
+
+			if _, err := c.ParseToken(pair.AccessToken); err != nil {
+				t.Fatalf("ParseToken(access) error = %v", err)
+			}
+			if _, err := c.ParseRefreshToken(pair.RefreshToken); err != nil {
+				t.Fatalf("ParseRefreshToken(refresh) error = %v", err)
+			}
+			if _, err := c.ParseEmailToken(email); err != nil {
+				t.Fatalf("ParseEmailToken(email) error = %v", err)
+			}
+
+			for name, token := range map[string]string{"refresh": pair.RefreshToken, "email": email} {
+				if _, err := c.ParseToken(token); err == nil {
+					t.Errorf("ParseToken(%s) accepted", name)
+				}
+			}
+			for name, token := range map[string]string{"access": pair.AccessToken, "email": email} {
+				if _, err := c.ParseRefreshToken(token); err == nil {
+					t.Errorf("ParseRefreshToken(%s) accepted", name)
+				}
+			}
+			for name, token := range map[string]string{"access": pair.AccessToken, "refresh": pair.RefreshToken} {
+				if _, err := c.ParseEmailToken(token); err == nil {
+					t.Errorf("ParseEmailToken(%s) accepted", name)
+				}
+			}
+		})
+	}
+}
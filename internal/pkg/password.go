@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+)
+
+const (
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Iterations  = 3
+	defaultArgon2Parallelism = 2
+
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// ErrMalformedPasswordHash 存储的哈希不是合法的 argon2id PHC 字符串
+var ErrMalformedPasswordHash = errors.New("password: malformed hash")
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// PasswordHasher 使用 argon2id 哈希密码, 结果为 PHC 格式:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+// 参数随哈希一起保存, 调整配置不影响已有密码的校验
+type PasswordHasher struct {
+	params argon2Params
+
+	dummyOnce sync.Once
+	dummy     string
+}
+
+// NewPasswordHasher 根据 conf.Data.Password 创建密码哈希器, 未配置的参数使用默认值
+func NewPasswordHasher(c *conf.Data) *PasswordHasher {
+	pc := c.GetPassword()
+	p := argon2Params{
+		memory:      pc.GetMemory(),
+		iterations:  pc.GetIterations(),
+		parallelism: uint8(min(pc.GetParallelism(), 255)),
+	}
+	if p.memory == 0 {
+		p.memory = defaultArgon2Memory
+	}
+	if p.iterations == 0 {
+		p.iterations = defaultArgon2Iterations
+	}
+	if p.parallelism == 0 {
+		p.parallelism = defaultArgon2Parallelism
+	}
+	return &PasswordHasher{params: p}
+}
+
+// Hash 使用随机盐和当前参数哈希密码
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.iterations, h.params.memory, h.params.parallelism, argon2KeyLen)
+
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.memory, h.params.iterations, h.params.parallelism,
+		b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// Verify 以常数时间比较密码与哈希
+// needsRehash 为 true 表示哈希使用的参数与当前配置不同, 校验通过后应重新哈希保存
+func (h *PasswordHasher) Verify(password, encoded string) (ok, needsRehash bool, err error) {
+	p, salt, key, err := decodeArgon2Hash(encoded)
+	if err != nil {
+		return false, false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+	return true, p != h.params || len(key) != argon2KeyLen || len(salt) != argon2SaltLen, nil
+}
+
+// VerifyDummy 对一个固定哈希做一次校验, 用户不存在时调用
+// 使响应耗时与用户存在时一致, 无法通过耗时判断手机号是否注册
+func (h *PasswordHasher) VerifyDummy(password string) {
+	h.dummyOnce.Do(func() {
+		h.dummy, _ = h.Hash("dummy-password")
+	})
+	_, _, _ = h.Verify(password, h.dummy)
+}
+
+func decodeArgon2Hash(encoded string) (p argon2Params, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrMalformedPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrMalformedPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return p, nil, nil, ErrMalformedPasswordHash
+	}
+	if p.memory == 0 || p.iterations == 0 || p.parallelism == 0 {
+		return p, nil, nil, ErrMalformedPasswordHash
+	}
+
+	b64 := base64.RawStdEncoding
+	if salt, err = b64.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, ErrMalformedPasswordHash
+	}
+	if key, err = b64.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return p, nil, nil, ErrMalformedPasswordHash
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+)
+
+// newTestHasher 使用较小的参数, 让测试保持快速
+func newTestHasher(memory, iterations, parallelism uint32) *PasswordHasher {
+	return NewPasswordHasher(&conf.Data{Password: &conf.Data_Password{
+		Memory:      memory,
+		Iterations:  iterations,
+		Parallelism: parallelism,
+	}})
+}
+
+func TestPasswordHasher_HashAndVerify(t *testing.T) {
+	h := newTestHasher(1024, 1, 1)
+	encoded, err := h.Hash("s3cret")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if want := "$argon2id$v=19$m=1024,t=1,p=1$"; encoded[:len(want)] != want {
+		t.Fatalf("Hash() = %q, want prefix %q", encoded, want)
+	}
+
+	ok, needsRehash, err := h.Verify("s3cret", encoded)
+	if err != nil || !ok || needsRehash {
+		t.Errorf("Verify(correct) = %v, %v, %v, want true, false, nil", ok, needsRehash, err)
+	}
+	ok, needsRehash, err = h.Verify("wrong", encoded)
+	if err != nil || ok || needsRehash {
+		t.Errorf("Verify(wrong) = %v, %v, %v, want false, false, nil", ok, needsRehash, err)
+	}
+
+	// 相同密码每次使用不同的盐
+	again, err := h.Hash("s3cret")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if again == encoded {
+		t.Error("Hash() returned the same encoding twice")
+	}
+}
+
+func TestPasswordHasher_NeedsRehash(t *testing.T) {
+	old := newTestHasher(1024, 1, 1)
+	encoded, err := old.Hash("s3cret")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		hasher *PasswordHasher
+		want   bool
+	}{
+		{"same params", newTestHasher(1024, 1, 1), false},
+		{"memory changed", newTestHasher(2048, 1, 1), true},
+		{"iterations changed", newTestHasher(1024, 2, 1), true},
+		{"parallelism changed", newTestHasher(1024, 1, 2), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 旧参数的哈希仍然可以校验, 只是提示需要重新哈希
+			ok, needsRehash, err := tt.hasher.Verify("s3cret", encoded)
+			if err != nil || !ok {
+				t.Fatalf("Verify() = %v, %v", ok, err)
+			}
+			if needsRehash != tt.want {
+				t.Errorf("needsRehash = %v, want %v", needsRehash, tt.want)
+			}
+			// 错误的密码不提示重新哈希
+			if _, needsRehash, _ := tt.hasher.Verify("wrong", encoded); needsRehash {
+				t.Error("needsRehash = true for a wrong password")
+			}
+		})
+	}
+}
+
+func TestPasswordHasher_Malformed(t *testing.T) {
+	h := newTestHasher(1024, 1, 1)
+	for _, encoded := range []string{
+		"",
+		"plaintext",
+		"$2a$10$abcdefghijklmnopqrstuv",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!!$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$",
+	} {
+		if _, _, err := h.Verify("s3cret", encoded); !errors.Is(err, ErrMalformedPasswordHash) {
+			t.Errorf("Verify(%q) error = %v, want ErrMalformedPasswordHash", encoded, err)
+		}
+	}
+}
+
+func TestPasswordHasher_VerifyDummy(t *testing.T) {
+	h := newTestHasher(8*1024, 2, 1)
+	h.VerifyDummy("anything")
+
+	// 假哈希使用当前参数, 计算量与真实用户的校验相同
+	p, _, _, err := decodeArgon2Hash(h.dummy)
+	if err != nil {
+		t.Fatalf("dummy hash malformed: %v", err)
+	}
+	if p != h.params {
+		t.Errorf("dummy params = %+v, want %+v", p, h.params)
+	}
+
+	encoded, err := h.Hash("s3cret")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	elapsed := func(fn func()) time.Duration {
+		best := time.Duration(1<<63 - 1)
+		for i := 0; i < 5; i++ {
+			start := time.Now()
+			fn()
+			best = min(best, time.Since(start))
+		}
+		return best
+	}
+	verify := elapsed(func() { _, _, _ = h.Verify("wrong", encoded) })
+	dummy := elapsed(func() { h.VerifyDummy("wrong") })
+	// 只检查量级, 避免机器负载造成误报
+	if dummy < verify/4 || dummy > verify*4 {
+		t.Errorf("VerifyDummy took %s, Verify took %s", dummy, verify)
+	}
+}
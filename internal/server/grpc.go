@@ -1,10 +1,14 @@
 package server
 
 import (
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	applogv1 "github.com/YangZhaoWeblog/UserService/api/applog/v1"
 	v1 "github.com/YangZhaoWeblog/UserService/api/helloworld/v1"
 	userv1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
 	"github.com/YangZhaoWeblog/UserService/internal/conf"
 	"github.com/YangZhaoWeblog/UserService/internal/observability"
+	"github.com/YangZhaoWeblog/UserService/internal/server/middleware"
 	"github.com/YangZhaoWeblog/UserService/internal/service"
 	"github.com/go-kratos/kratos/v2/middleware/metrics"
 	"github.com/go-kratos/kratos/v2/middleware/tracing"
@@ -15,7 +19,12 @@ import (
 // NewGRPCServer new a gRPC server.
 func NewGRPCServer(c *conf.Server, greeter *service.GreeterService,
 	user *service.UserService,
+	introspection *service.IntrospectionService,
+	uc *biz.UserUsecase,
+	applog *service.AppLogService,
 	metricsData *observability.MetricsData,
+	applogger *takin_log.TakinLogger,
+	applogSink *observability.AppLogSink,
 	tracer *sdktrace.TracerProvider,
 ) *grpc.Server {
 	var opts = []grpc.ServerOption{
@@ -26,6 +35,10 @@ func NewGRPCServer(c *conf.Server, greeter *service.GreeterService,
 				metrics.WithSeconds(metricsData.Seconds),
 				metrics.WithRequests(metricsData.Requests),
 			),
+			middleware.ServerLog(applogger, applogSink),
+			adminOnly(c),
+			internalOnly(c),
+			authRequired(c, uc),
 		),
 	}
 	if c.Grpc.Network != "" {
@@ -42,5 +55,7 @@ func NewGRPCServer(c *conf.Server, greeter *service.GreeterService,
 
 	v1.RegisterGreeterServer(srv, greeter)
 	userv1.RegisterUserServer(srv, user)
+	userv1.RegisterIntrospectionServer(srv, introspection)
+	applogv1.RegisterAppLogServer(srv, applog)
 	return srv
 }
@@ -2,10 +2,13 @@ package server
 
 import (
 	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	applogv1 "github.com/YangZhaoWeblog/UserService/api/applog/v1"
 	v1 "github.com/YangZhaoWeblog/UserService/api/helloworld/v1"
 	userv1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
 	"github.com/YangZhaoWeblog/UserService/internal/conf"
 	"github.com/YangZhaoWeblog/UserService/internal/observability"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
 	"github.com/YangZhaoWeblog/UserService/internal/server/middleware"
 	"github.com/YangZhaoWeblog/UserService/internal/service"
 	"github.com/go-kratos/kratos/v2/middleware/metrics"
@@ -18,8 +21,12 @@ import (
 // NewHTTPServer new an HTTP server.
 func NewHTTPServer(c *conf.Server, greeter *service.GreeterService,
 	user *service.UserService,
+	uc *biz.UserUsecase,
+	jwtCli *pkg.JwtClient,
+	applog *service.AppLogService,
 	metricsData *observability.MetricsData,
 	applogger *takin_log.TakinLogger,
+	applogSink *observability.AppLogSink,
 	tracer *sdktrace.TracerProvider,
 ) *http.Server {
 	var opts = []http.ServerOption{
@@ -30,7 +37,9 @@ func NewHTTPServer(c *conf.Server, greeter *service.GreeterService,
 				metrics.WithSeconds(metricsData.Seconds),
 				metrics.WithRequests(metricsData.Requests),
 			),
-			middleware.ServerLog(applogger),
+			middleware.ServerLog(applogger, applogSink),
+			adminOnly(c),
+			authRequired(c, uc),
 		),
 	}
 
@@ -48,8 +57,10 @@ func NewHTTPServer(c *conf.Server, greeter *service.GreeterService,
 	// Prometheus 定期访问 http://host.docker.internal:8010/metrics, 抓取收集的指标
 	// 仅需再次 http server 暴露，grpc 的请求也会被自动捕获到
 	srv.Handle("/metrics", promhttp.Handler())
+	srv.Handle(jwksPath, jwksHandler(jwtCli))
 
 	v1.RegisterGreeterHTTPServer(srv, greeter)
 	userv1.RegisterUserHTTPServer(srv, user)
+	applogv1.RegisterAppLogHTTPServer(srv, applog)
 	return srv
 }
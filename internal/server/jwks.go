@@ -0,0 +1,33 @@
+package server
+
+import (
+	"encoding/json"
+	nethttp "net/http"
+
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+)
+
+// jwksPath 发布签名公钥的地址, 与 OIDC discovery 的约定一致
+const jwksPath = "/.well-known/jwks.json"
+
+// jwksCacheControl 允许下游缓存公钥 5 分钟, 新密钥的启用时间至少要晚于发布时间这么久
+const jwksCacheControl = "public, max-age=300"
+
+// jwksHandler 发布访问令牌的签名公钥, 下游服务只能校验令牌, 不能签发
+// 不经过 kratos 中间件, 不需要登录
+func jwksHandler(jwtCli *pkg.JwtClient) nethttp.Handler {
+	return nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Method != nethttp.MethodGet && r.Method != nethttp.MethodHead {
+			w.WriteHeader(nethttp.StatusMethodNotAllowed)
+			return
+		}
+		body, err := json.Marshal(jwtCli.JWKS())
+		if err != nil {
+			w.WriteHeader(nethttp.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", jwksCacheControl)
+		_, _ = w.Write(body)
+	})
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/applog/v1"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+// AdminTokenHeader 管理员令牌所在的请求头, gRPC 对应同名 metadata
+const AdminTokenHeader = "X-Admin-Token"
+
+// ErrAdminUnauthorized 未携带或携带了错误的管理员令牌
+var ErrAdminUnauthorized = errors.Unauthorized(v1.ErrorReason_ADMIN_UNAUTHORIZED.String(), "admin token required")
+
+// AdminAuth is a middleware that only lets requests carrying one of the admin tokens pass.
+// 未配置任何令牌时拒绝所有请求
+func AdminAuth(tokens []string) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return nil, ErrAdminUnauthorized
+			}
+			if !matchToken(tokens, tr.RequestHeader().Get(AdminTokenHeader)) {
+				return nil, ErrAdminUnauthorized
+			}
+			return handler(ctx, req)
+		}
+	}
+}
+
+// matchToken 用常量时间比较, 避免通过响应耗时猜出令牌
+func matchToken(tokens []string, token string) bool {
+	if token == "" {
+		return false
+	}
+	matched := 0
+	for _, t := range tokens {
+		if t != "" {
+			matched |= subtle.ConstantTimeCompare([]byte(t), []byte(token))
+		}
+	}
+	return matched == 1
+}
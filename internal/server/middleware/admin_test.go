@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+func adminContext(token string) context.Context {
+	header := fakeHeader{}
+	if token != "" {
+		header[AdminTokenHeader] = token
+	}
+	return transport.NewServerContext(context.Background(), fakeTransport{
+		kind:      transport.KindGRPC,
+		operation: "/applog.v1.AppLog/ListAppLogs",
+		header:    header,
+	})
+}
+
+func TestAdminAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokens  []string
+		ctx     context.Context
+		wantErr bool
+	}{
+		{"matching token", []string{"t1", "t2"}, adminContext("t2"), false},
+		{"missing header", []string{"t1"}, adminContext(""), true},
+		{"wrong token", []string{"t1"}, adminContext("t2"), true},
+		{"prefix of a token", []string{"token"}, adminContext("tok"), true},
+		{"no tokens configured", nil, adminContext("t1"), true},
+		// 配置中的空字符串不能被空请求头匹配
+		{"empty configured token", []string{""}, adminContext(""), true},
+		{"no transport", []string{"t1"}, context.Background(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := AdminAuth(tt.tokens)(func(context.Context, interface{}) (interface{}, error) {
+				called = true
+				return "ok", nil
+			})
+			_, err := h(tt.ctx, nil)
+			if tt.wantErr {
+				if !errors.Is(err, ErrAdminUnauthorized) || called {
+					t.Fatalf("error = %v, handler called = %v, want ErrAdminUnauthorized", err, called)
+				}
+				return
+			}
+			if err != nil || !called {
+				t.Fatalf("error = %v, handler called = %v", err, called)
+			}
+		})
+	}
+}
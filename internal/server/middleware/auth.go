@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+// TokenValidator 校验访问令牌, 返回令牌中的声明
+type TokenValidator interface {
+	ValidateAccessToken(ctx context.Context, token string) (*pkg.CustomClaims, error)
+}
+
+// Auth is a middleware that requires an access token for every operation outside the public list.
+// 令牌从 Authorization: Bearer 请求头读取, gRPC 对应同名 metadata, 校验通过后声明写入 ctx
+// public 中以 / 结尾的项按前缀匹配, 例如 /helloworld.v1.Greeter/ 表示整个服务公开
+func Auth(v TokenValidator, public []string) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return nil, biz.ErrInvalidAccessToken
+			}
+			if isPublic(public, tr.Operation()) {
+				return handler(ctx, req)
+			}
+
+			token := bearerToken(tr.RequestHeader().Get("Authorization"))
+			if token == "" {
+				return nil, biz.ErrInvalidAccessToken
+			}
+			claims, err := v.ValidateAccessToken(ctx, token)
+			if err != nil {
+				return nil, err
+			}
+			recordUserID(ctx, claims.UserID)
+			return handler(biz.NewAuthContext(ctx, claims), req)
+		}
+	}
+}
+
+func isPublic(public []string, operation string) bool {
+	for _, p := range public {
+		if p == operation || (strings.HasSuffix(p, "/") && strings.HasPrefix(operation, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken 取出 Bearer 令牌, scheme 不区分大小写
+func bearerToken(header string) string {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+)
+
+// whoami 返回 ctx 中的用户 ID, 未登录时返回空串
+func whoami(ctx context.Context, _ interface{}) (interface{}, error) {
+	if c, ok := biz.AuthFromContext(ctx); ok {
+		return c.UserID, nil
+	}
+	return "", nil
+}
+
+func TestAuth(t *testing.T) {
+	v := staticValidator{"good": {UserID: "42"}}
+	public := []string{"/user.v1.User/Login", "/helloworld.v1.Greeter/"}
+	h := Auth(v, public)(whoami)
+
+	tests := []struct {
+		name          string
+		operation     string
+		authorization string
+		want          string
+		wantErr       error
+	}{
+		{"exact public", "/user.v1.User/Login", "", "", nil},
+		{"prefix public", "/helloworld.v1.Greeter/SayHello", "", "", nil},
+		{"public ignores token", "/user.v1.User/Login", "Bearer good", "", nil},
+		{"exact entry is not a prefix", "/user.v1.User/LoginWithProvider", "", "", biz.ErrInvalidAccessToken},
+		{"prefix needs the separator", "/helloworld.v1.GreeterAdmin/SayHello", "", "", biz.ErrInvalidAccessToken},
+		{"missing token", "/user.v1.User/Info", "", "", biz.ErrInvalidAccessToken},
+		{"other scheme", "/user.v1.User/Info", "Basic good", "", biz.ErrInvalidAccessToken},
+		{"empty bearer", "/user.v1.User/Info", "Bearer ", "", biz.ErrInvalidAccessToken},
+		{"invalid token", "/user.v1.User/Info", "Bearer bad", "", biz.ErrInvalidAccessToken},
+		{"valid token", "/user.v1.User/Info", "Bearer good", "42", nil},
+		{"scheme is case insensitive", "/user.v1.User/Info", "bearer  good ", "42", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h(serverContext(tt.operation, tt.authorization), nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("user = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuth_ValidatorErrorPassedThrough(t *testing.T) {
+	h := Auth(revokedValidator{}, nil)(whoami)
+	if _, err := h(serverContext("/user.v1.User/Info", "Bearer any"), nil); !errors.Is(err, biz.ErrTokenRevoked) {
+		t.Fatalf("error = %v, want ErrTokenRevoked", err)
+	}
+}
+
+func TestAuth_WithoutTransport(t *testing.T) {
+	h := Auth(staticValidator{}, []string{"/helloworld.v1.Greeter/"})(whoami)
+	if _, err := h(context.Background(), nil); !errors.Is(err, biz.ErrInvalidAccessToken) {
+		t.Fatalf("error = %v, want ErrInvalidAccessToken", err)
+	}
+}
+
+type revokedValidator struct{}
+
+func (revokedValidator) ValidateAccessToken(context.Context, string) (*pkg.CustomClaims, error) {
+	return nil, biz.ErrTokenRevoked
+}
+
+// Auth 与 Verified 按 authRequired 的顺序串联, 未验证的令牌先通过 Auth 再被 Verified 拦下
+func TestAuth_ChainedWithVerified(t *testing.T) {
+	v := staticValidator{
+		"verified":   {UserID: "1", Scope: biz.ScopeUser},
+		"unverified": {UserID: "2", Scope: biz.ScopeUnverified},
+	}
+	h := Auth(v, []string{"/user.v1.User/Login"})(Verified([]string{"/user.v1.User/Info"})(whoami))
+
+	tests := []struct {
+		operation, token string
+		wantErr          error
+	}{
+		{"/user.v1.User/Login", "", nil},
+		{"/user.v1.User/Update", "verified", nil},
+		{"/user.v1.User/Info", "unverified", nil},
+		{"/user.v1.User/Update", "unverified", biz.ErrEmailNotVerified},
+	}
+	for _, tt := range tests {
+		auth := ""
+		if tt.token != "" {
+			auth = "Bearer " + tt.token
+		}
+		if _, err := h(serverContext(tt.operation, auth), nil); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s with %q: error = %v, want %v", tt.operation, tt.token, err, tt.wantErr)
+		}
+	}
+}
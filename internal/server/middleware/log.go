@@ -3,13 +3,18 @@ package middleware
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/observability"
 
 	"github.com/go-kratos/kratos/v2/errors"
 	"github.com/go-kratos/kratos/v2/middleware"
 	"github.com/go-kratos/kratos/v2/transport"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 // Redacter defines how to log an object
@@ -21,6 +26,7 @@ type logArgs struct {
 	Kind      string
 	Component string
 	Operation string
+	UserID    int64
 	Args      string
 	Code      int
 	Reason    string
@@ -33,6 +39,7 @@ func (l logArgs) toKV() []interface{} {
 		"kind", l.Kind,
 		"component", l.Component,
 		"operation", l.Operation,
+		"user_id", l.UserID,
 		"args", l.Args,
 		"code", l.Code,
 		"reason", l.Reason,
@@ -41,8 +48,26 @@ func (l logArgs) toKV() []interface{} {
 	}
 }
 
+func (l logArgs) toAppLog(msg string) *biz.AppLog {
+	return &biz.AppLog{
+		Time:      time.Now(),
+		Level:     "error",
+		Msg:       msg,
+		Kind:      l.Kind,
+		Component: l.Component,
+		Operation: l.Operation,
+		UserID:    l.UserID,
+		Args:      l.Args,
+		Code:      l.Code,
+		Reason:    l.Reason,
+		Stack:     l.Stack,
+		Latency:   l.Latency,
+	}
+}
+
 // ServerLog is an server logging middleware.
-func ServerLog(appLogger *takin_log.TakinLogger) middleware.Middleware {
+// error 级别的日志同时交给 sink 落盘
+func ServerLog(appLogger *takin_log.TakinLogger, sink *observability.AppLogSink) middleware.Middleware {
 	return func(handler middleware.Handler) middleware.Handler {
 		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
 			// 1. 初始化日志参数
@@ -54,19 +79,34 @@ func ServerLog(appLogger *takin_log.TakinLogger) middleware.Middleware {
 				args.Operation = info.Operation()
 			}
 
-			// 3. 执行请求并记录日志
+			// 3. 执行请求并记录日志, Auth 在 handler 内部认证后回填 UserID
 			startTime := time.Now()
 			defer func() {
-				logRequestResult(ctx, appLogger, args, err, startTime)
+				logRequestResult(ctx, appLogger, sink, args, err, startTime)
 			}()
-			reply, err = handler(ctx, req)
+			reply, err = handler(context.WithValue(ctx, logArgsKey{}, args), req)
 			return
 		}
 	}
 }
 
+// logArgsKey 是 ServerLog 的日志参数在 context 中的 key
+type logArgsKey struct{}
+
+// recordUserID 把认证得到的用户 ID 回填到外层 ServerLog 的日志参数
+// Auth 位于 ServerLog 之后, 它写入 ctx 的声明对 ServerLog 不可见, 只能经由指针传回
+func recordUserID(ctx context.Context, userID string) {
+	args, ok := ctx.Value(logArgsKey{}).(*logArgs)
+	if !ok {
+		return
+	}
+	if id, err := strconv.ParseInt(userID, 10, 64); err == nil {
+		args.UserID = id
+	}
+}
+
 // ClientLog is a client logging middleware.
-func ClientLog(appLogger *takin_log.TakinLogger) middleware.Middleware {
+func ClientLog(appLogger *takin_log.TakinLogger, sink *observability.AppLogSink) middleware.Middleware {
 	return func(handler middleware.Handler) middleware.Handler {
 		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
 			// 1. 初始化日志参数
@@ -81,7 +121,7 @@ func ClientLog(appLogger *takin_log.TakinLogger) middleware.Middleware {
 			// 3. 执行请求并记录日志
 			startTime := time.Now()
 			defer func() {
-				logRequestResult(ctx, appLogger, args, err, startTime)
+				logRequestResult(ctx, appLogger, sink, args, err, startTime)
 			}()
 			reply, err = handler(ctx, req)
 			return
@@ -89,17 +129,66 @@ func ClientLog(appLogger *takin_log.TakinLogger) middleware.Middleware {
 	}
 }
 
+// redactedValue 替换敏感字段的原值
+const redactedValue = "***"
+
+// sensitiveFields 是请求中不能写入日志的字段, 按 proto 字段名匹配, 嵌套消息同样生效
+// 日志会落盘并经由管理接口返回, 密码、验证码与各类令牌一律打码
+var sensitiveFields = map[protoreflect.Name]struct{}{
+	"password":          {},
+	"verification_code": {},
+	"id_token":          {},
+	"refresh_token":     {},
+	"token":             {},
+}
+
 // extractArgs returns the string of the req
 func extractArgs(req interface{}) string {
 	if redacter, ok := req.(Redacter); ok {
 		return redacter.Redact()
 	}
+	if m, ok := req.(proto.Message); ok {
+		m = proto.Clone(m)
+		redactMessage(m.ProtoReflect())
+		req = m
+	}
 	if stringer, ok := req.(fmt.Stringer); ok {
 		return stringer.String()
 	}
 	return fmt.Sprintf("%+v", req)
 }
 
+// redactMessage 把消息及其子消息中的敏感字段替换为 redactedValue, 未赋值的字段保持为空
+func redactMessage(m protoreflect.Message) {
+	var sensitive []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+		case fd.Kind() == protoreflect.StringKind:
+			if _, ok := sensitiveFields[fd.Name()]; ok {
+				sensitive = append(sensitive, fd)
+			}
+		case fd.Kind() == protoreflect.MessageKind && fd.IsList():
+			for l, i := v.List(), 0; i < l.Len(); i++ {
+				redactMessage(l.Get(i).Message())
+			}
+		case fd.Kind() == protoreflect.MessageKind:
+			redactMessage(v.Message())
+		}
+		return true
+	})
+	// Range 过程中不修改消息, 遍历结束后再替换
+	for _, fd := range sensitive {
+		if fd.IsList() {
+			for l, i := m.Mutable(fd).List(), 0; i < l.Len(); i++ {
+				l.Set(i, protoreflect.ValueOfString(redactedValue))
+			}
+			continue
+		}
+		m.Set(fd, protoreflect.ValueOfString(redactedValue))
+	}
+}
+
 func newLogArgs(kind string, req interface{}) *logArgs {
 	return &logArgs{
 		Kind:      kind,
@@ -112,7 +201,7 @@ func newLogArgs(kind string, req interface{}) *logArgs {
 	}
 }
 
-func logRequestResult(ctx context.Context, logger *takin_log.TakinLogger, args *logArgs, err error, startTime time.Time) {
+func logRequestResult(ctx context.Context, logger *takin_log.TakinLogger, sink *observability.AppLogSink, args *logArgs, err error, startTime time.Time) {
 	var msg string
 	if se := errors.FromError(err); se != nil {
 		args.Code = int(se.Code)
@@ -125,6 +214,7 @@ func logRequestResult(ctx context.Context, logger *takin_log.TakinLogger, args *
 	if err != nil {
 		args.Stack = fmt.Sprintf("%+v", err)
 		logger.ErrorContext(ctx, msg, args.toKV()...)
+		sink.Write(ctx, args.toAppLog(msg))
 		return
 	}
 	logger.InfoContext(ctx, msg, args.toKV()...)
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/YangZhaoWeblog/UserService/internal/observability"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+	"github.com/go-kratos/kratos/v2/transport"
+	"google.golang.org/protobuf/proto"
+)
+
+type fakeHeader map[string]string
+
+func (h fakeHeader) Get(key string) string      { return h[key] }
+func (h fakeHeader) Set(key, value string)      { h[key] = value }
+func (h fakeHeader) Add(key, value string)      { h[key] = value }
+func (h fakeHeader) Keys() []string             { return nil }
+func (h fakeHeader) Values(key string) []string { return []string{h[key]} }
+
+// fakeTransport 是测试用的服务端 transport, kind 为空时视为 HTTP
+type fakeTransport struct {
+	kind      transport.Kind
+	operation string
+	header    fakeHeader
+}
+
+func (t fakeTransport) Kind() transport.Kind {
+	if t.kind == "" {
+		return transport.KindHTTP
+	}
+	return t.kind
+}
+func (t fakeTransport) Endpoint() string                { return "" }
+func (t fakeTransport) Operation() string               { return t.operation }
+func (t fakeTransport) RequestHeader() transport.Header { return t.header }
+func (t fakeTransport) ReplyHeader() transport.Header   { return fakeHeader{} }
+
+func serverContext(operation, authorization string) context.Context {
+	return transport.NewServerContext(context.Background(), fakeTransport{
+		operation: operation,
+		header:    fakeHeader{"Authorization": authorization},
+	})
+}
+
+// staticValidator 只接受 map 中的令牌
+type staticValidator map[string]*pkg.CustomClaims
+
+func (v staticValidator) ValidateAccessToken(_ context.Context, token string) (*pkg.CustomClaims, error) {
+	if c, ok := v[token]; ok {
+		return c, nil
+	}
+	return nil, biz.ErrInvalidAccessToken
+}
+
+func TestAuth_RecordsUserIDForServerLog(t *testing.T) {
+	args := &logArgs{}
+	ctx := context.WithValue(serverContext("/user.v1.User/Info", "Bearer good"), logArgsKey{}, args)
+	h := Auth(staticValidator{"good": {UserID: "42"}}, nil)(func(context.Context, interface{}) (interface{}, error) {
+		return nil, nil
+	})
+
+	if _, err := h(ctx, nil); err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	if args.UserID != 42 {
+		t.Errorf("UserID = %d, want 42", args.UserID)
+	}
+	if got := args.toAppLog("boom").UserID; got != 42 {
+		t.Errorf("AppLog.UserID = %d, want 42", got)
+	}
+}
+
+func TestAuth_PublicOperationLeavesUserIDEmpty(t *testing.T) {
+	args := &logArgs{}
+	ctx := context.WithValue(serverContext("/user.v1.User/Login", ""), logArgsKey{}, args)
+	h := Auth(staticValidator{}, []string{"/user.v1.User/Login"})(func(context.Context, interface{}) (interface{}, error) {
+		return nil, nil
+	})
+
+	if _, err := h(ctx, nil); err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	if args.UserID != 0 {
+		t.Errorf("UserID = %d, want 0", args.UserID)
+	}
+}
+
+// memoryAppLogRepo 记录 sink 写入的日志
+type memoryAppLogRepo struct {
+	biz.AppLogRepo
+	mu   sync.Mutex
+	logs []*biz.AppLog
+}
+
+func (r *memoryAppLogRepo) BatchSave(_ context.Context, logs []*biz.AppLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs = append(r.logs, logs...)
+	return nil
+}
+
+func TestServerLog_FailedLoginDoesNotPersistPassword(t *testing.T) {
+	repo := &memoryAppLogRepo{}
+	sink, closeSink := observability.NewAppLogSink(&conf.Log{Persist: &conf.Log_Persist{Enabled: true}}, &conf.App{},
+		repo, &observability.MetricsData{}, takin_log.NewTakinLogger(takin_log.TakinLoggerOptions{}))
+	h := ServerLog(takin_log.NewTakinLogger(takin_log.TakinLoggerOptions{}), sink)(func(context.Context, interface{}) (interface{}, error) {
+		return nil, biz.ErrInvalidCredentials
+	})
+	req := &v1.LoginRequest{
+		AuthType: &v1.LoginRequest_Email{Email: &v1.EmailLogin{Email: "alice@example.com", Password: "hunter2-secret"}},
+		DeviceId: "device-1",
+	}
+
+	if _, err := h(serverContext("/user.v1.User/Login", ""), req); err == nil {
+		t.Fatal("handler error = nil")
+	}
+	closeSink()
+
+	if len(repo.logs) != 1 {
+		t.Fatalf("persisted %d logs, want 1", len(repo.logs))
+	}
+	args := repo.logs[0].Args
+	if strings.Contains(args, "hunter2-secret") {
+		t.Errorf("password persisted: %s", args)
+	}
+	if !strings.Contains(args, "alice@example.com") || !strings.Contains(args, redactedValue) {
+		t.Errorf("args = %s, want email kept and password redacted", args)
+	}
+	// 打码作用在副本上, 不影响交给 handler 的请求
+	if req.GetEmail().GetPassword() != "hunter2-secret" {
+		t.Errorf("request mutated: %v", req)
+	}
+}
+
+func TestExtractArgs_RedactsSensitiveFields(t *testing.T) {
+	const secret = "s3cr3t-value"
+	tests := []struct {
+		name string
+		req  proto.Message
+	}{
+		{"phone register", &v1.RegisterRequest{AuthType: &v1.RegisterRequest_Phone{Phone: &v1.PhoneRegister{
+			PhoneNumber: "13800000000", VerificationCode: secret, Password: secret,
+		}}}},
+		{"phone login with code", &v1.LoginRequest{AuthType: &v1.LoginRequest_Phone{Phone: &v1.PhoneLogin{
+			PhoneNumber: "13800000000", Verification: &v1.PhoneLogin_VerificationCode{VerificationCode: secret},
+		}}}},
+		{"google login", &v1.LoginRequest{AuthType: &v1.LoginRequest_Google{Google: &v1.GoogleLogin{IdToken: secret}}}},
+		{"oidc register", &v1.RegisterRequest{AuthType: &v1.RegisterRequest_Oidc{Oidc: &v1.OidcRegister{Provider: "apple", IdToken: secret}}}},
+		{"refresh", &v1.RefreshTokenRequest{RefreshToken: secret}},
+		{"introspect", &v1.IntrospectRequest{Token: secret}},
+		{"verify email", &v1.VerifyEmailRequest{Token: secret}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractArgs(tt.req); strings.Contains(got, secret) || !strings.Contains(got, redactedValue) {
+				t.Errorf("extractArgs() = %s", got)
+			}
+		})
+	}
+
+	// 未赋值的敏感字段不输出占位符, 非敏感字段原样保留
+	got := extractArgs(&v1.LoginRequest{AuthType: &v1.LoginRequest_Oidc{Oidc: &v1.OidcLogin{Provider: "apple"}}})
+	if strings.Contains(got, redactedValue) || !strings.Contains(got, "apple") {
+		t.Errorf("extractArgs(empty id_token) = %s", got)
+	}
+}
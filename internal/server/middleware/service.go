@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+// ServiceTokenHeader 内部服务令牌所在的 metadata
+const ServiceTokenHeader = "X-Service-Token"
+
+// ErrServiceUnauthorized 未携带或携带了错误的内部服务令牌
+var ErrServiceUnauthorized = errors.Unauthorized(v1.ErrorReason_SERVICE_UNAUTHORIZED.String(), "service token required")
+
+// ServiceAuth is a middleware that only lets gRPC requests carrying one of the internal service tokens pass.
+// 未配置任何令牌时拒绝所有请求
+func ServiceAuth(tokens []string) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok || tr.Kind() != transport.KindGRPC {
+				return nil, ErrServiceUnauthorized
+			}
+			if !matchToken(tokens, tr.RequestHeader().Get(ServiceTokenHeader)) {
+				return nil, ErrServiceUnauthorized
+			}
+			return handler(ctx, req)
+		}
+	}
+}
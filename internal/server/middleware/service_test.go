@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+func TestServiceAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokens  []string
+		kind    transport.Kind
+		token   string
+		wantErr error
+	}{
+		{"grpc with a valid token", []string{"s1", "s2"}, transport.KindGRPC, "s2", nil},
+		{"http with a valid token", []string{"s1"}, transport.KindHTTP, "s1", ErrServiceUnauthorized},
+		{"missing token", []string{"s1"}, transport.KindGRPC, "", ErrServiceUnauthorized},
+		{"wrong token", []string{"s1"}, transport.KindGRPC, "s3", ErrServiceUnauthorized},
+		{"token prefix", []string{"s1"}, transport.KindGRPC, "s", ErrServiceUnauthorized},
+		{"no tokens configured", nil, transport.KindGRPC, "", ErrServiceUnauthorized},
+		{"empty configured token", []string{""}, transport.KindGRPC, "", ErrServiceUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := ServiceAuth(tt.tokens)(func(context.Context, interface{}) (interface{}, error) {
+				called = true
+				return nil, nil
+			})
+			ctx := transport.NewServerContext(context.Background(), fakeTransport{
+				kind:      tt.kind,
+				operation: "/user.v1.Introspection/Introspect",
+				header:    fakeHeader{ServiceTokenHeader: tt.token},
+			})
+			if _, err := h(ctx, nil); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if called != (tt.wantErr == nil) {
+				t.Errorf("handler called = %v", called)
+			}
+		})
+	}
+}
+
+func TestServiceAuth_WithoutTransport(t *testing.T) {
+	h := ServiceAuth([]string{"s1"})(func(context.Context, interface{}) (interface{}, error) { return nil, nil })
+	if _, err := h(context.Background(), nil); !errors.Is(err, ErrServiceUnauthorized) {
+		t.Fatalf("error = %v, want ErrServiceUnauthorized", err)
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+// Verified is a middleware that limits accounts with an unverified email to the allowed operations.
+// 必须放在 Auth 之后; 只拦截带 unverified 权限范围的令牌, 公开接口与其他令牌不受影响
+// allowed 的匹配规则与 Auth 的 public 相同
+func Verified(allowed []string) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			claims, ok := biz.AuthFromContext(ctx)
+			if !ok || !hasScope(claims.Scopes(), biz.ScopeUnverified) {
+				return handler(ctx, req)
+			}
+			if tr, ok := transport.FromServerContext(ctx); ok && isPublic(allowed, tr.Operation()) {
+				return handler(ctx, req)
+			}
+			return nil, biz.ErrEmailNotVerified
+		}
+	}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+func TestVerified(t *testing.T) {
+	allowed := []string{"/user.v1.User/Info", "/user.v1.User/Session/"}
+	h := Verified(allowed)(func(context.Context, interface{}) (interface{}, error) { return "ok", nil })
+
+	tests := []struct {
+		name      string
+		operation string
+		claims    *pkg.CustomClaims // nil 表示未经过 Auth, 如公开接口
+		wantErr   error
+	}{
+		{"anonymous", "/user.v1.User/Update", nil, nil},
+		{"verified", "/user.v1.User/Update", &pkg.CustomClaims{Scope: biz.ScopeUser}, nil},
+		{"no scope", "/user.v1.User/Update", &pkg.CustomClaims{}, nil},
+		{"unverified allowed exact", "/user.v1.User/Info", &pkg.CustomClaims{Scope: biz.ScopeUnverified}, nil},
+		{"unverified allowed prefix", "/user.v1.User/Session/List", &pkg.CustomClaims{Scope: biz.ScopeUnverified}, nil},
+		{"unverified blocked", "/user.v1.User/Update", &pkg.CustomClaims{Scope: biz.ScopeUnverified}, biz.ErrEmailNotVerified},
+		{"unverified among scopes", "/user.v1.User/Update", &pkg.CustomClaims{Scope: "user unverified"}, biz.ErrEmailNotVerified},
+		{"exact entry is not a prefix", "/user.v1.User/InfoAll", &pkg.CustomClaims{Scope: biz.ScopeUnverified}, biz.ErrEmailNotVerified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := transport.NewServerContext(context.Background(), fakeTransport{operation: tt.operation})
+			if tt.claims != nil {
+				ctx = biz.NewAuthContext(ctx, tt.claims)
+			}
+			if _, err := h(ctx, nil); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
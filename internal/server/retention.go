@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+)
+
+const (
+	retentionLockKey         = "applog:retention"
+	defaultRetentionInterval = time.Hour
+	defaultRetentionLockTTL  = 10 * time.Minute
+)
+
+// RetentionJob 定期按保留策略清理、归档 AppLog 表
+// 实现了 transport.Server, 随 kratos.App 一起启停
+type RetentionJob struct {
+	uc        *biz.AppLogUsecase
+	locker    biz.Locker
+	policy    *biz.AppLogRetentionPolicy
+	interval  time.Duration
+	lockTTL   time.Duration
+	enabled   bool
+	logHelper *takin_log.TakinLogger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewRetentionJob 根据 conf.Log.Retention 创建清理任务
+func NewRetentionJob(c *conf.Log, uc *biz.AppLogUsecase, locker biz.Locker, logHelper *takin_log.TakinLogger) *RetentionJob {
+	rc := c.GetRetention()
+
+	policy := &biz.AppLogRetentionPolicy{
+		DefaultMaxAge:  rc.GetDefaultMaxAge().AsDuration(),
+		DefaultArchive: rc.GetDefaultArchive(),
+		BatchSize:      int(rc.GetBatchSize()),
+	}
+	for _, r := range rc.GetRules() {
+		policy.Rules = append(policy.Rules, biz.AppLogRetentionRule{
+			AppLogMatch: biz.AppLogMatch{Level: r.GetLevel(), Code: int(r.GetCode())},
+			MaxAge:      r.GetMaxAge().AsDuration(),
+			Archive:     r.GetArchive(),
+		})
+	}
+
+	j := &RetentionJob{
+		uc:        uc,
+		locker:    locker,
+		policy:    policy,
+		interval:  rc.GetInterval().AsDuration(),
+		lockTTL:   rc.GetLockTtl().AsDuration(),
+		enabled:   rc.GetEnabled(),
+		logHelper: logHelper,
+	}
+	if j.interval <= 0 {
+		j.interval = defaultRetentionInterval
+	}
+	if j.lockTTL <= 0 {
+		j.lockTTL = defaultRetentionLockTTL
+	}
+	return j
+}
+
+// Start 启动定时清理, 未开启时直接返回
+func (j *RetentionJob) Start(context.Context) error {
+	if !j.enabled {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	j.done = make(chan struct{})
+	go j.loop(ctx)
+	return nil
+}
+
+// Stop 停止定时清理, 等待正在执行的一轮结束
+func (j *RetentionJob) Stop(ctx context.Context) error {
+	if j.cancel == nil {
+		return nil
+	}
+	j.cancel()
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (j *RetentionJob) loop(ctx context.Context) {
+	defer close(j.done)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.runOnce(ctx)
+		}
+	}
+}
+
+// runOnce 抢到锁的副本执行一轮清理, 其他副本跳过
+func (j *RetentionJob) runOnce(ctx context.Context) {
+	unlock, ok, err := j.locker.TryLock(ctx, retentionLockKey, j.lockTTL)
+	if err != nil {
+		j.logHelper.ErrorContext(ctx, "acquire applog retention lock failed", "err", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// 单轮执行不能超过锁的有效期, 否则其他副本可能同时开始清理
+	ctx, cancel := context.WithTimeout(ctx, j.lockTTL)
+	defer cancel()
+
+	start := time.Now()
+	n, err := j.uc.Purge(ctx, j.policy, start)
+	if err != nil {
+		j.logHelper.ErrorContext(ctx, "purge applog failed", "deleted", n, "err", err)
+		return
+	}
+	j.logHelper.InfoContext(ctx, "purge applog finished", "deleted", n, "cost", time.Since(start).String())
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// fakeLocker 模拟分布式锁, held 为 true 时锁被其他副本持有
+type fakeLocker struct {
+	held     bool
+	ttl      time.Duration
+	unlocked int
+}
+
+func (l *fakeLocker) TryLock(_ context.Context, _ string, ttl time.Duration) (func(), bool, error) {
+	if l.held {
+		return nil, false, nil
+	}
+	l.ttl = ttl
+	return func() { l.unlocked++ }, true, nil
+}
+
+// countingAppLogRepo 统计清理时的查询次数, 不返回任何过期日志
+type countingAppLogRepo struct {
+	biz.AppLogRepo
+	listed int
+}
+
+func (r *countingAppLogRepo) ListExpired(context.Context, biz.AppLogMatch, []biz.AppLogMatch, time.Time, int) ([]*biz.AppLog, error) {
+	r.listed++
+	return nil, nil
+}
+
+func TestRetentionJob_RunOnceHoldsLock(t *testing.T) {
+	c := &conf.Log{Retention: &conf.Log_Retention{
+		Enabled:       true,
+		DefaultMaxAge: durationpb.New(time.Hour),
+		LockTtl:       durationpb.New(time.Minute),
+	}}
+	repo := &countingAppLogRepo{}
+	locker := &fakeLocker{held: true}
+	j := NewRetentionJob(c, biz.NewAppLogUsecase(repo, nil), locker, takin_log.NewTakinLogger(takin_log.TakinLoggerOptions{}))
+
+	// 其他副本持有锁时跳过本轮
+	j.runOnce(context.Background())
+	if repo.listed != 0 {
+		t.Fatalf("purged while another replica holds the lock")
+	}
+
+	locker.held = false
+	j.runOnce(context.Background())
+	if repo.listed == 0 {
+		t.Fatal("purge did not run after acquiring the lock")
+	}
+	if locker.unlocked != 1 || locker.ttl != time.Minute {
+		t.Errorf("unlocked %d times with ttl %v, want once with 1m", locker.unlocked, locker.ttl)
+	}
+}
+
+func TestRetentionJob_StartStop(t *testing.T) {
+	c := &conf.Log{Retention: &conf.Log_Retention{
+		Enabled:       true,
+		Interval:      durationpb.New(5 * time.Millisecond),
+		DefaultMaxAge: durationpb.New(time.Hour),
+	}}
+	locker := &fakeLocker{}
+	j := NewRetentionJob(c, biz.NewAppLogUsecase(&countingAppLogRepo{}, nil), locker, takin_log.NewTakinLogger(takin_log.TakinLoggerOptions{}))
+	if err := j.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := j.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	// 未开启时 Start 与 Stop 都是空操作
+	disabled := NewRetentionJob(&conf.Log{}, nil, locker, nil)
+	if err := disabled.Start(context.Background()); err != nil {
+		t.Fatalf("Start(disabled) error = %v", err)
+	}
+	if err := disabled.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop(disabled) error = %v", err)
+	}
+}
@@ -1,8 +1,64 @@
 package server
 
 import (
+	applogv1 "github.com/YangZhaoWeblog/UserService/api/applog/v1"
+	helloworldv1 "github.com/YangZhaoWeblog/UserService/api/helloworld/v1"
+	userv1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"github.com/YangZhaoWeblog/UserService/internal/server/middleware"
+	kmiddleware "github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/middleware/selector"
 	"github.com/google/wire"
 )
 
 // ProviderSet is server providers.
-var ProviderSet = wire.NewSet(NewGRPCServer, NewHTTPServer)
+var ProviderSet = wire.NewSet(NewGRPCServer, NewHTTPServer, NewRetentionJob)
+
+// defaultPublicOperations 未配置 conf.Server.Auth 时不需要登录的接口
+var defaultPublicOperations = []string{
+	userv1.OperationUserRegister,
+	userv1.OperationUserLogin,
+	userv1.OperationUserRefreshToken,
+	userv1.OperationUserSendVerificationCode,
+	userv1.OperationUserVerifyEmail,
+	"/" + helloworldv1.Greeter_ServiceDesc.ServiceName + "/",
+}
+
+// unverifiedOperations 邮箱未验证的账号可以访问的接口, 其余接口返回 EMAIL_NOT_VERIFIED
+var unverifiedOperations = []string{
+	userv1.OperationUserInfo,
+	userv1.OperationUserLogout,
+	userv1.OperationUserLogoutAll,
+	userv1.OperationUserListSessions,
+	userv1.OperationUserRevokeSession,
+	userv1.OperationUserResendVerificationEmail,
+}
+
+// authRequired 除公开接口、管理接口与内部接口外都要求携带有效的访问令牌, http 与 grpc 共用
+// 邮箱未验证的账号只能访问 unverifiedOperations
+func authRequired(c *conf.Server, uc *biz.UserUsecase) kmiddleware.Middleware {
+	public := c.GetAuth().GetPublicOperations()
+	if len(public) == 0 {
+		public = defaultPublicOperations
+	}
+	public = append([]string{
+		"/" + applogv1.AppLog_ServiceDesc.ServiceName + "/",
+		"/" + userv1.Introspection_ServiceDesc.ServiceName + "/",
+	}, public...)
+	return kmiddleware.Chain(middleware.Auth(uc, public), middleware.Verified(unverifiedOperations))
+}
+
+// adminOnly 管理接口只允许携带管理员令牌的请求访问, http 与 grpc 共用
+func adminOnly(c *conf.Server) kmiddleware.Middleware {
+	return selector.Server(middleware.AdminAuth(c.GetAdmin().GetTokens())).
+		Prefix("/" + applogv1.AppLog_ServiceDesc.ServiceName + "/").
+		Build()
+}
+
+// internalOnly 内部接口只允许携带内部服务令牌的 gRPC 请求访问
+func internalOnly(c *conf.Server) kmiddleware.Middleware {
+	return selector.Server(middleware.ServiceAuth(c.GetInternal().GetTokens())).
+		Prefix("/" + userv1.Introspection_ServiceDesc.ServiceName + "/").
+		Build()
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	userv1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+type fakeHeader map[string]string
+
+func (h fakeHeader) Get(key string) string      { return h[key] }
+func (h fakeHeader) Set(key, value string)      { h[key] = value }
+func (h fakeHeader) Add(key, value string)      { h[key] = value }
+func (h fakeHeader) Keys() []string             { return nil }
+func (h fakeHeader) Values(key string) []string { return []string{h[key]} }
+
+type fakeTransport struct{ operation string }
+
+func (t fakeTransport) Kind() transport.Kind            { return transport.KindHTTP }
+func (t fakeTransport) Endpoint() string                { return "" }
+func (t fakeTransport) Operation() string               { return t.operation }
+func (t fakeTransport) RequestHeader() transport.Header { return fakeHeader{} }
+func (t fakeTransport) ReplyHeader() transport.Header   { return fakeHeader{} }
+
+// 绑定、解绑等账号操作不在公开接口中, 未携带令牌时不会进入 handler
+func TestAuthRequired_RejectsAnonymousAccountOperations(t *testing.T) {
+	m := authRequired(&conf.Server{}, nil)
+	for _, op := range []string{
+		userv1.OperationUserLinkIdentity,
+		userv1.OperationUserUnlinkIdentity,
+		userv1.OperationUserInfo,
+	} {
+		t.Run(op, func(t *testing.T) {
+			called := false
+			h := m(func(context.Context, interface{}) (interface{}, error) {
+				called = true
+				return nil, nil
+			})
+			ctx := transport.NewServerContext(context.Background(), fakeTransport{operation: op})
+			if _, err := h(ctx, nil); !errors.Is(err, biz.ErrInvalidAccessToken) {
+				t.Errorf("error = %v, want ErrInvalidAccessToken", err)
+			}
+			if called {
+				t.Error("handler called without an access token")
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/applog/v1"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// AppLogService 是错误日志查询服务, 只对管理员开放
+type AppLogService struct {
+	v1.UnimplementedAppLogServer
+	uc *biz.AppLogUsecase
+}
+
+// NewAppLogService 创建错误日志查询服务
+func NewAppLogService(uc *biz.AppLogUsecase) *AppLogService {
+	return &AppLogService{uc: uc}
+}
+
+// ListAppLogs 分页查询错误日志
+func (s *AppLogService) ListAppLogs(ctx context.Context, req *v1.ListAppLogsRequest) (*v1.ListAppLogsReply, error) {
+	logs, next, err := s.uc.Search(ctx, toAppLogFilter(req.GetFilter()), int(req.GetPageSize()), req.GetCursor())
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &v1.ListAppLogsReply{
+		Logs:       make([]*v1.AppLogRecord, 0, len(logs)),
+		NextCursor: next,
+	}
+	for _, l := range logs {
+		reply.Logs = append(reply.Logs, &v1.AppLogRecord{
+			Id:        int64(l.ID),
+			Time:      timestamppb.New(l.Time),
+			Level:     l.Level,
+			Msg:       l.Msg,
+			Kind:      l.Kind,
+			Component: l.Component,
+			Operation: l.Operation,
+			UserId:    l.UserID,
+			TraceId:   l.TraceID,
+			SpanId:    l.SpanID,
+			Args:      l.Args,
+			Code:      int32(l.Code),
+			Reason:    l.Reason,
+			Stack:     l.Stack,
+			Latency:   l.Latency,
+			AppName:   l.AppName,
+		})
+	}
+	return reply, nil
+}
+
+// CountAppLogsByCode 按错误码聚合
+func (s *AppLogService) CountAppLogsByCode(ctx context.Context, req *v1.CountAppLogsByCodeRequest) (*v1.CountAppLogsByCodeReply, error) {
+	counts, err := s.uc.CountByCode(ctx, toAppLogFilter(req.GetFilter()))
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &v1.CountAppLogsByCodeReply{
+		Counts: make([]*v1.CodeCount, 0, len(counts)),
+	}
+	for _, c := range counts {
+		reply.Counts = append(reply.Counts, &v1.CodeCount{
+			Code:  int32(c.Code),
+			Count: c.Count,
+		})
+	}
+	return reply, nil
+}
+
+func toAppLogFilter(f *v1.AppLogFilter) *biz.AppLogFilter {
+	filter := &biz.AppLogFilter{
+		Code:      int(f.GetCode()),
+		Reason:    f.GetReason(),
+		Operation: f.GetOperation(),
+		TraceID:   f.GetTraceId(),
+		UserID:    f.GetUserId(),
+	}
+	if f.GetStartTime() != nil {
+		filter.StartTime = f.GetStartTime().AsTime()
+	}
+	if f.GetEndTime() != nil {
+		filter.EndTime = f.GetEndTime().AsTime()
+	}
+	return filter
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/go-kratos/kratos/v2/transport"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"google.golang.org/grpc/peer"
+
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+)
+
+// trustedProxies 是可信的反向代理网段, 只有直接连接来自这些地址时才读取转发头
+// X-Forwarded-For 的左侧由客户端任意填写, 不能用于按 IP 限流
+type trustedProxies []netip.Prefix
+
+// newTrustedProxies 解析 conf.Server.trusted_proxies, 每项为 IP 或 CIDR
+func newTrustedProxies(c *conf.Server) (trustedProxies, error) {
+	var proxies trustedProxies
+	for _, s := range c.GetTrustedProxies() {
+		if prefix, err := netip.ParsePrefix(s); err == nil {
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", s)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+func (p trustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 返回客户端地址
+// 直接连接方不是可信代理时使用连接地址本身; 是可信代理时从 X-Forwarded-For 右侧往左,
+// 跳过可信代理, 第一个不可信的地址即为客户端, 它左边的内容都可能是伪造的
+func (p trustedProxies) clientIP(remote string, header transport.Header) string {
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !p.contains(addr) {
+		return remote
+	}
+
+	var hops []string
+	for _, v := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	if len(hops) == 0 {
+		if ip, err := netip.ParseAddr(header.Get("X-Real-IP")); err == nil {
+			return ip.Unmap().String()
+		}
+		return remote
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// 格式错误的记录不是可信代理写入的, 停在最后一个可信的地址
+			break
+		}
+		client = hop.Unmap().String()
+		if !p.contains(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// clientInfo 从请求中取出客户端的 IP 与 User-Agent
+// device_id 由客户端自行上报, 只用于会话展示和辅助限流, 不作为安全边界
+func (s *UserService) clientInfo(ctx context.Context, deviceID string) biz.ClientInfo {
+	info := biz.ClientInfo{DeviceID: deviceID}
+
+	tr, ok := transport.FromServerContext(ctx)
+	if !ok {
+		return info
+	}
+	header := tr.RequestHeader()
+	info.UserAgent = header.Get("User-Agent")
+	info.IP = s.proxies.clientIP(remoteIP(ctx), header)
+	return info
+}
+
+// remoteIP 返回直接连接方的地址, HTTP 取 RemoteAddr, gRPC 取 peer
+func remoteIP(ctx context.Context) string {
+	var remote string
+	if req, ok := khttp.RequestFromServerContext(ctx); ok {
+		remote = req.RemoteAddr
+	} else if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if addr, err := netip.ParseAddr(remote); err == nil {
+		return addr.Unmap().String()
+	}
+	return remote
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+
+	"github.com/go-kratos/kratos/v2/transport"
+	"google.golang.org/grpc/peer"
+)
+
+type fakeHeader map[string][]string
+
+func (h fakeHeader) Get(key string) string {
+	if v := h[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+func (h fakeHeader) Set(key, value string)      { h[key] = []string{value} }
+func (h fakeHeader) Add(key, value string)      { h[key] = append(h[key], value) }
+func (h fakeHeader) Keys() []string             { return nil }
+func (h fakeHeader) Values(key string) []string { return h[key] }
+
+type fakeTransport struct{ header fakeHeader }
+
+func (t fakeTransport) Kind() transport.Kind            { return transport.KindGRPC }
+func (t fakeTransport) Endpoint() string                { return "" }
+func (t fakeTransport) Operation() string               { return v1.OperationUserSendVerificationCode }
+func (t fakeTransport) RequestHeader() transport.Header { return t.header }
+func (t fakeTransport) ReplyHeader() transport.Header   { return fakeHeader{} }
+
+// requestContext 模拟一个来自 remote 的 gRPC 请求
+func requestContext(remote string, header fakeHeader) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(remote), Port: 52000},
+	})
+	return transport.NewServerContext(ctx, fakeTransport{header: header})
+}
+
+func newTestUserService(t *testing.T, proxies ...string) *UserService {
+	t.Helper()
+	s, err := NewUserService(&conf.Server{TrustedProxies: proxies}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewUserService() error = %v", err)
+	}
+	return s
+}
+
+func TestClientInfo_IP(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		remote  string
+		header  fakeHeader
+		want    string
+	}{
+		{"direct", nil, "203.0.113.7", fakeHeader{}, "203.0.113.7"},
+		{"untrusted xff ignored", nil, "203.0.113.7", fakeHeader{"X-Forwarded-For": {"1.2.3.4"}}, "203.0.113.7"},
+		{"untrusted real ip ignored", nil, "203.0.113.7", fakeHeader{"X-Real-IP": {"1.2.3.4"}}, "203.0.113.7"},
+		{"trusted proxy", []string{"10.0.0.0/8"}, "10.0.0.2", fakeHeader{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+		{"spoofed left-most entry", []string{"10.0.0.0/8"}, "10.0.0.2",
+			fakeHeader{"X-Forwarded-For": {"1.2.3.4, 203.0.113.7"}}, "203.0.113.7"},
+		{"proxy chain", []string{"10.0.0.0/8", "192.0.2.10"}, "10.0.0.2",
+			fakeHeader{"X-Forwarded-For": {"1.2.3.4, 203.0.113.7, 192.0.2.10"}}, "203.0.113.7"},
+		{"multiple headers", []string{"10.0.0.0/8"}, "10.0.0.2",
+			fakeHeader{"X-Forwarded-For": {"1.2.3.4", "203.0.113.7"}}, "203.0.113.7"},
+		{"malformed hop", []string{"10.0.0.0/8"}, "10.0.0.2",
+			fakeHeader{"X-Forwarded-For": {"203.0.113.7, not-an-ip"}}, "10.0.0.2"},
+		{"trusted real ip", []string{"10.0.0.2"}, "10.0.0.2", fakeHeader{"X-Real-IP": {"203.0.113.7"}}, "203.0.113.7"},
+		{"all hops trusted", []string{"10.0.0.0/8"}, "10.0.0.2", fakeHeader{"X-Forwarded-For": {"10.1.1.1"}}, "10.1.1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestUserService(t, tt.proxies...)
+			if got := s.clientInfo(requestContext(tt.remote, tt.header), "").IP; got != tt.want {
+				t.Errorf("IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewUserService_InvalidTrustedProxy(t *testing.T) {
+	if _, err := NewUserService(&conf.Server{TrustedProxies: []string{"10.0.0.0/33"}}, nil, nil, nil, nil); err == nil {
+		t.Error("NewUserService() accepted an invalid trusted proxy")
+	}
+}
+
+// ipLimiter 每个 IP 最多发送 limit 次, 只按 IP 计数
+type ipLimiter struct {
+	limit  int
+	counts map[string]int
+}
+
+func (l *ipLimiter) Acquire(_ context.Context, _ string, client biz.ClientInfo) error {
+	if l.counts[client.IP] >= l.limit {
+		return biz.ErrSmsHourlyLimitExceeded
+	}
+	l.counts[client.IP]++
+	return nil
+}
+
+type nopCodeRepo struct{}
+
+func (nopCodeRepo) Save(context.Context, string, string, string, time.Duration) error { return nil }
+func (nopCodeRepo) Verify(context.Context, string, string, string, int) (bool, error) {
+	return false, nil
+}
+
+type nopSmsSender struct{}
+
+func (nopSmsSender) SendCode(context.Context, string, string, string, time.Duration) error {
+	return nil
+}
+
+// 每次请求换一个伪造的 X-Forwarded-For, 仍按真实来源计数
+func TestSendVerificationCode_SpoofedXFFDoesNotResetIPLimit(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		proxies []string
+		remote  string
+		forward func(i int) string
+	}{
+		{"direct", nil, "203.0.113.7", func(i int) string {
+			return net.IPv4(198, 51, 100, byte(i)).String()
+		}},
+		{"behind proxy", []string{"10.0.0.0/8"}, "10.0.0.2", func(i int) string {
+			return net.IPv4(198, 51, 100, byte(i)).String() + ", 203.0.113.7"
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &ipLimiter{limit: 3, counts: map[string]int{}}
+			s := newTestUserService(t, tt.proxies...)
+			s.vc = biz.NewVerificationUsecase(&biz.VerificationPolicy{}, nopCodeRepo{}, nopSmsSender{}, limiter)
+
+			var err error
+			for i := 1; i <= 4; i++ {
+				ctx := requestContext(tt.remote, fakeHeader{"X-Forwarded-For": {tt.forward(i)}})
+				_, err = s.SendVerificationCode(ctx, &v1.SendVerificationCodeRequest{
+					Purpose:     biz.CodePurposeLogin,
+					PhoneNumber: fmt.Sprintf("138000000%02d", i),
+				})
+			}
+			if !errors.Is(err, biz.ErrSmsHourlyLimitExceeded) {
+				t.Errorf("4th request error = %v, want ErrSmsHourlyLimitExceeded", err)
+			}
+			if n := limiter.counts["203.0.113.7"]; n != 3 {
+				t.Errorf("count for 203.0.113.7 = %d, want 3", n)
+			}
+		})
+	}
+}
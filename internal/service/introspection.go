@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+)
+
+// IntrospectionService 是令牌自省服务, 只对内部服务开放
+type IntrospectionService struct {
+	v1.UnimplementedIntrospectionServer
+	uc *biz.IntrospectionUsecase
+}
+
+// NewIntrospectionService 创建令牌自省服务
+func NewIntrospectionService(uc *biz.IntrospectionUsecase) *IntrospectionService {
+	return &IntrospectionService{uc: uc}
+}
+
+// Introspect 查询访问令牌是否有效, 无效的令牌不返回错误, 只返回 active = false
+func (s *IntrospectionService) Introspect(ctx context.Context, req *v1.IntrospectRequest) (*v1.IntrospectReply, error) {
+	claims, ok := s.uc.Introspect(ctx, req.GetToken())
+	if !ok {
+		return &v1.IntrospectReply{Active: false}, nil
+	}
+	return &v1.IntrospectReply{
+		Active:   true,
+		Sub:      claims.Subject,
+		Username: claims.Username,
+		Scopes:   claims.Scopes(),
+		Exp:      claims.ExpiresAt.Unix(),
+		Iat:      claims.IssuedAt.Unix(),
+		Sid:      claims.SessionID,
+		Jti:      claims.ID,
+		Iss:      claims.Issuer,
+		Aud:      claims.Audience,
+	}, nil
+}
@@ -2,26 +2,39 @@ package service
 
 import (
 	"context"
-	"fmt"
+	"strconv"
 
 	"github.com/YangZhaoWeblog/GoldenTakin/takin_log"
 	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
 	"github.com/YangZhaoWeblog/UserService/internal/biz"
-	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/YangZhaoWeblog/UserService/internal/conf"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // UserService 是用户服务
 type UserService struct {
 	v1.UnimplementedUserServer
 	uc        *biz.UserUsecase
+	vc        *biz.VerificationUsecase
+	ev        *biz.EmailVerificationUsecase
+	proxies   trustedProxies
 	logHelper *takin_log.TakinLogger
 }
 
 // NewUserService 创建用户服务
-func NewUserService(uc *biz.UserUsecase, log *takin_log.TakinLogger) *UserService {
+func NewUserService(c *conf.Server, uc *biz.UserUsecase, vc *biz.VerificationUsecase, ev *biz.EmailVerificationUsecase,
+	log *takin_log.TakinLogger,
+) (*UserService, error) {
+	proxies, err := newTrustedProxies(c)
+	if err != nil {
+		return nil, err
+	}
 	return &UserService{uc: uc,
+		vc:        vc,
+		ev:        ev,
+		proxies:   proxies,
 		logHelper: log,
-	}
+	}, nil
 }
 
 func getAuthTypeString(user *biz.User, req *v1.RegisterRequest) *biz.User {
@@ -32,12 +45,17 @@ func getAuthTypeString(user *biz.User, req *v1.RegisterRequest) *biz.User {
 			Number:           req.GetPhone().GetPhoneNumber(),
 			VerificationCode: req.GetPhone().GetVerificationCode(),
 		}
+		user.Password = req.GetPhone().GetPassword()
+	} else if req.GetEmail() != nil {
+		user.AuthType = biz.AuthTypeEmail
+		user.Email = req.GetEmail().GetEmail()
+		user.Password = req.GetEmail().GetPassword()
 	} else if req.GetGoogle() != nil {
 		user.AuthType = biz.AuthTypeGoogle
-		user.Phone = biz.Phone{
-			Number:           req.GetPhone().GetPhoneNumber(),
-			VerificationCode: req.GetPhone().GetVerificationCode(),
-		}
+		user.IDToken = req.GetGoogle().GetIdToken()
+	} else if oidc := req.GetOidc(); oidc != nil && !biz.IsBuiltinAuthType(oidc.GetProvider()) {
+		user.AuthType = oidc.GetProvider()
+		user.IDToken = oidc.GetIdToken()
 	}
 	return user
 }
@@ -46,45 +64,333 @@ func getAuthTypeString(user *biz.User, req *v1.RegisterRequest) *biz.User {
 func (s *UserService) Register(ctx context.Context, req *v1.RegisterRequest) (*v1.RegisterReply, error) {
 	user := biz.User{
 		Nickname: req.GetNickname(),
+		Avatar:   req.GetAvatarUrl(),
 	}
 	getAuthTypeString(&user, req)
 
-	// 1. 注册
+	// 1. 注册, 注册成功即登录, 同样记录会话
+	ctx = biz.NewClientContext(ctx, s.clientInfo(ctx, ""))
 	createdUser, err := s.uc.CreateUser(ctx, &user)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 
+	identities, err := s.uc.ListIdentities(ctx, createdUser.ID)
+	if err != nil {
+		return nil, err
+	}
 	return &v1.RegisterReply{
-		UserInfo: &v1.UserInfo{
-			UserId:   fmt.Sprintf("%d", user.ID),
-			Nickname: createdUser.Nickname,
-		},
-		AuthToken: &v1.AuthToken{
-			AccessToken:  createdUser.AuthToken.AccessToken,
-			RefreshToken: createdUser.AuthToken.RefreshToken,
-			ExpiresIn:    createdUser.AuthToken.ExpiresIn,
-			TokenType:    "Bearer",
-		},
+		Success:   true,
+		Message:   "注册成功",
+		UserInfo:  toUserInfo(createdUser, identities),
+		AuthToken: toAuthToken(createdUser.AuthToken),
 	}, nil
 }
 
 // Login 实现登录接口
 func (s *UserService) Login(ctx context.Context, req *v1.LoginRequest) (*v1.LoginReply, error) {
-	// TODO: 实现登录逻辑
+	var (
+		user *biz.User
+		err  error
+	)
+	ctx = biz.NewClientContext(ctx, s.clientInfo(ctx, req.GetDeviceId()))
+	switch {
+	case req.GetPhone() != nil:
+		phone := req.GetPhone()
+		switch phone.GetVerification().(type) {
+		case *v1.PhoneLogin_Password:
+			user, err = s.uc.LoginWithPassword(ctx, phone.GetPhoneNumber(), phone.GetPassword())
+		case *v1.PhoneLogin_VerificationCode:
+			user, err = s.uc.LoginWithCode(ctx, phone.GetPhoneNumber(), phone.GetVerificationCode())
+		default:
+			return nil, biz.ErrInvalidCredentials
+		}
+	case req.GetEmail() != nil:
+		user, err = s.uc.LoginWithEmail(ctx, req.GetEmail().GetEmail(), req.GetEmail().GetPassword())
+	case req.GetGoogle() != nil:
+		user, err = s.uc.LoginWithProvider(ctx, biz.AuthTypeGoogle, req.GetGoogle().GetIdToken())
+	case req.GetOidc() != nil:
+		user, err = s.uc.LoginWithProvider(ctx, req.GetOidc().GetProvider(), req.GetOidc().GetIdToken())
+	default:
+		return nil, biz.ErrInvalidIdentity
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	//s.logHelper.Info("测试:", "Login", "是否", "成功")
-	return nil, errors.New(521, "LOGIN_FAILED", "登录失败")
+	identities, err := s.uc.ListIdentities(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.LoginReply{
+		Success:   true,
+		Message:   "登录成功",
+		UserInfo:  toUserInfo(user, identities),
+		AuthToken: toAuthToken(user.AuthToken),
+	}, nil
+}
+
+// RefreshToken 实现刷新令牌接口
+func (s *UserService) RefreshToken(ctx context.Context, req *v1.RefreshTokenRequest) (*v1.RefreshTokenReply, error) {
+	ctx = biz.NewClientContext(ctx, s.clientInfo(ctx, ""))
+	user, err := s.uc.RefreshToken(ctx, req.GetRefreshToken())
+	if err != nil {
+		return nil, err
+	}
+	return &v1.RefreshTokenReply{
+		Success:   true,
+		Message:   "刷新成功",
+		AuthToken: toAuthToken(user.AuthToken),
+	}, nil
+}
+
+// Logout 实现注销接口
+func (s *UserService) Logout(ctx context.Context, _ *v1.LogoutRequest) (*v1.LogoutReply, error) {
+	claims, ok := biz.AuthFromContext(ctx)
+	if !ok {
+		return nil, biz.ErrInvalidAccessToken
+	}
+	if err := s.uc.Logout(ctx, claims); err != nil {
+		return nil, err
+	}
+	return &v1.LogoutReply{
+		Success: true,
+		Message: "已退出登录",
+	}, nil
+}
 
-	// 不可达代码
-	//return &v1.LoginReply{
-	//	Success: true,
-	//	Message: "登录成功",
-	//}, nil
+// LogoutAll 实现注销所有会话接口
+func (s *UserService) LogoutAll(ctx context.Context, _ *v1.LogoutAllRequest) (*v1.LogoutAllReply, error) {
+	claims, ok := biz.AuthFromContext(ctx)
+	if !ok {
+		return nil, biz.ErrInvalidAccessToken
+	}
+	if err := s.uc.LogoutAll(ctx, claims); err != nil {
+		return nil, err
+	}
+	return &v1.LogoutAllReply{
+		Success: true,
+		Message: "已退出所有设备",
+	}, nil
+}
+
+// SendVerificationCode 实现发送验证码接口
+func (s *UserService) SendVerificationCode(ctx context.Context, req *v1.SendVerificationCodeRequest) (*v1.SendVerificationCodeReply, error) {
+	ttl, err := s.vc.SendCode(ctx, req.GetPurpose(), req.GetPhoneNumber(), s.clientInfo(ctx, req.GetDeviceId()))
+	if err != nil {
+		return nil, err
+	}
+	return &v1.SendVerificationCodeReply{
+		Success:   true,
+		Message:   "验证码已发送",
+		ExpiresIn: int64(ttl.Seconds()),
+	}, nil
+}
+
+// VerifyEmail 实现邮箱验证接口
+func (s *UserService) VerifyEmail(ctx context.Context, req *v1.VerifyEmailRequest) (*v1.VerifyEmailReply, error) {
+	if err := s.ev.VerifyEmail(ctx, req.GetToken()); err != nil {
+		return nil, err
+	}
+	return &v1.VerifyEmailReply{
+		Success: true,
+		Message: "邮箱已验证",
+	}, nil
+}
+
+// ResendVerificationEmail 实现重发验证邮件接口
+func (s *UserService) ResendVerificationEmail(ctx context.Context, _ *v1.ResendVerificationEmailRequest) (*v1.ResendVerificationEmailReply, error) {
+	id, err := currentUserID(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ev.Resend(ctx, id); err != nil {
+		return nil, err
+	}
+	return &v1.ResendVerificationEmailReply{
+		Success: true,
+		Message: "验证邮件已发送",
+	}, nil
+}
+
+// ListSessions 实现会话列表接口
+func (s *UserService) ListSessions(ctx context.Context, _ *v1.ListSessionsRequest) (*v1.ListSessionsReply, error) {
+	id, err := currentUserID(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := s.uc.ListSessions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, _ := biz.AuthFromContext(ctx)
+	reply := &v1.ListSessionsReply{
+		Success:  true,
+		Message:  "获取成功",
+		Sessions: make([]*v1.Session, 0, len(sessions)),
+	}
+	for _, sess := range sessions {
+		reply.Sessions = append(reply.Sessions, &v1.Session{
+			SessionId:  sess.ID,
+			DeviceId:   sess.DeviceID,
+			UserAgent:  sess.UserAgent,
+			Ip:         sess.IP,
+			CreatedAt:  timestamppb.New(sess.CreatedAt),
+			LastSeenAt: timestamppb.New(sess.LastSeenAt),
+			Current:    sess.ID == claims.SessionID,
+		})
+	}
+	return reply, nil
+}
+
+// RevokeSession 实现注销会话接口
+func (s *UserService) RevokeSession(ctx context.Context, req *v1.RevokeSessionRequest) (*v1.RevokeSessionReply, error) {
+	id, err := currentUserID(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.uc.RevokeSession(ctx, id, req.GetSessionId()); err != nil {
+		return nil, err
+	}
+	return &v1.RevokeSessionReply{
+		Success: true,
+		Message: "会话已注销",
+	}, nil
 }
 
 // Info 实现获取用户信息接口
 func (s *UserService) Info(ctx context.Context, req *v1.InfoRequest) (*v1.InfoReply, error) {
-	// TODO: 实现获取用户信息逻辑
-	return &v1.InfoReply{}, nil
+	id, err := currentUserID(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := s.userInfo(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.InfoReply{
+		Success:  true,
+		Message:  "ok",
+		UserInfo: info,
+	}, nil
+}
+
+// LinkIdentity 实现绑定登录方式接口
+func (s *UserService) LinkIdentity(ctx context.Context, req *v1.LinkIdentityRequest) (*v1.LinkIdentityReply, error) {
+	id, err := currentUserID(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case req.GetPhone() != nil:
+		_, err = s.uc.LinkPhone(ctx, id, req.GetPhone().GetPhoneNumber(), req.GetPhone().GetVerificationCode())
+	case req.GetGoogle() != nil:
+		_, err = s.uc.LinkProvider(ctx, id, biz.AuthTypeGoogle, req.GetGoogle().GetIdToken())
+	case req.GetOidc() != nil:
+		_, err = s.uc.LinkProvider(ctx, id, req.GetOidc().GetProvider(), req.GetOidc().GetIdToken())
+	default:
+		return nil, biz.ErrInvalidIdentity
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := s.userInfo(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.LinkIdentityReply{
+		Success:  true,
+		Message:  "绑定成功",
+		UserInfo: info,
+	}, nil
+}
+
+// UnlinkIdentity 实现解绑登录方式接口
+func (s *UserService) UnlinkIdentity(ctx context.Context, req *v1.UnlinkIdentityRequest) (*v1.UnlinkIdentityReply, error) {
+	id, err := currentUserID(ctx, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.uc.UnlinkIdentity(ctx, id, req.GetProvider(), req.GetSubject()); err != nil {
+		return nil, err
+	}
+
+	info, err := s.userInfo(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.UnlinkIdentityReply{
+		Success:  true,
+		Message:  "解绑成功",
+		UserInfo: info,
+	}, nil
+}
+
+// currentUserID 返回当前登录用户的 ID
+// 请求中的 user_id 为空表示当前用户, 不为空时必须与当前用户一致
+func currentUserID(ctx context.Context, requested string) (int64, error) {
+	claims, ok := biz.AuthFromContext(ctx)
+	if !ok {
+		return 0, biz.ErrInvalidAccessToken
+	}
+	if requested != "" && requested != claims.UserID {
+		return 0, biz.ErrPermissionDenied
+	}
+	id, err := strconv.ParseInt(claims.UserID, 10, 64)
+	if err != nil {
+		return 0, biz.ErrInvalidAccessToken
+	}
+	return id, nil
+}
+
+// userInfo 查询用户及其登录方式, 组装接口返回的用户信息
+func (s *UserService) userInfo(ctx context.Context, id int64) (*v1.UserInfo, error) {
+	user, err := s.uc.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := s.uc.ListIdentities(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return toUserInfo(user, identities), nil
+}
+
+// toAuthToken 把领域模型转换为接口返回的令牌
+func toAuthToken(t biz.AuthToken) *v1.AuthToken {
+	return &v1.AuthToken{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		ExpiresIn:    t.ExpiresIn,
+		TokenType:    t.TokenType,
+	}
+}
+
+// toUserInfo 把领域模型转换为接口返回的用户信息
+func toUserInfo(u *biz.User, identities []*biz.Identity) *v1.UserInfo {
+	info := &v1.UserInfo{
+		UserId:        strconv.FormatInt(u.ID, 10),
+		Nickname:      u.Nickname,
+		AvatarUrl:     u.Avatar,
+		AuthMethods:   biz.AuthMethods(u, identities),
+		PhoneNumber:   u.Phone.Number,
+		EmailVerified: u.EmailVerified,
+		CreatedAt:     u.CreatedAt.Unix(),
+		UpdatedAt:     u.UpdatedAt.Unix(),
+	}
+	// 优先展示邮箱登录方式, 其次是第三方账号提供的邮箱
+	for _, id := range identities {
+		switch {
+		case id.Provider == biz.AuthTypeEmail:
+			info.Email = id.Subject
+		case info.Email == "" && id.Email != "":
+			info.Email = id.Email
+		}
+	}
+	return info
 }
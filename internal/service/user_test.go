@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	v1 "github.com/YangZhaoWeblog/UserService/api/user/v1"
+	"github.com/YangZhaoWeblog/UserService/internal/biz"
+	"github.com/YangZhaoWeblog/UserService/internal/pkg"
+)
+
+// 绑定与解绑只能操作当前登录用户, 在访问用例之前就拒绝
+func TestUserService_IdentityRequiresCurrentUser(t *testing.T) {
+	s := &UserService{}
+	anonymous := context.Background()
+	other := biz.NewAuthContext(context.Background(), &pkg.CustomClaims{UserID: "1"})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		call func(ctx context.Context) error
+		want error
+	}{
+		{"link anonymous", anonymous, func(ctx context.Context) error {
+			_, err := s.LinkIdentity(ctx, &v1.LinkIdentityRequest{})
+			return err
+		}, biz.ErrInvalidAccessToken},
+		{"link other user", other, func(ctx context.Context) error {
+			_, err := s.LinkIdentity(ctx, &v1.LinkIdentityRequest{UserId: "2"})
+			return err
+		}, biz.ErrPermissionDenied},
+		{"unlink anonymous", anonymous, func(ctx context.Context) error {
+			_, err := s.UnlinkIdentity(ctx, &v1.UnlinkIdentityRequest{Provider: "google", Subject: "s"})
+			return err
+		}, biz.ErrInvalidAccessToken},
+		{"unlink other user", other, func(ctx context.Context) error {
+			_, err := s.UnlinkIdentity(ctx, &v1.UnlinkIdentityRequest{UserId: "2", Provider: "google", Subject: "s"})
+			return err
+		}, biz.ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(tt.ctx); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}